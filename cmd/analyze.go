@@ -35,7 +35,7 @@ var analyzeCmd = &cobra.Command{
 
 For details on how to specify images, run: container-diff help`,
 	Args: func(cmd *cobra.Command, args []string) error {
-		if err := validateArgs(args, checkAnalyzeArgNum, checkIfValidAnalyzer); err != nil {
+		if err := validateArgs(args, checkAnalyzeArgNum, checkIfValidAnalyzer, checkPlatformFlag, checkPathFilterFlags); err != nil {
 			return err
 		}
 		return nil
@@ -92,6 +92,7 @@ func analyzeImage(imageName string, analyzerArgs []string) error {
 }
 
 func init() {
+	analyzeCmd.Flags().BoolVar(&pkgutil.Provenance, "provenance", false, "Set this flag to report the layer that last wrote each file analyzed with --type=file, and the layers that overwrote or deleted it.")
 	RootCmd.AddCommand(analyzeCmd)
 	addSharedFlags(analyzeCmd)
 	output.AddFlags(analyzeCmd)
@@ -31,7 +31,7 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var filename string
+var filenames multiValueFlag
 
 var diffCmd = &cobra.Command{
 	Use:   "diff image1 image2",
@@ -40,7 +40,7 @@ var diffCmd = &cobra.Command{
 
 For details on how to specify images, run: container-diff help`,
 	Args: func(cmd *cobra.Command, args []string) error {
-		if err := validateArgs(args, checkDiffArgNum, checkIfValidAnalyzer, checkFilenameFlag); err != nil {
+		if err := validateArgs(args, checkDiffArgNum, checkFilenameFlag, checkIfValidAnalyzer, checkPlatformFlag, checkMoveSimilarityFlag, checkArchiveDepthFlag, checkSummaryDepthFlag, checkPathFilterFlags); err != nil {
 			return err
 		}
 		return nil
@@ -61,7 +61,12 @@ func checkDiffArgNum(args []string) error {
 }
 
 func checkFilenameFlag(_ []string) error {
-	if filename == "" {
+	if len(filenames) == 0 {
+		return nil
+	}
+	// the file analyzer extracts the file systems the diffs are taken from
+	if len(types) == 0 {
+		types = []string{"file"}
 		return nil
 	}
 	for _, t := range types {
@@ -72,9 +77,30 @@ func checkFilenameFlag(_ []string) error {
 	return errors.New("please include --type=file with the --filename flag")
 }
 
+func checkMoveSimilarityFlag(_ []string) error {
+	if util.MoveSimilarity < 0 || util.MoveSimilarity > 100 {
+		return errors.New("--move-similarity must be a percentage between 0 and 100")
+	}
+	return nil
+}
+
+func checkSummaryDepthFlag(_ []string) error {
+	if util.SummaryDepth < 0 {
+		return errors.New("--summary-depth can't be negative")
+	}
+	return nil
+}
+
+func checkArchiveDepthFlag(_ []string) error {
+	if util.ArchiveDepth < 0 {
+		return errors.New("--archive-depth can't be negative")
+	}
+	return nil
+}
+
 // processImage is a concurrency-friendly wrapper around getImageForName
-func processImage(imageName string, errChan chan<- error) *pkgutil.Image {
-	image, err := getImage(imageName)
+func processImage(imageName string, requires pkgutil.FSRequirement, errChan chan<- error) *pkgutil.Image {
+	image, err := getImageWithRequirements(imageName, requires)
 	if err != nil {
 		errChan <- fmt.Errorf("error retrieving image %s: %s", imageName, err)
 	}
@@ -106,30 +132,48 @@ func diffImages(image1Arg, image2Arg string, diffArgs []string) error {
 
 	logrus.Infof("starting diff on images %s and %s, using differs: %s\n", image1Arg, image2Arg, diffArgs)
 
+	// when no analyzer needs more, only the paths touched after the layers
+	// the images share are unpacked, once both images are retrieved. Disk
+	// usage can't be read from the layers for the directories left out.
+	requires := getRequirements()
+	changedOnly := requires&pkgutil.RequireChangedFS != 0 && requires&pkgutil.RequireRootFS == 0 && len(filenames) == 0 && !pkgutil.SizeOnDisk
+	if changedOnly {
+		requires &^= pkgutil.RequireChangedFS
+	}
+
 	var image1, image2 *pkgutil.Image
 	errChan := make(chan error, 2)
 
 	go func() {
 		defer wg.Done()
-		image1 = processImage(image1Arg, errChan)
+		image1 = processImage(image1Arg, requires, errChan)
 	}()
 	go func() {
 		defer wg.Done()
-		image2 = processImage(image2Arg, errChan)
+		image2 = processImage(image2Arg, requires, errChan)
 	}()
 
 	wg.Wait()
 	close(errChan)
 
 	if noCache && !save {
-		defer pkgutil.CleanupImage(*image1)
-		defer pkgutil.CleanupImage(*image2)
+		// the filesystems may only be unpacked below
+		defer func() {
+			pkgutil.CleanupImage(*image1)
+			pkgutil.CleanupImage(*image2)
+		}()
 	}
 
 	if err := readErrorsFromChannel(errChan); err != nil {
 		return err
 	}
 
+	if changedOnly {
+		if err := getChangedFileSystems(image1Arg, image2Arg, image1, image2); err != nil {
+			return errors.Wrap(err, "getting changed filesystems")
+		}
+	}
+
 	logrus.Info("computing diffs")
 	req := differs.DiffRequest{
 		Image1:    *image1,
@@ -139,15 +183,20 @@ func diffImages(image1Arg, image2Arg string, diffArgs []string) error {
 	if err != nil {
 		return fmt.Errorf("could not retrieve diff: %s", err)
 	}
-	outputResults(diffs)
-
-	if filename != "" {
+	if len(filenames) > 0 {
 		logrus.Info("computing filename diffs")
-		err := diffFile(image1, image2)
+		fileDiffs, err := util.DiffFiles(image1, image2, filenames)
 		if err != nil {
 			return err
 		}
+		diffs["filename"] = &util.FileNameDiffResult{
+			Image1:   image1.Source,
+			Image2:   image2.Source,
+			DiffType: "Filename",
+			Diff:     fileDiffs,
+		}
 	}
+	outputResults(diffs)
 
 	if noCache && save {
 		logrus.Infof("images were saved at %s and %s", image1.FSPath,
@@ -156,25 +205,29 @@ func diffImages(image1Arg, image2Arg string, diffArgs []string) error {
 	return nil
 }
 
-func diffFile(image1, image2 *pkgutil.Image) error {
-	diff, err := util.DiffFile(image1, image2, filename)
-	if err != nil {
-		return err
-	}
-	writer, err := getWriter(outputFile)
-	if err != nil {
-		return err
-	}
-	util.TemplateOutput(writer, diff, "FilenameDiff")
-	if err != nil {
-		logrus.Error(err)
-		return err
+// getChangedFileSystems unpacks the paths of both filesystems that differ,
+// caching them along with the rest of each image
+func getChangedFileSystems(image1Arg, image2Arg string, image1, image2 *pkgutil.Image) error {
+	var cacheDir1, cacheDir2 string
+	if !noCache {
+		var err error
+		if cacheDir1, err = getCacheDir(image1Arg); err != nil {
+			return err
+		}
+		if cacheDir2, err = getCacheDir(image2Arg); err != nil {
+			return err
+		}
 	}
-	return nil
+	return pkgutil.GetChangedFileSystems(image1, image2, cacheDir1, cacheDir2)
 }
 
 func init() {
-	diffCmd.Flags().StringVarP(&filename, "filename", "f", "", "Set this flag to the path of a file in both containers to view the diff of the file. Must be used with --type=file flag.")
+	diffCmd.Flags().VarP(&filenames, "filename", "f", "Set this flag to the path of a file to view its diff between the containers. A directory or a glob, e.g. '/etc/**/*.conf', diffs every file that changed under it. Set it repeatedly to diff several paths. Implies --type=file if no --type is set.")
+	diffCmd.Flags().BoolVar(&util.StructuredDiff, "structured-diff", false, "Diff the JSON, YAML, TOML, INI and properties files given with --filename key by key, falling back to a text diff if they can't be parsed.")
+	diffCmd.Flags().BoolVar(&util.HexDump, "hex-dump", false, "Diff the hex dumps of binary files given with --filename, rather than only reporting whether they differ.")
+	diffCmd.Flags().IntVar(&util.MoveSimilarity, "move-similarity", 0, "Also report deleted and added text files sharing at least this percentage of lines as moves. Files with identical contents are always reported as moves.")
+	diffCmd.Flags().IntVar(&util.ArchiveDepth, "archive-depth", 0, "Diff the entries of modified tar, zip, jar, war, ear and wheel files down to this many levels of nested archives, reporting them as archive!/path. 0 compares archives as whole files.")
+	diffCmd.Flags().IntVar(&util.SummaryDepth, "summary-depth", 0, "Also summarize the bytes added, deleted and modified by the file diff per directory, down to this many levels below /. Directories are ordered by name, or by the absolute change in size with --order.")
 	RootCmd.AddCommand(diffCmd)
 	addSharedFlags(diffCmd)
 	output.AddFlags(diffCmd)
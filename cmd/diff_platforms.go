@@ -0,0 +1,210 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/EyeCantCU/container-diff/cmd/util/output"
+	"github.com/EyeCantCU/container-diff/differs"
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+	"github.com/EyeCantCU/container-diff/util"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var diffPlatformsCmd = &cobra.Command{
+	Use:   "diff-platforms image",
+	Short: "Compare the platforms of a multi-arch image: container-diff diff-platforms image",
+	Long: `Compares the images for each platform of a manifest list or OCI index using the specifed analyzers as indicated via --type flag(s).
+
+Every pair of platforms is compared, unless --platform is set, in which case that platform is compared against each of the others.
+
+For details on how to specify images, run: container-diff help`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if err := validateArgs(args, checkDiffPlatformsArgNum, checkIfValidAnalyzer, checkPlatformFlag, checkPathFilterFlags); err != nil {
+			return err
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := diffPlatforms(args[0], types); err != nil {
+			logrus.Error(err)
+			os.Exit(1)
+		}
+	},
+}
+
+// PlatformDiff groups the diff results for one pair of platforms of an image
+type PlatformDiff struct {
+	Platform1 string
+	Platform2 string
+	Diffs     map[string]util.Result
+}
+
+func checkDiffPlatformsArgNum(args []string) error {
+	if len(args) != 1 {
+		return errors.New("'diff-platforms' requires one image as an argument: container-diff diff-platforms [image]")
+	}
+	return nil
+}
+
+// platformPairs returns the pairs of platforms to diff: each platform against
+// the reference, if one is given, or otherwise every pair of platforms.
+func platformPairs(platforms []v1.Platform, reference *v1.Platform) ([][2]v1.Platform, error) {
+	var pairs [][2]v1.Platform
+	if reference == nil {
+		for i := range platforms {
+			for j := i + 1; j < len(platforms); j++ {
+				pairs = append(pairs, [2]v1.Platform{platforms[i], platforms[j]})
+			}
+		}
+		return pairs, nil
+	}
+
+	refIndex := -1
+	for i, p := range platforms {
+		if p.Satisfies(*reference) {
+			refIndex = i
+			break
+		}
+	}
+	if refIndex < 0 {
+		return nil, fmt.Errorf("reference platform %s not found in image", reference.String())
+	}
+	for i, p := range platforms {
+		if i != refIndex {
+			pairs = append(pairs, [2]v1.Platform{platforms[refIndex], p})
+		}
+	}
+	return pairs, nil
+}
+
+func diffPlatforms(imageArg string, diffArgs []string) error {
+	diffTypes, err := differs.GetAnalyzers(diffArgs)
+	if err != nil {
+		return errors.Wrap(err, "getting analyzers")
+	}
+
+	platforms, err := pkgutil.GetImagePlatforms(imageArg)
+	if err != nil {
+		return errors.Wrapf(err, "retrieving platforms of %s", imageArg)
+	}
+	if len(platforms) < 2 {
+		return fmt.Errorf("%s has %d platform(s), nothing to compare", imageArg, len(platforms))
+	}
+	reference, err := getPlatform()
+	if err != nil {
+		return err
+	}
+	pairs, err := platformPairs(platforms, reference)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("starting diff on platforms of %s, using differs: %s\n", imageArg, diffArgs)
+
+	// retrieve each platform's image once, as it may take part in several pairs
+	requires := getRequirements()
+	images := map[string]pkgutil.Image{}
+	for _, pair := range pairs {
+		for _, p := range pair {
+			p := p
+			if _, ok := images[p.String()]; ok {
+				continue
+			}
+			image, err := getImageForPlatform(imageArg, &p, requires)
+			if noCache && !save {
+				defer pkgutil.CleanupImage(image)
+			}
+			if err != nil {
+				return fmt.Errorf("error retrieving image %s for platform %s: %s", imageArg, p.String(), err)
+			}
+			images[p.String()] = image
+		}
+	}
+
+	logrus.Info("computing diffs")
+	var diffs []PlatformDiff
+	for _, pair := range pairs {
+		p1, p2 := pair[0].String(), pair[1].String()
+		req := differs.DiffRequest{
+			Image1:    images[p1],
+			Image2:    images[p2],
+			DiffTypes: diffTypes}
+		results, err := req.GetDiff()
+		if err != nil {
+			return fmt.Errorf("could not retrieve diff between %s and %s: %s", p1, p2, err)
+		}
+		diffs = append(diffs, PlatformDiff{
+			Platform1: p1,
+			Platform2: p2,
+			Diffs:     results,
+		})
+	}
+	if err := outputPlatformResults(diffs); err != nil {
+		return err
+	}
+
+	if noCache && save {
+		for p, image := range images {
+			logrus.Infof("image for platform %s was saved at %s", p, image.FSPath)
+		}
+	}
+	return nil
+}
+
+// outputPlatformResults outputs the results of each pair of platforms under a
+// header naming both platforms
+func outputPlatformResults(diffs []PlatformDiff) error {
+	writer, err := getWriter(outputFile)
+	if err != nil {
+		return errors.Wrap(err, "getting writer for output file")
+	}
+
+	type platformOutput struct {
+		Platform1 string
+		Platform2 string
+		Diffs     []interface{}
+	}
+	var outputs []platformOutput
+	for _, diff := range diffs {
+		if !json {
+			fmt.Fprintf(writer, "\n=====%s vs %s=====\n", diff.Platform1, diff.Platform2)
+		}
+		outputs = append(outputs, platformOutput{
+			Platform1: diff.Platform1,
+			Platform2: diff.Platform2,
+			Diffs:     writeResults(writer, diff.Diffs),
+		})
+	}
+	if json {
+		if err := util.JSONify(writer, outputs); err != nil {
+			logrus.Error(err)
+		}
+	}
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(diffPlatformsCmd)
+	addSharedFlags(diffPlatformsCmd)
+	output.AddFlags(diffPlatformsCmd)
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+var diffPlatformsArgNumTests = []testpair{
+	{[]string{}, true},
+	{[]string{"one"}, false},
+	{[]string{"one", "two"}, true},
+}
+
+func TestDiffPlatformsArgNum(t *testing.T) {
+	for _, test := range diffPlatformsArgNumTests {
+		err := checkDiffPlatformsArgNum(test.input)
+		checkError(t, err, test.shouldError)
+	}
+}
+
+func TestPlatformPairs(t *testing.T) {
+	amd64 := v1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	armv7 := v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}
+	platforms := []v1.Platform{amd64, arm64, armv7}
+
+	tests := []struct {
+		name        string
+		reference   *v1.Platform
+		expected    [][2]v1.Platform
+		shouldError bool
+	}{
+		{
+			name:      "all pairs",
+			reference: nil,
+			expected:  [][2]v1.Platform{{amd64, arm64}, {amd64, armv7}, {arm64, armv7}},
+		},
+		{
+			name:      "reference platform",
+			reference: &v1.Platform{OS: "linux", Architecture: "arm64"},
+			expected:  [][2]v1.Platform{{arm64, amd64}, {arm64, armv7}},
+		},
+		{
+			name:        "missing reference platform",
+			reference:   &v1.Platform{OS: "windows", Architecture: "amd64"},
+			shouldError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pairs, err := platformPairs(platforms, test.reference)
+			checkError(t, err, test.shouldError)
+			if !reflect.DeepEqual(pairs, test.expected) {
+				t.Errorf("expected: %v\ngot: %v", test.expected, pairs)
+			}
+		})
+	}
+}
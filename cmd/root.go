@@ -28,6 +28,7 @@ import (
 	"github.com/EyeCantCU/container-diff/differs"
 	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
 	"github.com/EyeCantCU/container-diff/util"
+	"github.com/google/go-containerregistry/pkg/v1"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -46,7 +47,11 @@ var forceWrite bool
 var cacheDir string
 var LogLevel string
 var format string
+var platform string
 var skipTsVerifyRegistries multiValueFlag
+var includePaths multiValueFlag
+var excludePaths multiValueFlag
+var ignoreFile string
 var registriesCertificates keyValueFlag
 
 const containerDiffEnvCacheDir = "CONTAINER_DIFF_CACHEDIR"
@@ -63,7 +68,8 @@ To specify a local image, prefix the image ID with 'daemon://', e.g. 'daemon://g
 To specify a remote image, prefix the image ID with 'remote://', e.g. 'remote://gcr.io/foo/bar'.
 If no prefix is specified, the local daemon will be checked first.
 
-Tarballs can also be specified by simply providing the path to the .tar, .tar.gz, or .tgz file.`,
+Tarballs can also be specified by simply providing the path to the .tar, .tar.gz, or .tgz file.
+OCI image layout directories can be specified with the 'oci://' prefix, e.g. 'oci://path/to/layout:tag' or 'oci://path/to/layout@sha256:...'.`,
 	PersistentPreRun: func(c *cobra.Command, s []string) {
 		ll, err := logrus.ParseLevel(LogLevel)
 		if err != nil {
@@ -76,19 +82,31 @@ Tarballs can also be specified by simply providing the path to the .tar, .tar.gz
 }
 
 func outputResults(resultMap map[string]util.Result) {
-	// Outputs diff/analysis results in alphabetical order by analyzer name
-	sortedTypes := []string{}
-	for analyzerType := range resultMap {
-		sortedTypes = append(sortedTypes, analyzerType)
-	}
-	sort.Strings(sortedTypes)
-
 	// Get the writer
 	writer, err := getWriter(outputFile)
 	if err != nil {
 		errors.Wrap(err, "getting writer for output file")
 	}
 
+	results := writeResults(writer, resultMap)
+	if json {
+		err := util.JSONify(writer, results)
+		if err != nil {
+			logrus.Error(err)
+		}
+	}
+}
+
+// writeResults outputs diff/analysis results in alphabetical order by analyzer name.
+// Text results are written out directly, while JSON results are returned for
+// the caller to serialize.
+func writeResults(writer io.Writer, resultMap map[string]util.Result) []interface{} {
+	sortedTypes := []string{}
+	for analyzerType := range resultMap {
+		sortedTypes = append(sortedTypes, analyzerType)
+	}
+	sort.Strings(sortedTypes)
+
 	results := make([]interface{}, len(resultMap))
 	for i, analyzerType := range sortedTypes {
 		result := resultMap[analyzerType]
@@ -101,12 +119,7 @@ func outputResults(resultMap map[string]util.Result) {
 			}
 		}
 	}
-	if json {
-		err := util.JSONify(writer, results)
-		if err != nil {
-			logrus.Error(err)
-		}
-	}
+	return results
 }
 
 func validateArgs(args []string, validatefxns ...validatefxn) error {
@@ -130,18 +143,67 @@ func checkIfValidAnalyzer(_ []string) error {
 	return nil
 }
 
-func includeLayers() bool {
-	for _, t := range types {
-		for _, a := range differs.LayerAnalyzers {
-			if t == a {
-				return true
+func checkPlatformFlag(_ []string) error {
+	_, err := getPlatform()
+	return err
+}
+
+// checkPathFilterFlags builds the filter of the paths to extract and analyze
+// from --include-path, --exclude-path and the ignore file
+func checkPathFilterFlags(_ []string) error {
+	filter, err := pkgutil.NewPathFilter(includePaths, excludePaths)
+	if err != nil {
+		return err
+	}
+	if ignoreFile != "" {
+		if err := filter.AddIgnoreFile(ignoreFile); err != nil {
+			// the default ignore file is optional
+			if !os.IsNotExist(err) || ignoreFile != pkgutil.IgnoreFile {
+				return errors.Wrapf(err, "reading ignore file %s", ignoreFile)
 			}
 		}
 	}
-	return false
+	pkgutil.Filter = filter
+	return nil
+}
+
+// getPlatform parses the --platform flag, returning nil if it is unset
+func getPlatform() (*v1.Platform, error) {
+	if platform == "" {
+		return nil, nil
+	}
+	p, err := v1.ParsePlatform(platform)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing platform %s", platform)
+	}
+	return p, nil
+}
+
+// getRequirements returns the parts of an image needed by the analyzers
+// selected with --type
+func getRequirements() pkgutil.FSRequirement {
+	requires := pkgutil.RequireConfig
+	for _, t := range types {
+		if a, exists := differs.Analyzers[t]; exists {
+			requires |= a.Requires()
+		}
+	}
+	return requires
 }
 
 func getImage(imageName string) (pkgutil.Image, error) {
+	return getImageWithRequirements(imageName, getRequirements())
+}
+
+func getImageWithRequirements(imageName string, requires pkgutil.FSRequirement) (pkgutil.Image, error) {
+	p, err := getPlatform()
+	if err != nil {
+		return pkgutil.Image{}, err
+	}
+	return getImageForPlatform(imageName, p, requires)
+}
+
+func getImageForPlatform(imageName string, p *v1.Platform, requires pkgutil.FSRequirement) (pkgutil.Image, error) {
 	var cachePath string
 	var err error
 	if !noCache {
@@ -151,7 +213,7 @@ func getImage(imageName string) (pkgutil.Image, error) {
 		}
 	}
 
-	return pkgutil.GetImage(imageName, includeLayers(), cachePath)
+	return pkgutil.GetImage(imageName, requires, cachePath, p)
 }
 
 func getCacheDir(imageName string) (string, error) {
@@ -170,11 +232,25 @@ func getCacheDir(imageName string) (string, error) {
 			cacheDir = dir
 		}
 	}
-	rootDir := filepath.Join(cacheDir, ".container-diff", "cache")
+	removeLegacyCache(filepath.Join(cacheDir, ".container-diff", "cache"))
+	rootDir := filepath.Join(cacheDir, ".container-diff", "filesystems")
 	imageName = strings.Replace(imageName, string(os.PathSeparator), "", -1)
 	return filepath.Join(rootDir, pkgutil.CleanFilePath(imageName)), nil
 }
 
+// removeLegacyCache removes the cache of earlier versions, which held one
+// extracted filesystem per image name where filesystems are now kept per name
+// and digest
+func removeLegacyCache(dir string) {
+	if _, err := os.Lstat(dir); err != nil {
+		return
+	}
+	logrus.Infof("removing cache in an earlier layout at %s", dir)
+	if err := os.RemoveAll(dir); err != nil {
+		logrus.Warnf("removing cache at %s: %s", dir, err)
+	}
+}
+
 func getWriter(outputFile string) (io.Writer, error) {
 	var err error
 	var outWriter io.Writer
@@ -268,8 +344,14 @@ func addSharedFlags(cmd *cobra.Command) {
 			supportedTypes))
 	cmd.Flags().BoolVarP(&save, "save", "s", false, "Set this flag to save rather than remove the final image filesystems on exit.")
 	cmd.Flags().BoolVarP(&util.SortSize, "order", "o", false, "Set this flag to sort any file/package results by descending size. Otherwise, they will be sorted by name.")
+	cmd.Flags().IntVar(&util.EfficiencyTop, "efficiency-top", util.EfficiencyTop, "Number of wasted files the efficiency analyzer lists, largest first. Set it to -1 to list them all.")
+	cmd.Flags().BoolVar(&pkgutil.SizeOnDisk, "disk-usage", false, "Set this flag to report the disk space allocated to files, like du, rather than their apparent size.")
 	cmd.Flags().BoolVarP(&noCache, "no-cache", "n", false, "Set this to force retrieval of image filesystem on each run.")
 	cmd.Flags().StringVarP(&cacheDir, "cache-dir", "c", "", "cache directory base to create .container-diff (default is $HOME).")
 	cmd.Flags().StringVarP(&outputFile, "output", "w", "", "output file to write to (default writes to the screen).")
 	cmd.Flags().BoolVar(&forceWrite, "force", false, "force overwrite output file, if exists already.")
+	cmd.Flags().StringVar(&platform, "platform", "", "Platform to select from multi-arch images, in the form os/arch[/variant] (e.g. linux/arm64).")
+	cmd.Flags().Var(&includePaths, "include-path", "Only extract and analyze the paths matching this glob, e.g. /usr/lib/**. Set it repeatedly to include several paths.")
+	cmd.Flags().Var(&excludePaths, "exclude-path", "Leave out the paths matching this glob, e.g. /var/cache or __pycache__. Set it repeatedly to exclude several paths.")
+	cmd.Flags().StringVar(&ignoreFile, "ignore-file", pkgutil.IgnoreFile, "File of globs for paths to leave out, with .gitignore syntax.")
 }
@@ -17,12 +17,14 @@ limitations under the License.
 package cmd
 
 import (
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
 	"testing"
 
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
 	homedir "github.com/mitchellh/go-homedir"
 )
 
@@ -47,28 +49,28 @@ func TestCacheDir(t *testing.T) {
 			name:        "default cache is at $HOME",
 			cliFlag:     "",
 			envVar:      "",
-			expectedDir: filepath.Join(homeDir, ".container-diff", "cache"),
+			expectedDir: filepath.Join(homeDir, ".container-diff", "filesystems"),
 			imageName:   "pancakes",
 		},
 		{
 			name:        "setting cache via --cache-dir",
 			cliFlag:     "/tmp",
 			envVar:      "",
-			expectedDir: "/tmp/.container-diff/cache",
+			expectedDir: "/tmp/.container-diff/filesystems",
 			imageName:   "pancakes",
 		},
 		{
 			name:        "setting cache via CONTAINER_DIFF_CACHEDIR",
 			cliFlag:     "",
 			envVar:      "/tmp",
-			expectedDir: "/tmp/.container-diff/cache",
+			expectedDir: "/tmp/.container-diff/filesystems",
 			imageName:   "pancakes",
 		},
 		{
 			name:        "command line --cache-dir takes preference to CONTAINER_DIFF_CACHEDIR",
 			cliFlag:     "/tmp",
 			envVar:      "/opt",
-			expectedDir: "/tmp/.container-diff/cache",
+			expectedDir: "/tmp/.container-diff/filesystems",
 			imageName:   "pancakes",
 		},
 	}
@@ -96,6 +98,32 @@ func TestCacheDir(t *testing.T) {
 	}
 }
 
+func TestLegacyCacheRemoved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	// a filesystem extracted straight under the image name
+	legacy := filepath.Join(dir, ".container-diff", "cache", "pancakes", "etc")
+	if err := os.MkdirAll(legacy, 0700); err != nil {
+		t.Fatalf("Error creating legacy cache: %s", err)
+	}
+
+	defer func(dir string) { cacheDir = dir }(cacheDir)
+	cacheDir = dir
+	actualDir, err := getCacheDir("pancakes")
+	if err != nil {
+		t.Fatalf("Error getting cache dir: %s", err)
+	}
+	if expected := filepath.Join(dir, ".container-diff", "filesystems", "pancakes"); actualDir != expected {
+		t.Errorf("expected: %v\ngot: %v", expected, actualDir)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, ".container-diff", "cache")); !os.IsNotExist(err) {
+		t.Errorf("Expected the legacy cache to be removed, got %v", err)
+	}
+}
+
 func TestMultiValueFlag_Set_shouldDedupeRepeatedArguments(t *testing.T) {
 	var arg multiValueFlag
 	arg.Set("value1")
@@ -123,3 +151,89 @@ func Test_KeyValueArg_Set_shouldAcceptEqualAsValue(t *testing.T) {
 		t.Error("Invalid split. key=value=something should be split to key=>value=something")
 	}
 }
+
+func TestPlatformFlag(t *testing.T) {
+	defer func() { platform = "" }()
+	tests := []struct {
+		platform    string
+		expected    string
+		shouldError bool
+	}{
+		{platform: "", expected: ""},
+		{platform: "linux/arm64", expected: "linux/arm64"},
+		{platform: "linux/arm/v7", expected: "linux/arm/v7"},
+		{platform: "linux/arm/v7/extra", shouldError: true},
+	}
+
+	for _, test := range tests {
+		platform = test.platform
+		p, err := getPlatform()
+		checkError(t, err, test.shouldError)
+		if err != nil {
+			continue
+		}
+		actual := ""
+		if p != nil {
+			actual = p.String()
+		}
+		if actual != test.expected {
+			t.Errorf("Error parsing platform %s: expected %s, got %s", test.platform, test.expected, actual)
+		}
+	}
+}
+
+func TestGetRequirements(t *testing.T) {
+	defer func() { types = nil }()
+	tests := []struct {
+		types    []string
+		expected pkgutil.FSRequirement
+	}{
+		{types: []string{"history"}, expected: pkgutil.RequireConfig},
+		{types: []string{"history", "metadata", "index"}, expected: pkgutil.RequireConfig},
+		{types: []string{"size"}, expected: pkgutil.RequireRootFS},
+		{types: []string{"history", "layer"}, expected: pkgutil.RequireLayers},
+		{types: []string{"apt", "sizelayer"}, expected: pkgutil.RequireRootFS | pkgutil.RequireLayers},
+		{types: []string{"aptlayer"}, expected: pkgutil.RequireRootFS | pkgutil.RequireLayers},
+		{types: []string{"file"}, expected: pkgutil.RequireChangedFS},
+		{types: []string{"file", "apt"}, expected: pkgutil.RequireRootFS | pkgutil.RequireChangedFS},
+	}
+
+	for _, test := range tests {
+		types = test.types
+		if actual := getRequirements(); actual != test.expected {
+			t.Errorf("Wrong requirements for %v: expected %d, got %d", test.types, test.expected, actual)
+		}
+	}
+}
+
+func TestPathFilterFlags(t *testing.T) {
+	defer func() {
+		includePaths, excludePaths, ignoreFile = nil, nil, pkgutil.IgnoreFile
+		pkgutil.Filter = nil
+	}()
+	tests := []struct {
+		description string
+		include     []string
+		exclude     []string
+		ignoreFile  string
+		shouldError bool
+		empty       bool
+	}{
+		{description: "missing default ignore file", ignoreFile: pkgutil.IgnoreFile, empty: true},
+		{description: "missing ignore file", ignoreFile: "does-not-exist", shouldError: true},
+		{description: "exclude", exclude: []string{"/var/cache"}, ignoreFile: pkgutil.IgnoreFile},
+		{description: "negated include", include: []string{"!/usr"}, shouldError: true},
+	}
+
+	for _, test := range tests {
+		includePaths, excludePaths, ignoreFile = test.include, test.exclude, test.ignoreFile
+		err := checkPathFilterFlags(nil)
+		checkError(t, err, test.shouldError)
+		if err != nil {
+			continue
+		}
+		if pkgutil.Filter.Empty() != test.empty {
+			t.Errorf("%s: expected the filter to be empty: %v", test.description, test.empty)
+		}
+	}
+}
@@ -36,6 +36,10 @@ func (a ApkAnalyzer) Name() string {
 	return "ApkAnalyzer"
 }
 
+func (a ApkAnalyzer) Requires() pkgutil.FSRequirement {
+	return pkgutil.RequireRootFS
+}
+
 // ApkDiff compares the packages installed by apk.
 func (a ApkAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
 	diff, err := singleVersionDiff(image1, image2, a)
@@ -126,6 +130,10 @@ func (a ApkLayerAnalyzer) Name() string {
 	return "ApkLayerAnalyzer"
 }
 
+func (a ApkLayerAnalyzer) Requires() pkgutil.FSRequirement {
+	return pkgutil.RequireRootFS | pkgutil.RequireLayers
+}
+
 // ApkDiff compares the packages installed by apt-get.
 func (a ApkLayerAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
 	diff, err := singleVersionLayerDiff(image1, image2, a)
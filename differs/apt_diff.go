@@ -38,6 +38,10 @@ func (a AptAnalyzer) Name() string {
 	return "AptAnalyzer"
 }
 
+func (a AptAnalyzer) Requires() pkgutil.FSRequirement {
+	return pkgutil.RequireRootFS
+}
+
 // AptDiff compares the packages installed by apt-get.
 func (a AptAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
 	diff, err := singleVersionDiff(image1, image2, a)
@@ -134,6 +138,10 @@ func (a AptLayerAnalyzer) Name() string {
 	return "AptLayerAnalyzer"
 }
 
+func (a AptLayerAnalyzer) Requires() pkgutil.FSRequirement {
+	return pkgutil.RequireRootFS | pkgutil.RequireLayers
+}
+
 // AptDiff compares the packages installed by apt-get.
 func (a AptLayerAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
 	diff, err := singleVersionLayerDiff(image1, image2, a)
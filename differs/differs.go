@@ -30,6 +30,8 @@ const fileAnalyzer = "file"
 const layerAnalyzer = "layer"
 const sizeAnalyzer = "size"
 const sizeLayerAnalyzer = "sizelayer"
+const efficiencyAnalyzer = "efficiency"
+const duplicatesAnalyzer = "duplicates"
 const apkAnalyzer = "apk"
 const apkLayerAnalyzer = "apklayer"
 const aptAnalyzer = "apt"
@@ -39,6 +41,7 @@ const rpmLayerAnalyzer = "rpmlayer"
 const pipAnalyzer = "pip"
 const nodeAnalyzer = "node"
 const emergeAnalyzer = "emerge"
+const indexAnalyzer = "index"
 
 type DiffRequest struct {
 	Image1    pkgutil.Image
@@ -55,28 +58,32 @@ type Analyzer interface {
 	Diff(image1, image2 pkgutil.Image) (util.Result, error)
 	Analyze(image pkgutil.Image) (util.Result, error)
 	Name() string
+	// Requires reports which parts of an image the analyzer reads, so that
+	// only those need to be retrieved and unpacked
+	Requires() pkgutil.FSRequirement
 }
 
 var Analyzers = map[string]Analyzer{
-	historyAnalyzer:   HistoryAnalyzer{},
-	metadataAnalyzer:  MetadataAnalyzer{},
-	fileAnalyzer:      FileAnalyzer{},
-	layerAnalyzer:     FileLayerAnalyzer{},
-	sizeAnalyzer:      SizeAnalyzer{},
-	sizeLayerAnalyzer: SizeLayerAnalyzer{},
-	apkAnalyzer:       ApkAnalyzer{},
-	apkLayerAnalyzer:  ApkLayerAnalyzer{},
-	aptAnalyzer:       AptAnalyzer{},
-	aptLayerAnalyzer:  AptLayerAnalyzer{},
-	rpmAnalyzer:       RPMAnalyzer{},
-	rpmLayerAnalyzer:  RPMLayerAnalyzer{},
-	pipAnalyzer:       PipAnalyzer{},
-	nodeAnalyzer:      NodeAnalyzer{},
-	emergeAnalyzer:    EmergeAnalyzer{},
+	historyAnalyzer:    HistoryAnalyzer{},
+	metadataAnalyzer:   MetadataAnalyzer{},
+	fileAnalyzer:       FileAnalyzer{},
+	layerAnalyzer:      FileLayerAnalyzer{},
+	sizeAnalyzer:       SizeAnalyzer{},
+	sizeLayerAnalyzer:  SizeLayerAnalyzer{},
+	efficiencyAnalyzer: EfficiencyAnalyzer{},
+	duplicatesAnalyzer: DuplicatesAnalyzer{},
+	apkAnalyzer:        ApkAnalyzer{},
+	apkLayerAnalyzer:   ApkLayerAnalyzer{},
+	aptAnalyzer:        AptAnalyzer{},
+	aptLayerAnalyzer:   AptLayerAnalyzer{},
+	rpmAnalyzer:        RPMAnalyzer{},
+	rpmLayerAnalyzer:   RPMLayerAnalyzer{},
+	pipAnalyzer:        PipAnalyzer{},
+	nodeAnalyzer:       NodeAnalyzer{},
+	emergeAnalyzer:     EmergeAnalyzer{},
+	indexAnalyzer:      IndexAnalyzer{},
 }
 
-var LayerAnalyzers = [...]string{layerAnalyzer, sizeLayerAnalyzer, apkLayerAnalyzer, aptLayerAnalyzer, rpmLayerAnalyzer}
-
 func (req DiffRequest) GetDiff() (map[string]util.Result, error) {
 	img1 := req.Image1
 	img2 := req.Image2
@@ -0,0 +1,67 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package differs
+
+import (
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+	"github.com/EyeCantCU/container-diff/util"
+)
+
+type DuplicatesAnalyzer struct {
+}
+
+func (a DuplicatesAnalyzer) Name() string {
+	return "DuplicatesAnalyzer"
+}
+
+func (a DuplicatesAnalyzer) Requires() pkgutil.FSRequirement {
+	return pkgutil.RequireRootFS
+}
+
+// Diff compares the sets of duplicate files of two images
+func (a DuplicatesAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
+	sets1, err := pkgutil.GetDuplicates(image1.FSPath)
+	if err != nil {
+		return nil, err
+	}
+	sets2, err := pkgutil.GetDuplicates(image2.FSPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &util.DuplicatesDiffResult{
+		Image1:   image1.Source,
+		Image2:   image2.Source,
+		DiffType: "Duplicates",
+		Diff:     util.DiffDuplicates(sets1, sets2),
+	}, nil
+}
+
+// Analyze groups the regular files of an image by contents, and reports
+// those with more than one copy
+func (a DuplicatesAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
+	sets, err := pkgutil.GetDuplicates(image.FSPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &util.DuplicatesAnalyzeResult{
+		Image:       image.Source,
+		AnalyzeType: "Duplicates",
+		Analysis:    sets,
+	}, nil
+}
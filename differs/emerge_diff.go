@@ -37,6 +37,10 @@ func (em EmergeAnalyzer) Name() string {
 	return "EmergeAnalyzer"
 }
 
+func (em EmergeAnalyzer) Requires() pkgutil.FSRequirement {
+	return pkgutil.RequireRootFS
+}
+
 // Diff compares the packages installed by emerge.
 func (em EmergeAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
 	diff, err := singleVersionDiff(image1, image2, em)
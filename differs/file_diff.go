@@ -19,7 +19,6 @@ package differs
 import (
 	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
 	"github.com/EyeCantCU/container-diff/util"
-	"github.com/sirupsen/logrus"
 )
 
 type FileAnalyzer struct {
@@ -29,6 +28,16 @@ func (a FileAnalyzer) Name() string {
 	return "FileAnalyzer"
 }
 
+func (a FileAnalyzer) Requires() pkgutil.FSRequirement {
+	// a diff only needs the paths touched after the layers the images share
+	requires := pkgutil.RequireChangedFS
+	// provenance is read from the layers
+	if pkgutil.Provenance {
+		requires |= pkgutil.RequireLayers
+	}
+	return requires
+}
+
 // FileDiff diffs two packages and compares their contents
 func (a FileAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
 	diff, err := diffImageFiles(image1.FSPath, image2.FSPath)
@@ -43,14 +52,23 @@ func (a FileAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
 func (a FileAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
 	var result util.FileAnalyzeResult
 
-	imgDir, err := pkgutil.GetDirectory(image.FSPath, true)
+	imgDir, err := pkgutil.GetDirectoryWithHashes(image.FSPath)
 	if err != nil {
 		return result, err
 	}
 
+	entries := pkgutil.GetDirectoryEntries(imgDir)
+	if pkgutil.Provenance {
+		provenance, err := pkgutil.GetLayerProvenance(image)
+		if err != nil {
+			return result, err
+		}
+		pkgutil.AddProvenance(entries, provenance)
+	}
+
 	result.Image = image.Source
 	result.AnalyzeType = "File"
-	result.Analysis = pkgutil.GetDirectoryEntries(imgDir)
+	result.Analysis = entries
 	return &result, err
 }
 
@@ -77,55 +95,74 @@ func (a FileLayerAnalyzer) Name() string {
 	return "FileLayerAnalyzer"
 }
 
-// FileDiff diffs two packages and compares their contents
-func (a FileLayerAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
-	var dirDiffs []util.DirDiff
+func (a FileLayerAnalyzer) Requires() pkgutil.FSRequirement {
+	return pkgutil.RequireLayers
+}
 
-	// Go through each layer of the first image...
-	for index, layer := range image1.Layers {
-		if index >= len(image2.Layers) {
-			continue
+// Diff aligns the layers of two images, and diffs the contents of the
+// layers paired with a changed one. The contents of layers found in only one
+// image are listed as added or deleted.
+func (a FileLayerAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
+	matches := util.AlignLayers(image1, image2)
+	dirDiffs := make([]util.DirDiff, len(matches))
+	for i, match := range matches {
+		var err error
+		switch match.Status {
+		case util.LayerChanged:
+			dirDiffs[i], err = diffImageFiles(image1.Layers[match.Index1].FSPath, image2.Layers[match.Index2].FSPath)
+		case util.LayerRemoved:
+			dirDiffs[i].Dels, err = getLayerEntries(image1.Layers[match.Index1])
+		case util.LayerInserted:
+			dirDiffs[i].Adds, err = getLayerEntries(image2.Layers[match.Index2])
 		}
-		// ...else, diff as usual
-		layer2 := image2.Layers[index]
-		diff, err := diffImageFiles(layer.FSPath, layer2.FSPath)
 		if err != nil {
 			return &util.MultipleDirDiffResult{}, err
 		}
-		dirDiffs = append(dirDiffs, diff)
 	}
 
-	// check if there are any additional layers in either image
-	if len(image1.Layers) != len(image2.Layers) {
-		if len(image1.Layers) > len(image2.Layers) {
-			logrus.Infof("%s has additional layers, please use container-diff analyze to view the files in these layers", image1.Source)
-		} else {
-			logrus.Infof("%s has additional layers, please use container-diff analyze to view the files in these layers", image2.Source)
-		}
-	}
 	return &util.MultipleDirDiffResult{
 		Image1:   image1.Source,
 		Image2:   image2.Source,
 		DiffType: "FileLayer",
 		Diff: util.MultipleDirDiff{
 			DirDiffs: dirDiffs,
+			Layers:   matches,
 		},
 	}, nil
 }
 
+func getLayerEntries(layer pkgutil.Layer) ([]pkgutil.DirectoryEntry, error) {
+	layerDir, err := pkgutil.GetDirectory(layer.FSPath, true)
+	if err != nil {
+		return nil, err
+	}
+	return pkgutil.GetDirectoryEntries(layerDir), nil
+}
+
+// Analyze lists the entries each layer adds, and the entries of the layers
+// below that it deletes through whiteouts
 func (a FileLayerAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
-	var directoryEntries [][]pkgutil.DirectoryEntry
+	var layerDiffs []util.DirDiff
+	lower := map[string]pkgutil.DirectoryEntry{}
 	for _, layer := range image.Layers {
 		layerDir, err := pkgutil.GetDirectory(layer.FSPath, true)
 		if err != nil {
 			return util.FileLayerAnalyzeResult{}, err
 		}
-		directoryEntries = append(directoryEntries, pkgutil.GetDirectoryEntries(layerDir))
+		dels := pkgutil.ApplyWhiteouts(lower, layer.Whiteouts)
+		adds := pkgutil.GetDirectoryEntries(layerDir)
+		for _, entry := range adds {
+			lower[entry.Name] = entry
+		}
+		layerDiffs = append(layerDiffs, util.DirDiff{
+			Adds: adds,
+			Dels: dels,
+		})
 	}
 
 	return &util.FileLayerAnalyzeResult{
 		Image:       image.Source,
 		AnalyzeType: "FileLayer",
-		Analysis:    directoryEntries,
+		Analysis:    layerDiffs,
 	}, nil
 }
@@ -0,0 +1,324 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package differs
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+var baseLayer = []pkgutil.TestTarEntry{
+	{Name: "etc/", Typeflag: tar.TypeDir},
+	{Name: "etc/hostname", Contents: "base"},
+	{Name: "etc/passwd", Contents: "root:x:0:0"},
+	{Name: "usr/", Typeflag: tar.TypeDir},
+	{Name: "usr/lib/", Typeflag: tar.TypeDir},
+	{Name: "usr/lib/libfoo.so", Contents: "foo"},
+	{Name: "usr/lib/libbar.so", Contents: "bar"},
+	{Name: "usr/lib/libbar.so.1", Typeflag: tar.TypeLink, Linkname: "usr/lib/libbar.so"},
+	{Name: "usr/share/", Typeflag: tar.TypeDir},
+	{Name: "usr/share/doc/", Typeflag: tar.TypeDir},
+	{Name: "usr/share/doc/README", Contents: "docs"},
+	{Name: "var/", Typeflag: tar.TypeDir},
+	{Name: "var/log/", Typeflag: tar.TypeDir},
+	{Name: "var/log/messages", Contents: "log"},
+	// walked before its target, so counted in /etc
+	{Name: "etc/readme", Typeflag: tar.TypeLink, Linkname: "usr/share/doc/README"},
+}
+
+func buildTestImage(t *testing.T, layers ...[]pkgutil.TestTarEntry) pkgutil.Image {
+	img := empty.Image
+	for _, entries := range layers {
+		layer, err := pkgutil.TestLayer(entries...)
+		if err != nil {
+			t.Fatalf("Error building layer: %s", err)
+		}
+		if img, err = mutate.AppendLayers(img, layer); err != nil {
+			t.Fatalf("Error appending layer: %s", err)
+		}
+	}
+	dir, err := ioutil.TempDir("", "file-diff-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	if err := pkgutil.GetFileSystemForImage(img, dir, nil); err != nil {
+		t.Fatalf("Error extracting image: %s", err)
+	}
+	return pkgutil.Image{Image: img, FSPath: dir}
+}
+
+func TestDiffImagesSharedLayers(t *testing.T) {
+	tests := []struct {
+		name   string
+		layer1 []pkgutil.TestTarEntry
+		layer2 []pkgutil.TestTarEntry
+		// further layers on top of layer1 and layer2
+		more1 [][]pkgutil.TestTarEntry
+		more2 [][]pkgutil.TestTarEntry
+		// the shared layer is read again for the targets of hard links
+		reread bool
+	}{
+		{
+			name: "identical images",
+		},
+		{
+			name: "modified and added files",
+			layer1: []pkgutil.TestTarEntry{
+				{Name: "etc/hostname", Contents: "one"},
+			},
+			layer2: []pkgutil.TestTarEntry{
+				{Name: "etc/hostname", Contents: "two!"},
+				{Name: "opt/app/bin/app", Contents: "app"},
+			},
+		},
+		{
+			name: "whiteouts and opaque directories",
+			layer1: []pkgutil.TestTarEntry{
+				{Name: "etc/.wh.passwd"},
+			},
+			layer2: []pkgutil.TestTarEntry{
+				{Name: "usr/lib/.wh..wh..opq"},
+				{Name: "usr/lib/libbaz.so", Contents: "baz"},
+				{Name: "var/.wh.log"},
+			},
+		},
+		{
+			name: "directory replaced by other types",
+			layer1: []pkgutil.TestTarEntry{
+				{Name: "usr/share", Typeflag: tar.TypeSymlink, Linkname: "/opt/share"},
+			},
+			layer2: []pkgutil.TestTarEntry{
+				{Name: "var/log", Contents: "not a directory"},
+			},
+			reread: true,
+		},
+		{
+			name: "layers overriding each other",
+			layer1: []pkgutil.TestTarEntry{
+				{Name: "opt/app/config", Contents: "v1"},
+				{Name: "opt/app/data/", Typeflag: tar.TypeDir},
+				{Name: "opt/app/data/cache", Contents: "cache"},
+			},
+			more1: [][]pkgutil.TestTarEntry{{
+				{Name: "opt/app/config", Contents: "v2"},
+				{Name: "opt/app/data/.wh..wh..opq"},
+				{Name: "opt/app/data/fresh", Contents: "fresh"},
+				{Name: "usr/lib/.wh.libfoo.so"},
+			}},
+			layer2: []pkgutil.TestTarEntry{
+				{Name: "usr/lib/libfoo.so", Contents: "foo2"},
+			},
+			more2: [][]pkgutil.TestTarEntry{{
+				{Name: "usr/lib/libfoo.so", Contents: "foo3"},
+			}},
+		},
+		{
+			name: "hard links to shared files",
+			layer2: []pkgutil.TestTarEntry{
+				{Name: "usr/lib/libfoo.so.1", Typeflag: tar.TypeLink, Linkname: "usr/lib/libfoo.so"},
+			},
+		},
+		{
+			name: "shared hard link after its target overwritten",
+			layer2: []pkgutil.TestTarEntry{
+				{Name: "usr/lib/libbar.so.1", Contents: "bar2"},
+			},
+			reread: true,
+		},
+		{
+			name: "modified directories",
+			layer1: []pkgutil.TestTarEntry{
+				{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0700},
+				{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0700},
+			},
+			layer2: []pkgutil.TestTarEntry{
+				{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0750},
+				{Name: "usr/lib/", Typeflag: tar.TypeDir, Mode: 0700},
+				{Name: "usr/lib/libnew.so", Contents: "new"},
+				{Name: "usr/share/doc/", Typeflag: tar.TypeDir, Mode: 0700},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			layers1 := [][]pkgutil.TestTarEntry{baseLayer}
+			if test.layer1 != nil {
+				layers1 = append(layers1, test.layer1)
+			}
+			layers2 := [][]pkgutil.TestTarEntry{baseLayer}
+			if test.layer2 != nil {
+				layers2 = append(layers2, test.layer2)
+			}
+			layers1 = append(layers1, test.more1...)
+			layers2 = append(layers2, test.more2...)
+			image1 := buildTestImage(t, layers1...)
+			defer os.RemoveAll(image1.FSPath)
+			image2 := buildTestImage(t, layers2...)
+			defer os.RemoveAll(image2.FSPath)
+
+			expected, err := diffImageFiles(image1.FSPath, image2.FSPath)
+			if err != nil {
+				t.Fatalf("Error diffing full filesystems: %s", err)
+			}
+
+			reads1, reads2 := map[int]int{}, map[int]int{}
+			changed1 := pkgutil.Image{Image: countingImage{image1.Image, reads1}}
+			changed2 := pkgutil.Image{Image: countingImage{image2.Image, reads2}}
+			if err := pkgutil.GetChangedFileSystems(&changed1, &changed2, "", ""); err != nil {
+				t.Fatalf("Error getting changed filesystems: %s", err)
+			}
+			defer pkgutil.CleanupImage(changed1)
+			defer pkgutil.CleanupImage(changed2)
+			// each layer is read at most once, the shared one only for image1
+			for i, reads := range reads1 {
+				if reads > 1 && !(i == 0 && test.reread && reads == 2) {
+					t.Errorf("Layer %d of image1 read %d times", i, reads)
+				}
+			}
+			if test.reread && reads1[0] != 2 {
+				t.Errorf("Expected the shared layer to be read again, got %d reads", reads1[0])
+			}
+			for i, reads := range reads2 {
+				if reads > 1 || i == 0 && reads > 0 {
+					t.Errorf("Layer %d of image2 read %d times", i, reads)
+				}
+			}
+
+			actual, err := diffImageFiles(changed1.FSPath, changed2.FSPath)
+			if err != nil {
+				t.Fatalf("Error diffing changed filesystems: %s", err)
+			}
+			if !reflect.DeepEqual(expected, actual) {
+				t.Errorf("Diff of changed paths does not match full diff\nexpected: %+v\ngot: %+v", expected, actual)
+			}
+		})
+	}
+}
+
+// countingImage counts how many times each of its layers is read
+type countingImage struct {
+	v1.Image
+	reads map[int]int
+}
+
+func (i countingImage) Layers() ([]v1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	counted := make([]v1.Layer, len(layers))
+	for n, layer := range layers {
+		counted[n] = countingLayer{layer, n, i.reads}
+	}
+	return counted, nil
+}
+
+type countingLayer struct {
+	v1.Layer
+	index int
+	reads map[int]int
+}
+
+func (l countingLayer) Uncompressed() (io.ReadCloser, error) {
+	l.reads[l.index]++
+	return l.Layer.Uncompressed()
+}
+
+func TestChangedFileSystemsWithoutSharedLayers(t *testing.T) {
+	image1 := buildTestImage(t, []pkgutil.TestTarEntry{{Name: "a", Contents: "a"}})
+	defer os.RemoveAll(image1.FSPath)
+	image2 := buildTestImage(t, baseLayer)
+	defer os.RemoveAll(image2.FSPath)
+
+	changed1, changed2 := pkgutil.Image{Image: image1.Image}, pkgutil.Image{Image: image2.Image}
+	if err := pkgutil.GetChangedFileSystems(&changed1, &changed2, "", ""); err != nil {
+		t.Fatalf("Error getting changed filesystems: %s", err)
+	}
+	defer pkgutil.CleanupImage(changed1)
+	defer pkgutil.CleanupImage(changed2)
+	for _, pair := range [][2]string{{image1.FSPath, changed1.FSPath}, {image2.FSPath, changed2.FSPath}} {
+		full, err := pkgutil.GetDirectory(pair[0], true)
+		if err != nil {
+			t.Fatalf("Error reading directory: %s", err)
+		}
+		changed, err := pkgutil.GetDirectory(pair[1], true)
+		if err != nil {
+			t.Fatalf("Error reading directory: %s", err)
+		}
+		if !reflect.DeepEqual(full.Content, changed.Content) {
+			t.Errorf("Images without shared layers should be unpacked in full\nexpected: %v\ngot: %v", full.Content, changed.Content)
+		}
+	}
+}
+
+func TestFlattenedHardLinksToHiddenTargets(t *testing.T) {
+	image := buildTestImage(t, baseLayer, []pkgutil.TestTarEntry{
+		{Name: "usr/share", Typeflag: tar.TypeSymlink, Linkname: "/opt/share"},
+		{Name: "usr/lib/.wh.libbar.so"},
+	})
+	defer os.RemoveAll(image.FSPath)
+
+	// the links keep the contents their targets had in the layer
+	for name, expected := range map[string]string{
+		"etc/readme":          "docs",
+		"usr/lib/libbar.so.1": "bar",
+	} {
+		contents, err := ioutil.ReadFile(filepath.Join(image.FSPath, name))
+		if err != nil {
+			t.Errorf("Error reading %s: %s", name, err)
+		} else if string(contents) != expected {
+			t.Errorf("Expected %s to hold %q, got %q", name, expected, contents)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(image.FSPath, "usr/lib/libbar.so")); !os.IsNotExist(err) {
+		t.Errorf("Expected the target deleted by a whiteout to be left out, got %v", err)
+	}
+}
+
+func TestSharedLayerPrefix(t *testing.T) {
+	image1 := buildTestImage(t, baseLayer, []pkgutil.TestTarEntry{{Name: "a", Contents: "a"}})
+	defer os.RemoveAll(image1.FSPath)
+	image2 := buildTestImage(t, baseLayer, []pkgutil.TestTarEntry{{Name: "b", Contents: "b"}})
+	defer os.RemoveAll(image2.FSPath)
+
+	for _, test := range []struct {
+		img1, img2 v1.Image
+		expected   int
+	}{
+		{img1: image1.Image, img2: image1.Image, expected: 2},
+		{img1: image1.Image, img2: image2.Image, expected: 1},
+		{img1: empty.Image, img2: image2.Image, expected: 0},
+	} {
+		shared, err := pkgutil.SharedLayerPrefix(test.img1, test.img2)
+		if err != nil {
+			t.Fatalf("Error finding shared layers: %s", err)
+		}
+		if shared != test.expected {
+			t.Errorf("Expected %d shared layers, got %d", test.expected, shared)
+		}
+	}
+}
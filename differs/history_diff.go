@@ -36,6 +36,10 @@ func (a HistoryAnalyzer) Name() string {
 	return "HistoryAnalyzer"
 }
 
+func (a HistoryAnalyzer) Requires() pkgutil.FSRequirement {
+	return pkgutil.RequireConfig
+}
+
 func (a HistoryAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
 	diff, err := getHistoryDiff(image1, image2)
 	return &util.HistDiffResult{
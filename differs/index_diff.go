@@ -0,0 +1,178 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package differs
+
+import (
+	"fmt"
+
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+	"github.com/EyeCantCU/container-diff/util"
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+// IndexAnalyzer compares the manifest lists or OCI indexes images were
+// resolved from, without looking at any layers.
+type IndexAnalyzer struct {
+}
+
+func (a IndexAnalyzer) Name() string {
+	return "IndexAnalyzer"
+}
+
+func (a IndexAnalyzer) Requires() pkgutil.FSRequirement {
+	return pkgutil.RequireConfig
+}
+
+func (a IndexAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
+	diff, err := getIndexDiff(image1, image2)
+	return &util.IndexDiffResult{
+		Image1:   image1.Source,
+		Image2:   image2.Source,
+		DiffType: "Index",
+		Diff:     diff,
+	}, err
+}
+
+func (a IndexAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
+	entries, _, err := getIndexEntries(image)
+	if err != nil {
+		return &util.IndexAnalyzeResult{}, err
+	}
+	return &util.IndexAnalyzeResult{
+		Image:       image.Source,
+		AnalyzeType: "Index",
+		Analysis:    entries,
+	}, nil
+}
+
+func getIndexDiff(image1, image2 pkgutil.Image) (util.IndexDiff, error) {
+	entries1, annotations1, err := getIndexEntries(image1)
+	if err != nil {
+		return util.IndexDiff{}, err
+	}
+	entries2, annotations2, err := getIndexEntries(image2)
+	if err != nil {
+		return util.IndexDiff{}, err
+	}
+	return diffIndexEntries(entries1, entries2, annotations1, annotations2), nil
+}
+
+// diffIndexEntries matches the entries of two indexes up by platform
+func diffIndexEntries(entries1, entries2 []util.IndexEntry, annotations1, annotations2 map[string]string) util.IndexDiff {
+	diff := util.IndexDiff{
+		Adds:        []util.IndexEntry{},
+		Dels:        []util.IndexEntry{},
+		Mods:        []util.IndexEntryDiff{},
+		Annotations: util.GetAnnotationDiff(annotations1, annotations2),
+	}
+
+	byPlatform := map[string]util.IndexEntry{}
+	for _, entry := range entries2 {
+		byPlatform[entry.Platform] = entry
+	}
+	for _, entry1 := range entries1 {
+		entry2, ok := byPlatform[entry1.Platform]
+		if !ok {
+			diff.Dels = append(diff.Dels, entry1)
+			continue
+		}
+		delete(byPlatform, entry1.Platform)
+		annotations := util.GetAnnotationDiff(entry1.Annotations, entry2.Annotations)
+		if entry1.Digest != entry2.Digest || len(annotations) > 0 {
+			diff.Mods = append(diff.Mods, util.IndexEntryDiff{
+				Platform:    entry1.Platform,
+				Digest1:     entry1.Digest,
+				Digest2:     entry2.Digest,
+				Annotations: annotations,
+			})
+		}
+	}
+	// keep additions in index order
+	for _, entry2 := range entries2 {
+		if _, ok := byPlatform[entry2.Platform]; ok {
+			diff.Adds = append(diff.Adds, entry2)
+		}
+	}
+	return diff
+}
+
+// getIndexEntries lists the manifests of the index an image was resolved
+// from, along with the index annotations. An image that did not come from an
+// index is treated as an index holding only that image.
+func getIndexEntries(image pkgutil.Image) ([]util.IndexEntry, map[string]string, error) {
+	if image.Index == nil {
+		entry, err := getSingleImageEntry(image)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []util.IndexEntry{entry}, nil, nil
+	}
+
+	manifest, err := image.Index.IndexManifest()
+	if err != nil {
+		return nil, nil, err
+	}
+	entries := []util.IndexEntry{}
+	seen := map[string]int{}
+	for _, desc := range manifest.Manifests {
+		platform := "unknown"
+		if desc.Platform != nil {
+			platform = desc.Platform.String()
+		}
+		// entries without a distinct platform (e.g. build attestations) are
+		// numbered in index order so they can still be matched up
+		seen[platform]++
+		if seen[platform] > 1 {
+			platform = fmt.Sprintf("%s#%d", platform, seen[platform])
+		}
+		entries = append(entries, util.IndexEntry{
+			Platform:    platform,
+			Digest:      desc.Digest.String(),
+			MediaType:   string(desc.MediaType),
+			Size:        desc.Size,
+			Annotations: desc.Annotations,
+		})
+	}
+	return entries, manifest.Annotations, nil
+}
+
+func getSingleImageEntry(image pkgutil.Image) (util.IndexEntry, error) {
+	cf, err := image.Image.ConfigFile()
+	if err != nil {
+		return util.IndexEntry{}, err
+	}
+	platform := v1.Platform{
+		OS:           cf.OS,
+		Architecture: cf.Architecture,
+		Variant:      cf.Variant,
+		OSVersion:    cf.OSVersion,
+	}
+	mediaType, err := image.Image.MediaType()
+	if err != nil {
+		return util.IndexEntry{}, err
+	}
+	size, err := image.Image.Size()
+	if err != nil {
+		return util.IndexEntry{}, err
+	}
+	return util.IndexEntry{
+		Platform:  platform.String(),
+		Digest:    image.Digest.String(),
+		MediaType: string(mediaType),
+		Size:      size,
+	}, nil
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package differs
+
+import (
+	"reflect"
+	"testing"
+
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+	"github.com/EyeCantCU/container-diff/util"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestDiffIndexEntries(t *testing.T) {
+	amd64 := util.IndexEntry{Platform: "linux/amd64", Digest: "sha256:a1"}
+	arm64 := util.IndexEntry{Platform: "linux/arm64", Digest: "sha256:b1"}
+	s390x := util.IndexEntry{Platform: "linux/s390x", Digest: "sha256:c1"}
+	ppc64le := util.IndexEntry{Platform: "linux/ppc64le", Digest: "sha256:d1"}
+
+	testCases := []struct {
+		descrip      string
+		entries1     []util.IndexEntry
+		entries2     []util.IndexEntry
+		annotations1 map[string]string
+		annotations2 map[string]string
+		expected     util.IndexDiff
+	}{
+		{
+			descrip:  "same indexes",
+			entries1: []util.IndexEntry{amd64, arm64},
+			entries2: []util.IndexEntry{amd64, arm64},
+			expected: util.IndexDiff{
+				Adds:        []util.IndexEntry{},
+				Dels:        []util.IndexEntry{},
+				Mods:        []util.IndexEntryDiff{},
+				Annotations: []util.AnnotationDiff{},
+			},
+		},
+		{
+			descrip:  "platforms added and removed in index order",
+			entries1: []util.IndexEntry{amd64, s390x, arm64},
+			entries2: []util.IndexEntry{ppc64le, amd64, arm64, {Platform: "windows/amd64", Digest: "sha256:e1"}},
+			expected: util.IndexDiff{
+				Adds:        []util.IndexEntry{ppc64le, {Platform: "windows/amd64", Digest: "sha256:e1"}},
+				Dels:        []util.IndexEntry{s390x},
+				Mods:        []util.IndexEntryDiff{},
+				Annotations: []util.AnnotationDiff{},
+			},
+		},
+		{
+			descrip:  "digest changed",
+			entries1: []util.IndexEntry{amd64, arm64},
+			entries2: []util.IndexEntry{{Platform: "linux/amd64", Digest: "sha256:a2"}, arm64},
+			expected: util.IndexDiff{
+				Adds: []util.IndexEntry{},
+				Dels: []util.IndexEntry{},
+				Mods: []util.IndexEntryDiff{
+					{Platform: "linux/amd64", Digest1: "sha256:a1", Digest2: "sha256:a2", Annotations: []util.AnnotationDiff{}},
+				},
+				Annotations: []util.AnnotationDiff{},
+			},
+		},
+		{
+			descrip:  "entry and index annotations changed",
+			entries1: []util.IndexEntry{{Platform: "linux/amd64", Digest: "sha256:a1", Annotations: map[string]string{"rev": "1"}}},
+			entries2: []util.IndexEntry{{Platform: "linux/amd64", Digest: "sha256:a1", Annotations: map[string]string{"rev": "2"}}},
+			annotations1: map[string]string{
+				"org.opencontainers.image.created": "2018-01-01",
+			},
+			annotations2: map[string]string{
+				"org.opencontainers.image.created": "2018-02-01",
+				"org.opencontainers.image.version": "1.1",
+			},
+			expected: util.IndexDiff{
+				Adds: []util.IndexEntry{},
+				Dels: []util.IndexEntry{},
+				Mods: []util.IndexEntryDiff{
+					{Platform: "linux/amd64", Digest1: "sha256:a1", Digest2: "sha256:a1", Annotations: []util.AnnotationDiff{{Key: "rev", Value1: "1", Value2: "2"}}},
+				},
+				Annotations: []util.AnnotationDiff{
+					{Key: "org.opencontainers.image.created", Value1: "2018-01-01", Value2: "2018-02-01"},
+					{Key: "org.opencontainers.image.version", Value1: "", Value2: "1.1"},
+				},
+			},
+		},
+	}
+	for _, test := range testCases {
+		diff := diffIndexEntries(test.entries1, test.entries2, test.annotations1, test.annotations2)
+		if !reflect.DeepEqual(diff, test.expected) {
+			t.Errorf("%s\nExpected: %v\nGot: %v", test.descrip, test.expected, diff)
+		}
+	}
+}
+
+// testIndexManifest is a manifest of an in-memory index built by testIndex
+type testIndexManifest struct {
+	image       v1.Image
+	platform    *v1.Platform
+	annotations map[string]string
+}
+
+func testIndex(annotations map[string]string, manifests []testIndexManifest) v1.ImageIndex {
+	var adds []mutate.IndexAddendum
+	for _, m := range manifests {
+		adds = append(adds, mutate.IndexAddendum{
+			Add: m.image,
+			Descriptor: v1.Descriptor{
+				MediaType:   types.DockerManifestSchema2,
+				Platform:    m.platform,
+				Annotations: m.annotations,
+			},
+		})
+	}
+	index := mutate.AppendManifests(empty.Index, adds...)
+	if annotations != nil {
+		index = mutate.Annotations(index, annotations).(v1.ImageIndex)
+	}
+	return index
+}
+
+func testRandomImage(t *testing.T) v1.Image {
+	image, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatalf("Error creating random image: %s", err)
+	}
+	return image
+}
+
+func testImageEntry(t *testing.T, platform string, image v1.Image, annotations map[string]string) util.IndexEntry {
+	digest, err := image.Digest()
+	if err != nil {
+		t.Fatalf("Error getting image digest: %s", err)
+	}
+	size, err := image.Size()
+	if err != nil {
+		t.Fatalf("Error getting image size: %s", err)
+	}
+	return util.IndexEntry{
+		Platform:    platform,
+		Digest:      digest.String(),
+		MediaType:   string(types.DockerManifestSchema2),
+		Size:        size,
+		Annotations: annotations,
+	}
+}
+
+func TestGetIndexEntries(t *testing.T) {
+	amd64, arm64, attestation1, attestation2 := testRandomImage(t), testRandomImage(t), testRandomImage(t), testRandomImage(t)
+	index := testIndex(map[string]string{"a": "1"}, []testIndexManifest{
+		{image: amd64, platform: &v1.Platform{OS: "linux", Architecture: "amd64"}},
+		{image: arm64, platform: &v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, annotations: map[string]string{"b": "2"}},
+		{image: attestation1},
+		{image: attestation2},
+	})
+
+	entries, annotations, err := getIndexEntries(pkgutil.Image{Index: index})
+	if err != nil {
+		t.Fatalf("Error getting index entries: %s", err)
+	}
+	expected := []util.IndexEntry{
+		testImageEntry(t, "linux/amd64", amd64, nil),
+		testImageEntry(t, "linux/arm64/v8", arm64, map[string]string{"b": "2"}),
+		testImageEntry(t, "unknown", attestation1, nil),
+		testImageEntry(t, "unknown#2", attestation2, nil),
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("\nExpected: %v\nGot: %v", expected, entries)
+	}
+	if !reflect.DeepEqual(annotations, map[string]string{"a": "1"}) {
+		t.Errorf("\nExpected index annotations: %v\nGot: %v", map[string]string{"a": "1"}, annotations)
+	}
+}
+
+func TestGetIndexDiff(t *testing.T) {
+	amd64, arm64, amd64v2, s390x := testRandomImage(t), testRandomImage(t), testRandomImage(t), testRandomImage(t)
+	linuxAMD64 := &v1.Platform{OS: "linux", Architecture: "amd64"}
+	linuxARM64 := &v1.Platform{OS: "linux", Architecture: "arm64"}
+	linuxS390X := &v1.Platform{OS: "linux", Architecture: "s390x"}
+	index1 := testIndex(nil, []testIndexManifest{
+		{image: amd64, platform: linuxAMD64},
+		{image: arm64, platform: linuxARM64},
+	})
+	index2 := testIndex(map[string]string{"a": "1"}, []testIndexManifest{
+		{image: amd64v2, platform: linuxAMD64},
+		{image: s390x, platform: linuxS390X},
+	})
+
+	diff, err := getIndexDiff(pkgutil.Image{Index: index1}, pkgutil.Image{Index: index2})
+	if err != nil {
+		t.Fatalf("Error diffing indexes: %s", err)
+	}
+	expected := util.IndexDiff{
+		Adds: []util.IndexEntry{testImageEntry(t, "linux/s390x", s390x, nil)},
+		Dels: []util.IndexEntry{testImageEntry(t, "linux/arm64", arm64, nil)},
+		Mods: []util.IndexEntryDiff{{
+			Platform:    "linux/amd64",
+			Digest1:     testImageEntry(t, "", amd64, nil).Digest,
+			Digest2:     testImageEntry(t, "", amd64v2, nil).Digest,
+			Annotations: []util.AnnotationDiff{},
+		}},
+		Annotations: []util.AnnotationDiff{{Key: "a", Value1: "", Value2: "1"}},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("\nExpected: %v\nGot: %v", expected, diff)
+	}
+}
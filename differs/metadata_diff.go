@@ -36,6 +36,10 @@ func (a MetadataAnalyzer) Name() string {
 	return "MetadataAnalyzer"
 }
 
+func (a MetadataAnalyzer) Requires() pkgutil.FSRequirement {
+	return pkgutil.RequireConfig
+}
+
 func (a MetadataAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
 	diff, err := getMetadataDiff(image1, image2)
 	return &util.MetadataDiffResult{
@@ -35,6 +35,10 @@ func (a NodeAnalyzer) Name() string {
 	return "NodeAnalyzer"
 }
 
+func (a NodeAnalyzer) Requires() pkgutil.FSRequirement {
+	return pkgutil.RequireRootFS
+}
+
 // NodeDiff compares the packages installed by apt-get.
 func (a NodeAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
 	diff, err := multiVersionDiff(image1, image2, a)
@@ -61,6 +65,15 @@ func (a NodeAnalyzer) getPackages(image pkgutil.Image) (map[string]map[string]ut
 
 	for _, modulesDir := range layerStems {
 		packageJSONs, _ := util.BuildLayerTargets(modulesDir, "package.json")
+		if len(packageJSONs) == 0 {
+			continue
+		}
+		// the sizes of all packages in modulesDir come from a single walk
+		sizes, err := pkgutil.GetSizeIndex(modulesDir)
+		if err != nil {
+			logrus.Warningf("Error getting package sizes in %s: %s\n", modulesDir, err)
+			return packages, err
+		}
 		for _, currPackage := range packageJSONs {
 			if _, err := os.Stat(currPackage); err != nil {
 				// package.json file does not exist at this target path
@@ -75,7 +88,7 @@ func (a NodeAnalyzer) getPackages(image pkgutil.Image) (map[string]map[string]ut
 			var currInfo util.PackageInfo
 			currInfo.Version = packageJSON.Version
 			packagePath := strings.TrimSuffix(currPackage, "package.json")
-			currInfo.Size = pkgutil.GetSize(packagePath)
+			currInfo.Size = sizes[strings.TrimSuffix(strings.TrimPrefix(packagePath, modulesDir), "/")]
 			mapPath := strings.Replace(packagePath, path, "", 1)
 			// Check if other package version already recorded
 			if _, ok := packages[packageJSON.Name]; !ok {
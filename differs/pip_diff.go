@@ -36,6 +36,10 @@ func (a PipAnalyzer) Name() string {
 	return "PipAnalyzer"
 }
 
+func (a PipAnalyzer) Requires() pkgutil.FSRequirement {
+	return pkgutil.RequireRootFS
+}
+
 // PipDiff compares pip-installed Python packages between layers of two different images.
 func (a PipAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
 	diff, err := multiVersionDiff(image1, image2, a)
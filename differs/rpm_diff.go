@@ -48,10 +48,11 @@ import (
 // RPM macros file location
 const rpmMacros string = "/usr/lib/rpm/macros"
 
-// RPM command to extract packages from the rpm database
+// RPM command to extract packages from the rpm database, with the fields
+// parseRPMHeader reads
 var rpmCmd = []string{
 	"rpm", "--nodigest", "--nosignature",
-	"-qa", "--qf", "%{NAME}\t%{VERSION}-%{RELEASE}\t%{SIZE}\n",
+	"-qa", "--qf", "%{NAME}\t%|EPOCH?{%{EPOCH}}:{}|\t%{VERSION}\t%{RELEASE}\t%{ARCH}\t%{SIZE}\n",
 }
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
@@ -69,6 +70,10 @@ func (a RPMAnalyzer) Name() string {
 	return "RPMAnalyzer"
 }
 
+func (a RPMAnalyzer) Requires() pkgutil.FSRequirement {
+	return pkgutil.RequireRootFS
+}
+
 // Diff compares the installed rpm packages of image1 and image2.
 func (a RPMAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
 	diff, err := singleVersionDiff(image1, image2, a)
@@ -90,6 +95,14 @@ func (a RPMAnalyzer) getPackages(image pkgutil.Image) (map[string]util.PackageIn
 		return packages, err
 	}
 
+	if dbFile, err := findRPMDatabase(path); err != nil {
+		logrus.Infof("Could not detect RPM database in unpacked image %s; querying it with rpm", image.Source)
+	} else if dbPackages, err := rpmDataFromDatabase(filepath.Join(path, dbFile)); err != nil {
+		logrus.Warnf("Couldn't read RPM database %s: %s", dbFile, err)
+	} else {
+		return dbPackages, nil
+	}
+
 	// try to find the rpm binary in bin/ or usr/bin/
 	rpmBinary := filepath.Join(path, "bin/rpm")
 	if _, err := os.Stat(rpmBinary); err != nil {
@@ -233,32 +246,35 @@ func rpmDataFromContainer(image v1.Image) (map[string]util.PackageInfo, error) {
 }
 
 // parsePackageData parses the package data of each line in rpmOutput and
-// returns a map of packages.
+// returns a map of packages, keyed like the packages read from the rpm
+// database.
 func parsePackageData(rpmOutput []string) (map[string]util.PackageInfo, error) {
-	packages := make(map[string]util.PackageInfo)
+	var rpmPackages []rpmPackage
 
 	for _, output := range rpmOutput {
 		spl := strings.Split(output, "\t")
-		if len(spl) != 3 {
+		if len(spl) != 6 {
 			// ignore the empty (last) line
 			if output != "" {
 				logrus.Errorf("unexpected rpm-query output: '%s'", output)
 			}
 			continue
 		}
-		pkg := util.PackageInfo{}
+		pkg := rpmPackage{Name: spl[0], Epoch: spl[1], Version: spl[2], Release: spl[3], Arch: spl[4]}
+		// rpm prints unset tags, like the arch of gpg-pubkey, as (none)
+		if pkg.Arch == "(none)" {
+			pkg.Arch = ""
+		}
 
 		var err error
-		pkg.Size, err = strconv.ParseInt(spl[2], 10, 64)
+		pkg.Size, err = strconv.ParseInt(spl[5], 10, 64)
 		if err != nil {
-			return packages, fmt.Errorf("error converting package size: %s", spl[2])
+			return rpmPackageInfos(rpmPackages), fmt.Errorf("error converting package size: %s", spl[5])
 		}
-
-		pkg.Version = spl[1]
-		packages[spl[0]] = pkg
+		rpmPackages = append(rpmPackages, pkg)
 	}
 
-	return packages, nil
+	return rpmPackageInfos(rpmPackages), nil
 }
 
 // loadImageToDaemon loads the image specified to the docker daemon.
@@ -367,6 +383,10 @@ func (a RPMLayerAnalyzer) Name() string {
 	return "RPMLayerAnalyzer"
 }
 
+func (a RPMLayerAnalyzer) Requires() pkgutil.FSRequirement {
+	return pkgutil.RequireRootFS | pkgutil.RequireLayers
+}
+
 // Diff compares the installed rpm packages of image1 and image2 for each layer
 func (a RPMLayerAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
 	diff, err := singleVersionLayerDiff(image1, image2, a)
@@ -388,6 +408,14 @@ func (a RPMLayerAnalyzer) getPackages(image pkgutil.Image) ([]map[string]util.Pa
 		return packages, err
 	}
 
+	if dbFile, err := findRPMDatabase(path); err != nil {
+		logrus.Infof("Could not detect RPM database in unpacked image %s; querying it with rpm", image.Source)
+	} else if dbPackages, err := rpmDataFromLayerDatabases(image, dbFile); err != nil {
+		logrus.Warnf("Couldn't read RPM database %s: %s", dbFile, err)
+	} else {
+		return dbPackages, nil
+	}
+
 	// try to find the rpm binary in bin/ or usr/bin/
 	rpmBinary := filepath.Join(path, "bin/rpm")
 	if _, err := os.Stat(rpmBinary); err != nil {
@@ -406,6 +434,25 @@ func (a RPMLayerAnalyzer) getPackages(image pkgutil.Image) ([]map[string]util.Pa
 	return packages, err
 }
 
+// rpmDataFromLayerDatabases reads the rpm database dbFile of each layer
+// that has one, and returns an array of maps of installed packages.
+func rpmDataFromLayerDatabases(image pkgutil.Image, dbFile string) ([]map[string]util.PackageInfo, error) {
+	var packages []map[string]util.PackageInfo
+	for _, layer := range image.Layers {
+		layerPackages := make(map[string]util.PackageInfo)
+		layerDBFile := filepath.Join(layer.FSPath, dbFile)
+		if _, err := os.Lstat(layerDBFile); err == nil {
+			var err error
+			layerPackages, err = rpmDataFromDatabase(layerDBFile)
+			if err != nil {
+				return packages, err
+			}
+		}
+		packages = append(packages, layerPackages)
+	}
+	return packages, nil
+}
+
 // rpmDataFromLayerFS runs a local rpm binary, if any, to query the layer
 // rpmdb and returns an array of maps of installed packages.
 func rpmDataFromLayerFS(image pkgutil.Image) ([]map[string]util.PackageInfo, error) {
@@ -17,7 +17,13 @@ limitations under the License.
 package differs
 
 import (
+	"encoding/binary"
+	"io/ioutil"
+	"reflect"
 	"testing"
+
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+	"github.com/EyeCantCU/container-diff/util"
 )
 
 // TestLockUnlock runs some lock-unlock cycles to make sure that close,
@@ -66,3 +72,188 @@ func TestGoRoutineRace(t *testing.T) {
 		t.Errorf("Other goroutine didn't lock although lock was released")
 	}
 }
+
+// TestRPMDatabases reads the same packages from fixture databases in each
+// of the formats rpm uses, generated by testDirs/rpmdb/generate.py
+func TestRPMDatabases(t *testing.T) {
+	expected := map[string]util.PackageInfo{
+		"bash":         {Version: "5.1.8-6.el9", Size: 7738634},
+		"openssl-libs": {Version: "1:3.0.7-27.el9", Size: 6391184},
+		"glibc.x86_64": {Version: "2.34-100.el9", Size: 6345478},
+		"glibc.i686":   {Version: "2.34-100.el9", Size: 6000000},
+		"large-assets": {Version: "1.0-1", Size: 5368709120},
+	}
+	testCases := []struct {
+		descrip string
+		root    string
+		dbFile  string
+	}{
+		{descrip: "sqlite", root: "testDirs/rpmdb/sqlite", dbFile: "/usr/lib/sysimage/rpm/rpmdb.sqlite"},
+		{descrip: "ndb", root: "testDirs/rpmdb/ndb", dbFile: "/usr/lib/sysimage/rpm/Packages.db"},
+		{descrip: "Berkeley DB", root: "testDirs/rpmdb/bdb", dbFile: "/var/lib/rpm/Packages"},
+	}
+	for _, test := range testCases {
+		dbFile, err := findRPMDatabase(test.root)
+		if err != nil {
+			t.Errorf("%s: error finding rpm database: %s", test.descrip, err)
+			continue
+		}
+		if dbFile != test.dbFile {
+			t.Errorf("%s: expected rpm database %s, got %s", test.descrip, test.dbFile, dbFile)
+		}
+		packages, err := RPMAnalyzer{}.getPackages(pkgutil.Image{FSPath: test.root})
+		if err != nil {
+			t.Errorf("%s: error reading rpm database: %s", test.descrip, err)
+			continue
+		}
+		if !reflect.DeepEqual(packages, expected) {
+			t.Errorf("%s:\nExpected: %v\nGot: %v", test.descrip, expected, packages)
+		}
+	}
+
+	image := pkgutil.Image{
+		FSPath: "testDirs/rpmdb/sqlite",
+		Layers: []pkgutil.Layer{{FSPath: "testDirs/noPackages"}, {FSPath: "testDirs/rpmdb/sqlite"}},
+	}
+	layerPackages, err := RPMLayerAnalyzer{}.getPackages(image)
+	if err != nil {
+		t.Fatalf("Error reading layer rpm databases: %s", err)
+	}
+	expectedLayers := []map[string]util.PackageInfo{{}, expected}
+	if !reflect.DeepEqual(layerPackages, expectedLayers) {
+		t.Errorf("\nExpected: %v\nGot: %v", expectedLayers, layerPackages)
+	}
+
+	data, err := ioutil.ReadFile("testDirs/rpmdb/ndb/usr/lib/sysimage/rpm/Packages.db")
+	if err != nil {
+		t.Fatalf("Error reading ndb fixture: %s", err)
+	}
+	headers, err := readNDBRPMDB(data)
+	if err != nil || len(headers) != len(expected) {
+		t.Fatalf("Error reading ndb headers: %v, %s", headers, err)
+	}
+	pkg, err := parseRPMHeader(headers[1])
+	if err != nil {
+		t.Fatalf("Error parsing rpm header: %s", err)
+	}
+	expectedPkg := rpmPackage{Name: "openssl-libs", Epoch: "1", Version: "3.0.7", Release: "27.el9", Arch: "x86_64", Size: 6391184}
+	if pkg != expectedPkg {
+		t.Errorf("\nExpected: %v\nGot: %v", expectedPkg, pkg)
+	}
+}
+
+// TestParsePackageData parses the output of rpmCmd for the packages of the
+// database fixtures, which must be keyed like the packages read from them.
+func TestParsePackageData(t *testing.T) {
+	output := []string{
+		"bash\t\t5.1.8\t6.el9\tx86_64\t7738634",
+		"openssl-libs\t1\t3.0.7\t27.el9\tx86_64\t6391184",
+		"glibc\t\t2.34\t100.el9\tx86_64\t6345478",
+		"glibc\t\t2.34\t100.el9\ti686\t6000000",
+		"large-assets\t\t1.0\t1\tnoarch\t5368709120",
+		"gpg-pubkey\t\t8483c65d\t5ccc5b19\t(none)\t0",
+		"",
+	}
+	expected := map[string]util.PackageInfo{
+		"bash":         {Version: "5.1.8-6.el9", Size: 7738634},
+		"openssl-libs": {Version: "1:3.0.7-27.el9", Size: 6391184},
+		"glibc.x86_64": {Version: "2.34-100.el9", Size: 6345478},
+		"glibc.i686":   {Version: "2.34-100.el9", Size: 6000000},
+		"large-assets": {Version: "1.0-1", Size: 5368709120},
+		"gpg-pubkey":   {Version: "8483c65d-5ccc5b19", Size: 0},
+	}
+	packages, err := parsePackageData(output)
+	if err != nil {
+		t.Fatalf("Error parsing rpm output: %s", err)
+	}
+	if !reflect.DeepEqual(packages, expected) {
+		t.Errorf("\nExpected: %v\nGot: %v", expected, packages)
+	}
+
+	dbPackages, err := rpmDataFromDatabase("testDirs/rpmdb/sqlite/usr/lib/sysimage/rpm/rpmdb.sqlite")
+	if err != nil {
+		t.Fatalf("Error reading rpm database: %s", err)
+	}
+	delete(packages, "gpg-pubkey")
+	if !reflect.DeepEqual(packages, dbPackages) {
+		t.Errorf("Packages keyed differently from the rpm database:\nrpm: %v\ndatabase: %v", packages, dbPackages)
+	}
+
+	if _, err := parsePackageData([]string{"bash\t\t5.1.8\t6.el9\tx86_64\tbig"}); err == nil {
+		t.Errorf("Expected an error parsing an invalid package size")
+	}
+}
+
+// TestRPMHeaders parses headers written by rpmbuild, as rpm stores them in
+// its database. They are the main headers of the one-epoch and zero-epoch
+// test packages of github.com/sassoftware/go-rpmutils (Apache 2.0), without
+// their magic.
+func TestRPMHeaders(t *testing.T) {
+	testCases := []struct {
+		file     string
+		expected rpmPackage
+		version  string
+	}{
+		{
+			file:     "testDirs/rpmdb/headers/one-epoch-0.1-1.x86_64.hdr",
+			expected: rpmPackage{Name: "one-epoch", Epoch: "1", Version: "0.1", Release: "1", Arch: "x86_64", Size: 10},
+			version:  "1:0.1-1",
+		},
+		{
+			file:     "testDirs/rpmdb/headers/zero-epoch-0.1-1.x86_64.hdr",
+			expected: rpmPackage{Name: "zero-epoch", Epoch: "0", Version: "0.1", Release: "1", Arch: "x86_64", Size: 10},
+			version:  "0:0.1-1",
+		},
+	}
+	for _, test := range testCases {
+		header, err := ioutil.ReadFile(test.file)
+		if err != nil {
+			t.Fatalf("Error reading %s: %s", test.file, err)
+		}
+		pkg, err := parseRPMHeader(header)
+		if err != nil {
+			t.Errorf("%s: error parsing rpm header: %s", test.file, err)
+			continue
+		}
+		if pkg != test.expected {
+			t.Errorf("%s:\nExpected: %v\nGot: %v", test.file, test.expected, pkg)
+		}
+		packages := rpmPackageInfos([]rpmPackage{pkg})
+		if version := packages[pkg.Name].Version; version != test.version {
+			t.Errorf("%s: expected version %s, got %s", test.file, test.version, version)
+		}
+	}
+}
+
+// TestCorruptSQLiteRPMDB reads a SQLite database whose b-tree pages each
+// list the next page as all of their children: shallow, but with a number of
+// paths to the last page growing exponentially with the number of pages.
+func TestCorruptSQLiteRPMDB(t *testing.T) {
+	const pageSize = 512
+	const pages = 20
+	data := make([]byte, pages*pageSize)
+	copy(data, sqliteHeader)
+	binary.BigEndian.PutUint16(data[16:], pageSize)
+	binary.BigEndian.PutUint32(data[56:], 1)
+	for n := 1; n < pages; n++ {
+		page := data[(n-1)*pageSize : n*pageSize]
+		header := 0
+		if n == 1 {
+			header = 100
+		}
+		cells := 10
+		page[header] = sqliteTableInteriorPage
+		binary.BigEndian.PutUint16(page[header+3:], uint16(cells))
+		binary.BigEndian.PutUint32(page[header+8:], uint32(n+1))
+		cell := pageSize - 8
+		binary.BigEndian.PutUint32(page[cell:], uint32(n+1))
+		for i := 0; i < cells; i++ {
+			binary.BigEndian.PutUint16(page[header+12+2*i:], uint16(cell))
+		}
+	}
+	data[(pages-1)*pageSize] = sqliteTableLeafPage
+
+	if _, err := readSQLiteRPMDB(data); err == nil {
+		t.Errorf("Expected an error reading a SQLite database with shared pages")
+	}
+}
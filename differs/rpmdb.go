@@ -0,0 +1,451 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package differs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/EyeCantCU/container-diff/util"
+)
+
+// rpmDBPaths are the directories an rpm database is looked for in, after
+// the one set by %_dbpath in the image's rpm macros
+var rpmDBPaths = []string{"/usr/lib/sysimage/rpm", "/var/lib/rpm"}
+
+// rpmDBFormats maps the file names of the rpm database backends, in order
+// of preference, to the function that reads the headers they hold
+var rpmDBFormats = []struct {
+	name string
+	read func(data []byte) ([][]byte, error)
+}{
+	{"rpmdb.sqlite", readSQLiteRPMDB},
+	{"Packages.db", readNDBRPMDB},
+	{"Packages", readBDBRPMDB},
+}
+
+// rpmMacroDirs expands the macros %_dbpath is usually defined with
+var rpmMacroDirs = strings.NewReplacer(
+	"%{_usr}", "/usr",
+	"%{_prefix}", "/usr",
+	"%{_var}", "/var",
+	"%{_localstatedir}", "/var",
+)
+
+// findRPMDatabase returns the path under root of the rpm database of an
+// extracted image, without needing an rpm binary to expand %_dbpath.
+// Symlinked directories aren't followed, as they may point outside root.
+func findRPMDatabase(root string) (string, error) {
+	dirs := rpmDBPaths
+	if dbPath, ok := rpmDBPathFromMacros(root); ok {
+		dirs = append([]string{dbPath}, dirs...)
+	}
+	for _, dir := range dirs {
+		if info, err := os.Lstat(filepath.Join(root, dir)); err != nil || !info.IsDir() {
+			continue
+		}
+		for _, format := range rpmDBFormats {
+			dbFile := path.Join(dir, format.name)
+			if info, err := os.Lstat(filepath.Join(root, dbFile)); err == nil && info.Mode().IsRegular() {
+				return dbFile, nil
+			}
+		}
+	}
+	return "", errors.New("no rpm database found")
+}
+
+// rpmDBPathFromMacros reads %_dbpath from the rpm macros of the image at
+// root, if it only uses the macros rpmMacroDirs expands
+func rpmDBPathFromMacros(root string) (string, bool) {
+	macros, err := os.Open(filepath.Join(root, rpmMacros))
+	if err != nil {
+		return "", false
+	}
+	defer macros.Close()
+
+	scanner := bufio.NewScanner(macros)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "%_dbpath" {
+			dbPath := rpmMacroDirs.Replace(fields[1])
+			return dbPath, path.IsAbs(dbPath) && !strings.Contains(dbPath, "%")
+		}
+	}
+	return "", false
+}
+
+// rpmDataFromDatabase reads the installed packages from the rpm database
+// file dbFile, in any of the formats of rpmDBFormats
+func rpmDataFromDatabase(dbFile string) (map[string]util.PackageInfo, error) {
+	data, err := ioutil.ReadFile(dbFile)
+	if err != nil {
+		return nil, err
+	}
+	var read func(data []byte) ([][]byte, error)
+	for _, format := range rpmDBFormats {
+		if filepath.Base(dbFile) == format.name {
+			read = format.read
+		}
+	}
+	if read == nil {
+		return nil, fmt.Errorf("unknown rpm database format %s", dbFile)
+	}
+	headers, err := read(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var rpmPackages []rpmPackage
+	for _, header := range headers {
+		pkg, err := parseRPMHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		rpmPackages = append(rpmPackages, pkg)
+	}
+	return rpmPackageInfos(rpmPackages), nil
+}
+
+// rpmPackage holds the fields of an rpm header the analyzers read
+type rpmPackage struct {
+	Name    string
+	Epoch   string
+	Version string
+	Release string
+	Arch    string
+	Size    int64
+}
+
+// rpmPackageInfos maps the packages read from an rpm database or rpmCmd by
+// name, with their version formatted epoch:version-release, without the
+// epoch if it is unset. Packages installed for several architectures are
+// named name.arch, rather than hiding each other.
+func rpmPackageInfos(rpmPackages []rpmPackage) map[string]util.PackageInfo {
+	count := map[string]int{}
+	for _, pkg := range rpmPackages {
+		count[pkg.Name]++
+	}
+	packages := make(map[string]util.PackageInfo)
+	for _, pkg := range rpmPackages {
+		name := pkg.Name
+		if count[name] > 1 && pkg.Arch != "" {
+			name += "." + pkg.Arch
+		}
+		version := pkg.Version + "-" + pkg.Release
+		if pkg.Epoch != "" {
+			version = pkg.Epoch + ":" + version
+		}
+		packages[name] = util.PackageInfo{Version: version, Size: pkg.Size}
+	}
+	return packages
+}
+
+// rpm header tags
+const (
+	rpmTagName     = 1000
+	rpmTagVersion  = 1001
+	rpmTagRelease  = 1002
+	rpmTagEpoch    = 1003
+	rpmTagSize     = 1009
+	rpmTagArch     = 1022
+	rpmTagLongSize = 5009
+)
+
+// rpm header data types
+const (
+	rpmTypeInt32      = 4
+	rpmTypeInt64      = 5
+	rpmTypeString     = 6
+	rpmTypeI18NString = 9
+)
+
+// maxRPMHeaderEntries bounds the index of a header, like rpm does
+const maxRPMHeaderEntries = 0xffff
+
+// parseRPMHeader reads a header blob as stored in the rpm database: the
+// number of index entries and the size of the data, then the index entries
+// and the data they point into, all big-endian
+func parseRPMHeader(blob []byte) (rpmPackage, error) {
+	var pkg rpmPackage
+	if len(blob) < 8 {
+		return pkg, errors.New("rpm header too short")
+	}
+	entries := binary.BigEndian.Uint32(blob)
+	dataLen := binary.BigEndian.Uint32(blob[4:])
+	if entries > maxRPMHeaderEntries || 8+16*int64(entries)+int64(dataLen) > int64(len(blob)) {
+		return pkg, errors.New("invalid rpm header size")
+	}
+	data := blob[8+16*entries : 8+16*entries+dataLen]
+
+	for i := uint32(0); i < entries; i++ {
+		entry := blob[8+16*i:]
+		tag := binary.BigEndian.Uint32(entry)
+		kind := binary.BigEndian.Uint32(entry[4:])
+		offset := binary.BigEndian.Uint32(entry[8:])
+		if offset >= dataLen {
+			continue
+		}
+		value := data[offset:]
+
+		var str string
+		var num int64
+		switch kind {
+		case rpmTypeString, rpmTypeI18NString:
+			end := bytes.IndexByte(value, 0)
+			if end == -1 {
+				return pkg, fmt.Errorf("unterminated string for rpm tag %d", tag)
+			}
+			str = string(value[:end])
+		case rpmTypeInt32:
+			if len(value) < 4 {
+				return pkg, fmt.Errorf("truncated value for rpm tag %d", tag)
+			}
+			num = int64(binary.BigEndian.Uint32(value))
+		case rpmTypeInt64:
+			if len(value) < 8 {
+				return pkg, fmt.Errorf("truncated value for rpm tag %d", tag)
+			}
+			num = int64(binary.BigEndian.Uint64(value))
+		default:
+			continue
+		}
+
+		switch tag {
+		case rpmTagName:
+			pkg.Name = str
+		case rpmTagVersion:
+			pkg.Version = str
+		case rpmTagRelease:
+			pkg.Release = str
+		case rpmTagEpoch:
+			pkg.Epoch = strconv.FormatInt(num, 10)
+		case rpmTagArch:
+			pkg.Arch = str
+		case rpmTagSize:
+			// LONGSIZE is set instead for packages over 4GB
+			if pkg.Size == 0 {
+				pkg.Size = num
+			}
+		case rpmTagLongSize:
+			pkg.Size = num
+		}
+	}
+	if pkg.Name == "" {
+		return pkg, errors.New("rpm header has no name")
+	}
+	return pkg, nil
+}
+
+// readSQLiteRPMDB returns the headers of rpmdb.sqlite, the blob column of
+// its Packages table
+func readSQLiteRPMDB(data []byte) ([][]byte, error) {
+	db, err := openSQLite(data)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.tableRows("Packages")
+	if err != nil {
+		return nil, err
+	}
+	var headers [][]byte
+	for _, row := range rows {
+		// columns: hnum, the rowid, then blob
+		if len(row) < 2 {
+			return nil, errors.New("unexpected columns in Packages table")
+		}
+		header, ok := row[1].([]byte)
+		if !ok {
+			return nil, errors.New("unexpected header type in Packages table")
+		}
+		headers = append(headers, header)
+	}
+	return headers, nil
+}
+
+// ndb (Packages.db) layout: a header, pages of slots pointing to the blob of
+// each package, and the blobs, all little-endian
+const (
+	ndbHeaderMagic    = 'R' | 'p'<<8 | 'm'<<16 | 'P'<<24
+	ndbSlotMagic      = 'S' | 'l'<<8 | 'o'<<16 | 't'<<24
+	ndbBlobMagic      = 'B' | 'l'<<8 | 'b'<<16 | 'S'<<24
+	ndbVersion        = 0
+	ndbPageSize       = 4096
+	ndbHeaderSize     = 32
+	ndbSlotSize       = 16
+	ndbBlobHeaderSize = 16
+	ndbMaxSlotPages   = 2048
+	ndbBlockSize      = 16
+)
+
+// readNDBRPMDB returns the headers of an ndb Packages.db
+func readNDBRPMDB(data []byte) ([][]byte, error) {
+	le := binary.LittleEndian
+	if len(data) < ndbHeaderSize || le.Uint32(data) != ndbHeaderMagic {
+		return nil, errors.New("not an ndb rpm database")
+	}
+	if version := le.Uint32(data[4:]); version != ndbVersion {
+		return nil, fmt.Errorf("unsupported ndb version %d", version)
+	}
+	slotPages := le.Uint32(data[12:])
+	if slotPages == 0 || slotPages > ndbMaxSlotPages || int64(slotPages)*ndbPageSize > int64(len(data)) {
+		return nil, fmt.Errorf("invalid ndb slot page count %d", slotPages)
+	}
+
+	var headers [][]byte
+	// the slots fill the pages after the database header
+	for at := ndbHeaderSize; at+ndbSlotSize <= int(slotPages)*ndbPageSize; at += ndbSlotSize {
+		slot := data[at:]
+		if le.Uint32(slot) != ndbSlotMagic {
+			return nil, fmt.Errorf("bad ndb slot magic at %d", at)
+		}
+		pkgIndex := le.Uint32(slot[4:])
+		if pkgIndex == 0 {
+			continue
+		}
+		blob := int64(le.Uint32(slot[8:])) * ndbBlockSize
+		if blob+ndbBlobHeaderSize > int64(len(data)) {
+			return nil, fmt.Errorf("ndb blob of package %d out of range", pkgIndex)
+		}
+		if le.Uint32(data[blob:]) != ndbBlobMagic || le.Uint32(data[blob+4:]) != pkgIndex {
+			return nil, fmt.Errorf("bad ndb blob header for package %d", pkgIndex)
+		}
+		blobLen := int64(le.Uint32(data[blob+12:]))
+		start := blob + ndbBlobHeaderSize
+		if start+blobLen > int64(len(data)) {
+			return nil, fmt.Errorf("ndb blob of package %d out of range", pkgIndex)
+		}
+		headers = append(headers, data[start:start+blobLen])
+	}
+	return headers, nil
+}
+
+// Berkeley DB hash database (Packages) layout, in the byte order of the
+// host that wrote it
+const (
+	bdbHashMagic        = 0x061561
+	bdbMetaSize         = 72
+	bdbPageHeaderSize   = 26
+	bdbHashUnsortedPage = 2
+	bdbOverflowPage     = 7
+	bdbHashPage         = 13
+	// bdbOffPageItem is a hash item whose data is on overflow pages
+	bdbOffPageItem = 3
+	bdbOffPageSize = 12
+)
+
+// readBDBRPMDB returns the headers of a Berkeley DB Packages database. Each
+// header is the data of an item of a hash page, stored on overflow pages
+// as headers are larger than the items kept on hash pages. Items kept on
+// hash pages are skipped, like the record of the last header number.
+func readBDBRPMDB(data []byte) ([][]byte, error) {
+	if len(data) < bdbMetaSize {
+		return nil, errors.New("not a Berkeley DB database")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if order.Uint32(data[12:]) != bdbHashMagic {
+		order = binary.BigEndian
+		if order.Uint32(data[12:]) != bdbHashMagic {
+			return nil, errors.New("not a Berkeley DB hash database")
+		}
+	}
+	pageSize := int64(order.Uint32(data[20:]))
+	if pageSize < 512 || pageSize > 65536 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("invalid Berkeley DB page size %d", pageSize)
+	}
+	if data[24] != 0 {
+		return nil, errors.New("encrypted Berkeley DB databases aren't supported")
+	}
+	lastPage := int64(order.Uint32(data[32:]))
+	page := func(n int64) ([]byte, error) {
+		if (n+1)*pageSize > int64(len(data)) {
+			return nil, fmt.Errorf("Berkeley DB page %d out of range", n)
+		}
+		return data[n*pageSize : (n+1)*pageSize], nil
+	}
+
+	// overflow returns the size bytes stored on the overflow pages from n
+	overflow := func(n int64, size int64) ([]byte, error) {
+		if size > int64(len(data)) {
+			return nil, fmt.Errorf("invalid Berkeley DB item size %d", size)
+		}
+		value := make([]byte, 0, size)
+		for pages := int64(0); int64(len(value)) < size; pages++ {
+			if n == 0 || pages > lastPage {
+				return nil, errors.New("truncated Berkeley DB overflow item")
+			}
+			p, err := page(n)
+			if err != nil {
+				return nil, err
+			}
+			if p[25] != bdbOverflowPage {
+				return nil, fmt.Errorf("Berkeley DB page %d isn't an overflow page", n)
+			}
+			// the free area offset of an overflow page is its data length
+			length := int64(order.Uint16(p[22:]))
+			if bdbPageHeaderSize+length > pageSize {
+				return nil, fmt.Errorf("Berkeley DB overflow page %d too long", n)
+			}
+			value = append(value, p[bdbPageHeaderSize:bdbPageHeaderSize+length]...)
+			n = int64(order.Uint32(p[16:]))
+		}
+		return value[:size], nil
+	}
+
+	var headers [][]byte
+	for n := int64(1); n <= lastPage; n++ {
+		p, err := page(n)
+		if err != nil {
+			return nil, err
+		}
+		if p[25] != bdbHashPage && p[25] != bdbHashUnsortedPage {
+			continue
+		}
+		entries := int64(order.Uint16(p[20:]))
+		// items alternate keys and data
+		for i := int64(1); i < entries; i += 2 {
+			at := bdbPageHeaderSize + 2*i
+			if at+2 > pageSize {
+				return nil, fmt.Errorf("Berkeley DB page %d has too many entries", n)
+			}
+			item := int64(order.Uint16(p[at:]))
+			if item >= pageSize {
+				return nil, fmt.Errorf("Berkeley DB page %d has an item out of range", n)
+			}
+			if p[item] != bdbOffPageItem {
+				continue
+			}
+			if item+bdbOffPageSize > pageSize {
+				return nil, fmt.Errorf("Berkeley DB page %d has an item out of range", n)
+			}
+			header, err := overflow(int64(order.Uint32(p[item+4:])), int64(order.Uint32(p[item+8:])))
+			if err != nil {
+				return nil, err
+			}
+			headers = append(headers, header)
+		}
+	}
+	return headers, nil
+}
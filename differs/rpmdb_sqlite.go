@@ -0,0 +1,304 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package differs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+const sqliteHeader = "SQLite format 3\x00"
+
+// SQLite b-tree page types
+const (
+	sqliteTableInteriorPage = 0x05
+	sqliteTableLeafPage     = 0x0d
+)
+
+// maxSQLiteDepth bounds the depth of the b-trees walked, to stop loops in a
+// corrupt database
+const maxSQLiteDepth = 64
+
+// sqliteDB reads the rows of the tables of a SQLite 3 database file. Only
+// what reading rpmdb.sqlite needs is supported: table b-trees with their
+// overflow pages, in a UTF-8 database. Changes still in a write-ahead log
+// aren't seen.
+type sqliteDB struct {
+	data     []byte
+	pageSize int
+	// usable is the size of a page without the bytes reserved at its end
+	usable int
+}
+
+func openSQLite(data []byte) (*sqliteDB, error) {
+	if len(data) < 100 || string(data[:16]) != sqliteHeader {
+		return nil, errors.New("not a SQLite 3 database")
+	}
+	pageSize := int(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("invalid SQLite page size %d", pageSize)
+	}
+	if encoding := binary.BigEndian.Uint32(data[56:60]); encoding > 1 {
+		return nil, fmt.Errorf("unsupported SQLite text encoding %d", encoding)
+	}
+	return &sqliteDB{data: data, pageSize: pageSize, usable: pageSize - int(data[20])}, nil
+}
+
+// page returns page n, numbered from 1
+func (db *sqliteDB) page(n uint32) ([]byte, error) {
+	start := (int64(n) - 1) * int64(db.pageSize)
+	if n == 0 || start+int64(db.pageSize) > int64(len(db.data)) {
+		return nil, fmt.Errorf("SQLite page %d out of range", n)
+	}
+	return db.data[start : start+int64(db.pageSize)], nil
+}
+
+// tableRows returns the columns of each row of the table name, in rowid
+// order
+func (db *sqliteDB) tableRows(name string) ([][]interface{}, error) {
+	var root int64
+	err := db.walkTable(1, 0, map[uint32]bool{}, func(record []byte) error {
+		columns, err := parseSQLiteRecord(record)
+		if err != nil {
+			return err
+		}
+		// sqlite_schema columns: type, name, tbl_name, rootpage, sql
+		if len(columns) >= 4 && columns[0] == "table" && columns[1] == name {
+			root, _ = columns[3].(int64)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if root <= 0 || root > math.MaxUint32 {
+		return nil, fmt.Errorf("no %s table in SQLite database", name)
+	}
+
+	var rows [][]interface{}
+	err = db.walkTable(uint32(root), 0, map[uint32]bool{}, func(record []byte) error {
+		columns, err := parseSQLiteRecord(record)
+		if err == nil {
+			rows = append(rows, columns)
+		}
+		return err
+	})
+	return rows, err
+}
+
+// walkTable calls visit with the record of each row of the table b-tree
+// rooted at page n. visited holds the pages already walked: each page is in
+// a single b-tree once, so a page seen again is a loop in a corrupt database.
+func (db *sqliteDB) walkTable(n uint32, depth int, visited map[uint32]bool, visit func(record []byte) error) error {
+	if depth > maxSQLiteDepth {
+		return errors.New("SQLite b-tree too deep")
+	}
+	if visited[n] {
+		return fmt.Errorf("SQLite page %d is in the b-tree more than once", n)
+	}
+	visited[n] = true
+	page, err := db.page(n)
+	if err != nil {
+		return err
+	}
+	// the first page starts with the database header
+	header := 0
+	if n == 1 {
+		header = 100
+	}
+	if len(page) < header+12 {
+		return fmt.Errorf("SQLite page %d too short", n)
+	}
+	cells := int(binary.BigEndian.Uint16(page[header+3:]))
+	cellOffset := func(i, pointers int) (int, error) {
+		at := pointers + 2*i
+		if at+2 > len(page) {
+			return 0, fmt.Errorf("SQLite page %d has too many cells", n)
+		}
+		offset := int(binary.BigEndian.Uint16(page[at:]))
+		if offset+4 > db.usable {
+			return 0, fmt.Errorf("SQLite page %d has a cell out of range", n)
+		}
+		return offset, nil
+	}
+
+	switch page[header] {
+	case sqliteTableInteriorPage:
+		for i := 0; i < cells; i++ {
+			offset, err := cellOffset(i, header+12)
+			if err != nil {
+				return err
+			}
+			if err := db.walkTable(binary.BigEndian.Uint32(page[offset:]), depth+1, visited, visit); err != nil {
+				return err
+			}
+		}
+		return db.walkTable(binary.BigEndian.Uint32(page[header+8:]), depth+1, visited, visit)
+	case sqliteTableLeafPage:
+		for i := 0; i < cells; i++ {
+			offset, err := cellOffset(i, header+8)
+			if err != nil {
+				return err
+			}
+			size, n1 := sqliteVarint(page[offset:])
+			_, n2 := sqliteVarint(page[offset+n1:])
+			if n1 == 0 || n2 == 0 {
+				return fmt.Errorf("SQLite page %d has a truncated cell", n)
+			}
+			record, err := db.payload(page, offset+n1+n2, size)
+			if err != nil {
+				return err
+			}
+			if err := visit(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("SQLite page %d isn't a table page", n)
+}
+
+// payload returns the size bytes of the payload of a table leaf cell that
+// start at offset in page, following its overflow pages
+func (db *sqliteDB) payload(page []byte, offset int, size int64) ([]byte, error) {
+	if size < 0 || size > int64(len(db.data)) {
+		return nil, fmt.Errorf("invalid SQLite payload size %d", size)
+	}
+	local := size
+	if maxLocal := int64(db.usable - 35); size > maxLocal {
+		minLocal := int64((db.usable-12)*32/255 - 23)
+		local = minLocal + (size-minLocal)%int64(db.usable-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+	end := offset + int(local)
+	if local < size {
+		end += 4
+	}
+	if end > len(page) {
+		return nil, errors.New("SQLite cell overflows its page")
+	}
+
+	payload := make([]byte, 0, size)
+	payload = append(payload, page[offset:offset+int(local)]...)
+	if local == size {
+		return payload, nil
+	}
+	next := binary.BigEndian.Uint32(page[offset+int(local):])
+	for pages := 0; int64(len(payload)) < size; pages++ {
+		if pages > len(db.data)/db.pageSize {
+			return nil, errors.New("SQLite overflow pages form a loop")
+		}
+		overflow, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		chunk := overflow[4:db.usable]
+		if remaining := size - int64(len(payload)); int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		payload = append(payload, chunk...)
+		next = binary.BigEndian.Uint32(overflow)
+	}
+	return payload, nil
+}
+
+// parseSQLiteRecord decodes the columns of a record to nil, int64, float64,
+// string or []byte values
+func parseSQLiteRecord(record []byte) ([]interface{}, error) {
+	headerSize, n := sqliteVarint(record)
+	if n == 0 || headerSize < int64(n) || headerSize > int64(len(record)) {
+		return nil, errors.New("invalid SQLite record header")
+	}
+	var columns []interface{}
+	body := record[headerSize:]
+	for at := n; at < int(headerSize); {
+		serialType, n := sqliteVarint(record[at:headerSize])
+		if n == 0 {
+			return nil, errors.New("truncated SQLite record header")
+		}
+		at += n
+
+		var size int
+		switch {
+		case serialType >= 12:
+			size = int((serialType - 12) / 2)
+		case serialType >= 1 && serialType <= 4:
+			size = int(serialType)
+		case serialType == 5:
+			size = 6
+		case serialType == 6 || serialType == 7:
+			size = 8
+		}
+		if size > len(body) {
+			return nil, errors.New("truncated SQLite record")
+		}
+		value := body[:size]
+		body = body[size:]
+
+		switch {
+		case serialType < 0 || serialType == 10 || serialType == 11:
+			return nil, fmt.Errorf("invalid SQLite serial type %d", serialType)
+		case serialType == 0:
+			columns = append(columns, nil)
+		case serialType == 7:
+			columns = append(columns, math.Float64frombits(binary.BigEndian.Uint64(value)))
+		case serialType == 8 || serialType == 9:
+			columns = append(columns, serialType-8)
+		case serialType < 12:
+			// big-endian two's complement integers
+			var v int64
+			if value[0]&0x80 != 0 {
+				v = -1
+			}
+			for _, b := range value {
+				v = v<<8 | int64(b)
+			}
+			columns = append(columns, v)
+		case serialType%2 == 0:
+			columns = append(columns, value)
+		default:
+			columns = append(columns, string(value))
+		}
+	}
+	return columns, nil
+}
+
+// sqliteVarint decodes a SQLite variable-length integer, returning it with
+// the number of bytes read, or 0 if b is truncated
+func sqliteVarint(b []byte) (int64, int) {
+	var v uint64
+	for i := 0; i < 8; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return int64(v), i + 1
+		}
+	}
+	if len(b) < 9 {
+		return 0, 0
+	}
+	return int64(v<<8 | uint64(b[8])), 9
+}
@@ -30,6 +30,10 @@ func (a SizeAnalyzer) Name() string {
 	return "SizeAnalyzer"
 }
 
+func (a SizeAnalyzer) Requires() pkgutil.FSRequirement {
+	return pkgutil.RequireRootFS
+}
+
 // SizeDiff diffs two images and compares their size
 func (a SizeAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
 	diff := []util.SizeDiff{}
@@ -74,27 +78,31 @@ func (a SizeLayerAnalyzer) Name() string {
 	return "SizeLayerAnalyzer"
 }
 
-// SizeLayerDiff diffs the layers of two images and compares their size
+func (a SizeLayerAnalyzer) Requires() pkgutil.FSRequirement {
+	return pkgutil.RequireLayers
+}
+
+// SizeLayerDiff aligns the layers of two images and compares the size of
+// those that changed. Layers found in only one image have a size of -1 in
+// the other.
 func (a SizeLayerAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
 	var layerDiffs []util.SizeDiff
 
-	maxLayer := len(image1.Layers)
-	if len(image2.Layers) > maxLayer {
-		maxLayer = len(image2.Layers)
-	}
-
-	for index := 0; index < maxLayer; index++ {
+	for _, match := range util.AlignLayers(image1, image2) {
+		if match.Status == util.LayerUnchanged {
+			continue
+		}
 		var size1, size2 int64 = -1, -1
-		if index < len(image1.Layers) {
-			size1 = pkgutil.GetSize(image1.Layers[index].FSPath)
+		if match.Index1 != -1 {
+			size1 = pkgutil.GetSize(image1.Layers[match.Index1].FSPath)
 		}
-		if index < len(image2.Layers) {
-			size2 = pkgutil.GetSize(image2.Layers[index].FSPath)
+		if match.Index2 != -1 {
+			size2 = pkgutil.GetSize(image2.Layers[match.Index2].FSPath)
 		}
 
 		if size1 != size2 {
 			diff := util.SizeDiff{
-				Name:  strconv.Itoa(index),
+				Name:  match.Label(),
 				Size1: size1,
 				Size2: size2,
 			}
@@ -127,3 +135,48 @@ func (a SizeLayerAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
 		Analysis:    entries,
 	}, nil
 }
+
+type EfficiencyAnalyzer struct {
+}
+
+func (a EfficiencyAnalyzer) Name() string {
+	return "EfficiencyAnalyzer"
+}
+
+func (a EfficiencyAnalyzer) Requires() pkgutil.FSRequirement {
+	return pkgutil.RequireLayers
+}
+
+// Diff compares the space wasted by the layers of two images
+func (a EfficiencyAnalyzer) Diff(image1, image2 pkgutil.Image) (util.Result, error) {
+	efficiency1, err := util.GetEfficiency(image1)
+	if err != nil {
+		return nil, err
+	}
+	efficiency2, err := util.GetEfficiency(image2)
+	if err != nil {
+		return nil, err
+	}
+
+	return &util.EfficiencyDiffResult{
+		Image1:   image1.Source,
+		Image2:   image2.Source,
+		DiffType: "Efficiency",
+		Diff:     util.EfficiencyDiff{Efficiency1: efficiency1, Efficiency2: efficiency2},
+	}, nil
+}
+
+// Analyze reports the bytes stored in the layers of an image that are
+// overwritten, deleted or duplicated by later layers
+func (a EfficiencyAnalyzer) Analyze(image pkgutil.Image) (util.Result, error) {
+	efficiency, err := util.GetEfficiency(image)
+	if err != nil {
+		return nil, err
+	}
+
+	return &util.EfficiencyAnalyzeResult{
+		Image:       image.Source,
+		AnalyzeType: "Efficiency",
+		Analysis:    efficiency,
+	}, nil
+}
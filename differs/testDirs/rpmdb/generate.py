@@ -0,0 +1,171 @@
+#!/usr/bin/env python3
+# Copyright 2018 Google, Inc. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Generates the rpm database fixtures of TestRPMDatabases.
+
+Each database holds the same packages, in the sqlite, ndb and Berkeley DB
+formats rpm uses. Small page sizes make the databases use overflow pages
+and, for sqlite, interior b-tree pages.
+
+The headers are packed here too. headers/ holds headers written by rpmbuild
+instead, which TestRPMHeaders checks the same parser against.
+"""
+
+import os
+import sqlite3
+import struct
+
+HERE = os.path.dirname(os.path.abspath(__file__))
+
+# name, epoch, version, release, arch, size, longsize, description length
+PACKAGES = [
+    ("bash", None, "5.1.8", "6.el9", "x86_64", 7738634, None, 1500),
+    ("openssl-libs", 1, "3.0.7", "27.el9", "x86_64", 6391184, None, 300),
+    ("glibc", None, "2.34", "100.el9", "x86_64", 6345478, None, 300),
+    ("glibc", None, "2.34", "100.el9", "i686", 6000000, None, 300),
+    ("large-assets", None, "1.0", "1", "noarch", None, 5368709120, 300),
+]
+
+RPM_BIN, RPM_INT32, RPM_INT64, RPM_STRING, RPM_I18NSTRING = 7, 4, 5, 6, 9
+
+
+def header(name, epoch, version, release, arch, size, longsize, desc):
+    """Returns a header blob as rpm stores it in its database."""
+    tags = [
+        (1000, RPM_STRING, name),
+        (1001, RPM_STRING, version),
+        (1002, RPM_STRING, release),
+        (1005, RPM_I18NSTRING, "x" * desc),
+        (1022, RPM_STRING, arch),
+    ]
+    if epoch is not None:
+        tags.append((1003, RPM_INT32, epoch))
+    if size is not None:
+        tags.append((1009, RPM_INT32, size))
+    if longsize is not None:
+        tags.append((5009, RPM_INT64, longsize))
+    tags.sort()
+
+    index, data = [], b""
+    for tag, kind, value in tags:
+        if kind == RPM_INT32:
+            data += b"\0" * (-len(data) % 4)
+            encoded = struct.pack(">I", value)
+        elif kind == RPM_INT64:
+            data += b"\0" * (-len(data) % 8)
+            encoded = struct.pack(">Q", value)
+        else:
+            encoded = value.encode() + b"\0"
+        index.append(struct.pack(">IIII", tag, kind, len(data), 1))
+        data += encoded
+    # the immutable region trailer rpm puts first, which readers skip
+    index.insert(0, struct.pack(">IIII", 63, RPM_BIN, len(data), 16))
+    data += struct.pack(">IIii", 63, RPM_BIN, -16 * len(index), 16)
+    return struct.pack(">II", len(index), len(data)) + b"".join(index) + data
+
+
+HEADERS = [header(*p) for p in PACKAGES]
+
+
+def write(path, contents):
+    path = os.path.join(HERE, path)
+    os.makedirs(os.path.dirname(path), exist_ok=True)
+    with open(path, "wb") as f:
+        f.write(contents)
+
+
+def sqlite_db():
+    root = os.path.join(HERE, "sqlite")
+    write("sqlite/usr/lib/rpm/macros", b"%_dbpath\t\t%{_usr}/lib/sysimage/rpm\n")
+    path = os.path.join(root, "usr/lib/sysimage/rpm/rpmdb.sqlite")
+    os.makedirs(os.path.dirname(path), exist_ok=True)
+    if os.path.exists(path):
+        os.remove(path)
+    db = sqlite3.connect(path)
+    db.execute("PRAGMA page_size=512")
+    db.execute("CREATE TABLE 'Packages' (hnum INTEGER PRIMARY KEY AUTOINCREMENT, blob BLOB NOT NULL)")
+    db.execute("CREATE TABLE 'Name' (key TEXT NOT NULL, hnum INTEGER NOT NULL, idx INTEGER NOT NULL)")
+    for i, (blob, package) in enumerate(zip(HEADERS, PACKAGES), 1):
+        db.execute("INSERT INTO Packages VALUES (?, ?)", (i, blob))
+        db.execute("INSERT INTO Name VALUES (?, ?, 0)", (package[0], i))
+    db.commit()
+    db.close()
+
+
+def ndb():
+    page_size, block = 4096, 16
+    slots, blobs = [], b""
+    for i, blob in enumerate(HEADERS, 1):
+        offset = page_size + len(blobs)
+        tail = struct.pack("<III", 0, len(blob), 0x456C4242)  # "BBlE"
+        record = struct.pack("<IIII", 0x53626C42, i, 0, len(blob)) + blob + tail
+        record += b"\0" * (-len(record) % block)
+        slots.append(struct.pack("<IIII", 0x746F6C53, i, offset // block, len(record) // block))
+        blobs += record
+    db = struct.pack("<IIII16x", 0x506D7052, 0, 1, 1)
+    db += b"".join(slots)
+    while len(db) < page_size:
+        db += struct.pack("<IIII", 0x746F6C53, 0, 0, 0)
+    write("ndb/usr/lib/sysimage/rpm/Packages.db", db + blobs)
+
+
+def bdb():
+    page_size = 512
+    pages = {}
+
+    def page_header(pgno, prev, nxt, entries, hf_offset, level, kind):
+        return struct.pack("<8xIIIHHBB", pgno, prev, nxt, entries, hf_offset, level, kind)
+
+    # overflow pages, starting after the meta page and the hash page
+    items = [(b"\x01" + struct.pack("<I", 0), b"\x01" + struct.pack("<I", len(HEADERS)))]
+    next_page = 2
+    for i, blob in enumerate(HEADERS, 1):
+        chunk = page_size - 26
+        chunks = [blob[j:j + chunk] for j in range(0, len(blob), chunk)]
+        first = next_page
+        for j, data in enumerate(chunks):
+            pgno = next_page
+            prev = pgno - 1 if j else 0
+            nxt = pgno + 1 if j + 1 < len(chunks) else 0
+            pages[pgno] = page_header(pgno, prev, nxt, 1, len(data), 0, 7) + data
+            next_page += 1
+        items.append((b"\x01" + struct.pack("<I", i),
+                      struct.pack("<B3xII", 3, first, len(blob))))
+
+    # the hash page: an index of item offsets, items packed at the end
+    flat = [item for pair in items for item in pair]
+    offsets, body = [], b""
+    end = page_size
+    for item in flat:
+        end -= len(item)
+        offsets.append(end)
+        body = item + body
+    index = b"".join(struct.pack("<H", o) for o in offsets)
+    hash_page = page_header(1, 0, 0, len(flat), end, 0, 13) + index
+    hash_page += b"\0" * (end - len(hash_page)) + body
+    pages[1] = hash_page
+
+    last = next_page - 1
+    meta = struct.pack("<8xIIIIBBBxIIIIII20x", 0, 0x061561, 9, page_size,
+                       0, 8, 0, 0, last, 0, len(HEADERS) + 1, len(HEADERS) + 1, 0)
+    pages[0] = meta
+    db = b"".join(pages[n].ljust(page_size, b"\0") for n in range(last + 1))
+    write("bdb/var/lib/rpm/Packages", db)
+
+
+if __name__ == "__main__":
+    sqlite_db()
+    ndb()
+    bdb()
@@ -4,6 +4,7 @@ go 1.25.1
 
 require (
 	code.cloudfoundry.org/bytefmt v0.51.0
+	github.com/BurntSushi/toml v1.5.0
 	github.com/docker/docker v28.4.0+incompatible
 	github.com/fsouza/go-dockerclient v1.12.2
 	github.com/google/go-containerregistry v0.20.6
@@ -18,6 +19,7 @@ require (
 	github.com/spf13/cobra v1.10.1
 	github.com/spf13/pflag v1.0.10
 	golang.org/x/oauth2 v0.31.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,101 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"os"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DuplicateSet is a group of regular files of an image with the same
+// contents
+type DuplicateSet struct {
+	// Sha256 is the hex encoded sha256 of the contents of the files
+	Sha256 string
+	// Size is the size of each copy
+	Size int64
+	// Files lists one name for each copy, sorted. Hard links to a copy
+	// aren't listed, as they don't take up space of their own.
+	Files []string
+	// Reclaimable is the space taken up by all copies but one
+	Reclaimable int64
+}
+
+// duplicateCandidate is a regular file found by GetDuplicates
+type duplicateCandidate struct {
+	name string
+	path string
+	size int64
+}
+
+// GetDuplicates walks the directory at root, and groups the regular files
+// Filter matches by contents. Only files of the same size are hashed, and
+// hard links to the same file count as a single copy. Sets are sorted by
+// the name of their first file.
+func GetDuplicates(root string) ([]DuplicateSet, error) {
+	bySize := map[int64][]duplicateCandidate{}
+	seen := map[Inode]bool{}
+	visit := func(name, path string, info os.FileInfo) {
+		if !info.Mode().IsRegular() || info.Size() == 0 || !Filter.Matches(name, false) {
+			return
+		}
+		if id, ok := getInode(info); ok {
+			if seen[id] {
+				return
+			}
+			seen[id] = true
+		}
+		bySize[info.Size()] = append(bySize[info.Size()], duplicateCandidate{name: name, path: path, size: getFileSize(info)})
+	}
+	if err := newTreeWalker(root, visit).walkRoot(); err != nil {
+		return nil, err
+	}
+
+	var sets []DuplicateSet
+	for _, candidates := range bySize {
+		if len(candidates) < 2 {
+			continue
+		}
+		byHash := map[string][]duplicateCandidate{}
+		for _, candidate := range candidates {
+			hash, err := GetFileHash(candidate.path)
+			if err != nil {
+				logrus.Errorf("Could not hash %s: %s", candidate.path, err)
+				continue
+			}
+			byHash[hash] = append(byHash[hash], candidate)
+		}
+		for hash, copies := range byHash {
+			if len(copies) < 2 {
+				continue
+			}
+			set := DuplicateSet{Sha256: hash, Size: copies[0].size}
+			for _, c := range copies {
+				set.Files = append(set.Files, c.name)
+			}
+			sort.Strings(set.Files)
+			set.Reclaimable = set.Size * int64(len(copies)-1)
+			sets = append(sets, set)
+		}
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].Files[0] < sets[j].Files[0]
+	})
+	return sets, nil
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// IgnoreFile is the name of the file read for patterns of paths to ignore,
+// with the same syntax as a .gitignore file
+const IgnoreFile = ".containerdiffignore"
+
+// Filter selects the paths of images that are extracted and analyzed. If
+// nil, all paths are.
+var Filter *PathFilter
+
+// PathFilter selects paths inside an image. Patterns follow .gitignore
+// semantics: a pattern without a slash matches a name at any depth, one with
+// a slash matches from the root of the image, a trailing slash only matches
+// directories, "*" and "?" don't match "/" while "**" does, and a leading "!"
+// re-includes paths excluded by an earlier pattern.
+type PathFilter struct {
+	// include holds the patterns of the paths to keep; if there are none,
+	// all paths not excluded are kept
+	include []pathPattern
+	// exclude holds the patterns of the paths to leave out, in order
+	exclude []pathPattern
+	// sources lists every pattern, to identify the filter
+	sources []string
+}
+
+type pathPattern struct {
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// NewPathFilter creates a filter that keeps the paths matching the include
+// patterns, or all paths if there are none, except for those matching the
+// exclude patterns.
+func NewPathFilter(include, exclude []string) (*PathFilter, error) {
+	f := &PathFilter{}
+	for _, pattern := range include {
+		p, err := compilePathPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if p.negate {
+			return nil, fmt.Errorf("include pattern %s can't be negated", pattern)
+		}
+		f.include = append(f.include, p)
+		f.sources = append(f.sources, "include:"+pattern)
+	}
+	for _, pattern := range exclude {
+		if err := f.addExclude(pattern); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// AddIgnoreFile adds the patterns in the ignore file at path to the paths f
+// excludes. Blank lines and lines starting with "#" are skipped.
+func (f *PathFilter) AddIgnoreFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := f.addExclude(line); err != nil {
+			return errors.Wrapf(err, "reading %s", path)
+		}
+	}
+	return scanner.Err()
+}
+
+func (f *PathFilter) addExclude(pattern string) error {
+	p, err := compilePathPattern(pattern)
+	if err != nil {
+		return err
+	}
+	f.exclude = append(f.exclude, p)
+	f.sources = append(f.sources, "exclude:"+pattern)
+	return nil
+}
+
+// Empty reports whether f keeps every path
+func (f *PathFilter) Empty() bool {
+	return f == nil || (len(f.include) == 0 && len(f.exclude) == 0)
+}
+
+// Key identifies the paths f keeps, to tell filtered extractions apart. It is
+// empty if f keeps every path.
+func (f *PathFilter) Key() string {
+	if f.Empty() {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(strings.Join(f.sources, "\n")))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// Matches reports whether f keeps the path name, an absolute path inside an
+// image
+func (f *PathFilter) Matches(name string, isDir bool) bool {
+	if f.Empty() {
+		return true
+	}
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return true
+	}
+	elems := strings.Split(name, "/")
+	included := len(f.include) == 0
+	for i := range elems {
+		current := strings.Join(elems[:i+1], "/")
+		currentIsDir := isDir || i < len(elems)-1
+		// as with .gitignore, nothing inside an excluded directory can be
+		// included again
+		if f.excluded(current, currentIsDir) {
+			return false
+		}
+		if !included {
+			for _, p := range f.include {
+				if p.matches(current, currentIsDir) {
+					included = true
+					break
+				}
+			}
+		}
+	}
+	return included
+}
+
+// excluded applies the exclude patterns to name, the last matching one
+// deciding
+func (f *PathFilter) excluded(name string, isDir bool) bool {
+	excluded := false
+	for _, p := range f.exclude {
+		if p.matches(name, isDir) {
+			excluded = !p.negate
+		}
+	}
+	return excluded
+}
+
+func (p pathPattern) matches(name string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	return p.re.MatchString(name)
+}
+
+// compilePathPattern converts a .gitignore style pattern to a regular
+// expression matched against paths without their leading slash
+func compilePathPattern(pattern string) (pathPattern, error) {
+	var p pathPattern
+	if strings.HasPrefix(pattern, "!") {
+		p.negate = true
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		p.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return p, fmt.Errorf("invalid empty path pattern")
+	}
+	// a pattern with no slash, other than a trailing one, matches at any depth
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "/**") && i+3 == len(pattern):
+			re.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end == -1 {
+				return p, fmt.Errorf("invalid path pattern %s: unterminated [", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			re.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return p, errors.Wrapf(err, "invalid path pattern %s", pattern)
+	}
+	p.re = compiled
+	return p, nil
+}
@@ -17,7 +17,8 @@ limitations under the License.
 package util
 
 import (
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
 	"io/ioutil"
 	"os"
@@ -31,11 +32,29 @@ import (
 type Directory struct {
 	Root    string
 	Content []string
+	// Index holds the metadata recorded when the directory was extracted,
+	// if any
+	Index ExtractionIndex
+	// Hashes maps the regular files in Content to the hex encoded sha256 of
+	// their contents, if they were hashed during the walk
+	Hashes map[string]string
+	// Sizes holds the size of each entry in Content, if the directory was
+	// walked deeply
+	Sizes SizeIndex
 }
 
 type DirectoryEntry struct {
 	Name string
 	Size int64
+	// Metadata holds the ownership, mode, xattrs and type of the entry as
+	// recorded in the image, if it was extracted by container-diff
+	Metadata *EntryMetadata `json:",omitempty"`
+	// Sha256 is the hex encoded sha256 of the contents of a regular file, if
+	// it was hashed
+	Sha256 string `json:",omitempty"`
+	// Provenance records the layers that wrote and deleted the entry, if
+	// they were looked up
+	Provenance *EntryProvenance `json:",omitempty"`
 }
 
 func GetSize(path string) int64 {
@@ -45,13 +64,23 @@ func GetSize(path string) int64 {
 		return -1
 	}
 	if stat.IsDir() {
-		size, err := getDirectorySize(path)
+		sizes, err := GetSizeIndex(path)
 		if err != nil {
 			logrus.Errorf("Could not obtain directory size for %s: %s", path, err)
+			return 0
 		}
+		return sizes.Total()
+	}
+	return getFileSize(stat)
+}
+
+// GetEntrySize returns the size of the entry name in d, from d.Sizes if the
+// directory was walked deeply
+func GetEntrySize(d Directory, name string) int64 {
+	if size, ok := d.Sizes[name]; ok {
 		return size
 	}
-	return stat.Size()
+	return GetSize(filepath.Join(d.Root, name))
 }
 
 // GetFileContents returns the contents of a file at the specified path
@@ -73,32 +102,52 @@ func GetFileContents(path string) (*string, error) {
 	return &strContents, nil
 }
 
-func getDirectorySize(path string) (int64, error) {
-	var size int64
-	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
-		if !info.IsDir() {
-			size += info.Size()
-		}
-		return err
-	})
-	return size, err
-}
-
 // GetDirectoryContents converts the directory starting at the provided path into a Directory struct.
+// Deep walks only list the entries Filter matches.
 func GetDirectory(path string, deep bool) (Directory, error) {
+	return getDirectory(path, deep, false)
+}
+
+// GetDirectoryWithHashes walks the directory at path like a deep
+// GetDirectory, also hashing each regular file as it goes
+func GetDirectoryWithHashes(path string) (Directory, error) {
+	return getDirectory(path, true, true)
+}
+
+func getDirectory(path string, deep, hash bool) (Directory, error) {
 	var directory Directory
 	directory.Root = path
-	var err error
+	index, err := GetExtractionIndex(path)
+	if err != nil {
+		return directory, err
+	}
+	directory.Index = index
+	if hash {
+		directory.Hashes = map[string]string{}
+	}
 	if deep {
-		walkFn := func(currPath string, info os.FileInfo, err error) error {
-			newContent := strings.TrimPrefix(currPath, directory.Root)
-			if newContent != "" {
-				directory.Content = append(directory.Content, newContent)
+		visit := func(name, currPath string, info os.FileInfo) {
+			if !Filter.Matches(name, info.IsDir()) {
+				return
+			}
+			directory.Content = append(directory.Content, name)
+			if hash && info.Mode().IsRegular() {
+				fileHash, err := GetFileHash(currPath)
+				if err != nil {
+					logrus.Errorf("Could not hash %s: %s", currPath, err)
+					return
+				}
+				directory.Hashes[name] = fileHash
 			}
-			return nil
 		}
-
-		err = filepath.Walk(path, walkFn)
+		// sizes are computed in the same walk, so entries don't need to be
+		// walked again to get the size of directories
+		walker := newTreeWalker(path, visit)
+		err = walker.walkRoot()
+		directory.Sizes = walker.sizes
+		if err == nil {
+			err = applyDirectorySizes(path, directory.Sizes)
+		}
 	} else {
 		contents, err := ioutil.ReadDir(path)
 		if err != nil {
@@ -114,23 +163,34 @@ func GetDirectory(path string, deep bool) (Directory, error) {
 }
 
 func GetDirectoryEntries(d Directory) []DirectoryEntry {
-	return CreateDirectoryEntries(d.Root, d.Content)
+	return GetDirectoryEntriesForNames(d, d.Content)
 }
 
-func CreateDirectoryEntries(root string, entryNames []string) (entries []DirectoryEntry) {
+// GetDirectoryEntriesForNames creates entries for the given names in d,
+// filling in their metadata from d.Index
+func GetDirectoryEntriesForNames(d Directory, entryNames []string) (entries []DirectoryEntry) {
 	for _, name := range entryNames {
-		entryPath := filepath.Join(root, name)
-		size := GetSize(entryPath)
-
 		entry := DirectoryEntry{
 			Name: name,
-			Size: size,
+			Size: GetEntrySize(d, name),
+		}
+		if metadata, ok := d.Index[name]; ok {
+			entry.Metadata = &metadata
 		}
+		entry.Sha256 = d.Hashes[name]
 		entries = append(entries, entry)
 	}
 	return entries
 }
 
+func CreateDirectoryEntries(root string, entryNames []string) (entries []DirectoryEntry) {
+	index, err := GetExtractionIndex(root)
+	if err != nil {
+		logrus.Warnf("Could not read metadata for %s: %s", root, err)
+	}
+	return GetDirectoryEntriesForNames(Directory{Root: root, Index: index}, entryNames)
+}
+
 func CheckSameSymlink(f1name, f2name string) (bool, error) {
 	link1, err := os.Readlink(f1name)
 	if err != nil {
@@ -159,19 +219,30 @@ func CheckSameFile(f1name, f2name string) (bool, error) {
 	}
 
 	// Next, check file contents
-	f1, err := ioutil.ReadFile(f1name)
+	f1hash, err := GetFileHash(f1name)
 	if err != nil {
 		return false, err
 	}
-	f2, err := ioutil.ReadFile(f2name)
+	f2hash, err := GetFileHash(f2name)
 	if err != nil {
 		return false, err
 	}
+	return f1hash == f2hash, nil
+}
 
-	if !bytes.Equal(f1, f2) {
-		return false, nil
+// GetFileHash returns the hex encoded sha256 of the contents of the file at
+// path, reading it as a stream
+func GetFileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
 	}
-	return true, nil
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 // HasFilepathPrefix checks if the given file path begins with prefix
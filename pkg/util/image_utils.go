@@ -18,6 +18,7 @@ package util
 
 import (
 	"archive/tar"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -32,7 +33,6 @@ import (
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/daemon"
-	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 
@@ -44,21 +44,49 @@ import (
 const (
 	daemonPrefix = "daemon://"
 	remotePrefix = "remote://"
+	ociPrefix    = "oci://"
 
 	tagRegexStr = ".*:([^/]+$)"
+
+	// whiteoutsSuffix names the file next to an extracted layer that holds
+	// the whiteouts found in the layer
+	whiteoutsSuffix = ".whiteouts.json"
+)
+
+// FSRequirement describes which filesystems need to be unpacked for an image.
+// Requirements are combined with a bitwise OR.
+type FSRequirement uint8
+
+const (
+	// RequireConfig retrieves only the manifest and config of an image
+	RequireConfig FSRequirement = 0
+	// RequireRootFS unpacks the flattened filesystem of an image
+	RequireRootFS FSRequirement = 1 << 0
+	// RequireLayers unpacks the filesystem of each layer separately
+	RequireLayers FSRequirement = 1 << 1
+	// RequireChangedFS only needs the paths of the flattened filesystem that
+	// differ from the image it is diffed against, as unpacked by
+	// GetChangedFileSystems. GetImage unpacks the full filesystem for it.
+	RequireChangedFS FSRequirement = 1 << 2
 )
 
 type Layer struct {
 	FSPath string
 	Digest v1.Hash
+	// Whiteouts lists the paths the layer deletes from the layers below it
+	Whiteouts []Whiteout
 }
 
 type Image struct {
-	Image  v1.Image
-	Source string
-	FSPath string
-	Digest v1.Hash
-	Layers []Layer
+	Image    v1.Image
+	Source   string
+	FSPath   string
+	Digest   v1.Hash
+	Layers   []Layer
+	Platform *v1.Platform
+	// Index is the manifest list or OCI index the image was selected from,
+	// if any
+	Index v1.ImageIndex
 }
 
 type ImageHistoryItem struct {
@@ -68,15 +96,19 @@ type ImageHistoryItem struct {
 // GetImageForName retrieves an image by name alone.
 // It does not return layer information, or respect caching.
 func GetImageForName(imageName string) (Image, error) {
-	return GetImage(imageName, false, "")
+	return GetImage(imageName, RequireRootFS, "", nil)
 }
 
 // GetImage infers the source of an image and retrieves a v1.Image reference to it.
-// Once a reference is obtained, it attempts to unpack the v1.Image's reader's contents
-// into a temp directory on the local filesystem.
-func GetImage(imageName string, includeLayers bool, cacheDir string) (Image, error) {
+// If platform is set, the image for that platform is selected from a manifest list,
+// and the image is verified to match it.
+// Once a reference is obtained, it unpacks the filesystems named by requires into a
+// temp directory on the local filesystem. With RequireConfig, nothing is unpacked and
+// FSPath is left empty.
+func GetImage(imageName string, requires FSRequirement, cacheDir string, platform *v1.Platform) (Image, error) {
 	logrus.Infof("retrieving image: %s", imageName)
 	var img v1.Image
+	var index v1.ImageIndex
 	var err error
 	if IsTar(imageName) {
 		start := time.Now()
@@ -103,6 +135,17 @@ func GetImage(imageName string, includeLayers bool, cacheDir string) (Image, err
 		}
 		elapsed := time.Now().Sub(start)
 		logrus.Infof("retrieving local image ref took %f seconds", elapsed.Seconds())
+	} else if strings.HasPrefix(imageName, ociPrefix) {
+		// remove the oci prefix
+		imageName = strings.Replace(imageName, ociPrefix, "", -1)
+
+		start := time.Now()
+		img, index, err = getOCILayoutImage(imageName, platform)
+		if err != nil {
+			return Image{}, errors.Wrap(err, "retrieving image from OCI layout")
+		}
+		elapsed := time.Now().Sub(start)
+		logrus.Infof("retrieving OCI layout image ref took %f seconds", elapsed.Seconds())
 	} else {
 		// either has remote prefix or has no prefix, in which case we force remote
 		imageName = strings.Replace(imageName, remotePrefix, "", -1)
@@ -110,12 +153,26 @@ func GetImage(imageName string, includeLayers bool, cacheDir string) (Image, err
 		if err != nil {
 			return Image{}, errors.Wrap(err, "parsing image reference")
 		}
-		auth, err := authn.DefaultKeychain.Resolve(ref.Context().Registry)
+		opts, err := remoteOptions(ref)
 		if err != nil {
-			return Image{}, errors.Wrap(err, "resolving auth")
+			return Image{}, err
+		}
+		if platform != nil {
+			opts = append(opts, remote.WithPlatform(*platform))
 		}
 		start := time.Now()
-		img, err = remote.Image(ref, remote.WithAuth(auth), remote.WithTransport(BuildTransport(ref.Context().Registry)))
+		desc, err := remote.Get(ref, opts...)
+		if err != nil {
+			return Image{}, errors.Wrap(err, "retrieving remote image")
+		}
+		if desc.MediaType.IsIndex() {
+			index, err = desc.ImageIndex()
+			if err != nil {
+				return Image{}, errors.Wrap(err, "retrieving remote index")
+			}
+		}
+		// resolves an index to the image for the requested (or default) platform
+		img, err = desc.Image()
 		if err != nil {
 			return Image{}, errors.Wrap(err, "retrieving remote image")
 		}
@@ -123,9 +180,36 @@ func GetImage(imageName string, includeLayers bool, cacheDir string) (Image, err
 		logrus.Infof("retrieving remote image ref took %f seconds", elapsed.Seconds())
 	}
 
+	imageDigest, err := getImageDigest(img)
+	if err != nil {
+		return Image{}, err
+	}
+	source := imageName
+	if platform != nil {
+		// an image resolved from an index has the platform of its descriptor
+		var imagePlatform *v1.Platform
+		if index != nil {
+			if imagePlatform, err = indexPlatform(index, imageDigest); err != nil {
+				return Image{}, err
+			}
+		}
+		if imagePlatform == nil {
+			// the daemon and tarballs hold a single platform, and registries may
+			// return a plain manifest for any requested platform, so double check
+			if imagePlatform, err = getImagePlatform(img); err != nil {
+				return Image{}, err
+			}
+			if !configMatchesPlatform(*imagePlatform, *platform) {
+				return Image{}, fmt.Errorf("image %s is %s, not %s", imageName, imagePlatform.String(), platform.String())
+			}
+		}
+		platform = imagePlatform
+		source = fmt.Sprintf("%s (%s)", imageName, platform.String())
+	}
+
 	// create tempdir and extract fs into it
 	var layers []Layer
-	if includeLayers {
+	if requires&RequireLayers != 0 {
 		start := time.Now()
 		imgLayers, err := img.Layers()
 		if err != nil {
@@ -134,7 +218,7 @@ func GetImage(imageName string, includeLayers bool, cacheDir string) (Image, err
 		for _, layer := range imgLayers {
 			layerStart := time.Now()
 			digest, err := layer.Digest()
-			path, err := getExtractPathForName(digest.String(), cacheDir)
+			path, err := getExtractPathForName(digest.String()+filterSuffix(), cacheDir)
 			if err != nil {
 				return Image{
 					Layers: layers,
@@ -145,9 +229,16 @@ func GetImage(imageName string, includeLayers bool, cacheDir string) (Image, err
 					Layers: layers,
 				}, errors.Wrap(err, "getting filesystem for layer")
 			}
+			whiteouts, err := GetWhiteoutsForLayer(path)
+			if err != nil {
+				return Image{
+					Layers: layers,
+				}, errors.Wrap(err, "getting whiteouts for layer")
+			}
 			layers = append(layers, Layer{
-				FSPath: path,
-				Digest: digest,
+				FSPath:    path,
+				Digest:    digest,
+				Whiteouts: whiteouts,
 			})
 			elapsed := time.Now().Sub(layerStart)
 			logrus.Infof("time elapsed retrieving layer: %fs", elapsed.Seconds())
@@ -156,41 +247,152 @@ func GetImage(imageName string, includeLayers bool, cacheDir string) (Image, err
 		logrus.Infof("time elapsed retrieving image layers: %fs", elapsed.Seconds())
 	}
 
-	imageDigest, err := getImageDigest(img)
+	var path string
+	if requires&(RequireRootFS|RequireChangedFS) != 0 {
+		path, err = getExtractPathForName(RemoveTag(imageName)+"@"+imageDigest.String()+filterSuffix(), cacheDir)
+		if err != nil {
+			return Image{}, err
+		}
+		// extract fs into provided dir
+		if err := GetFileSystemForImage(img, path, nil); err != nil {
+			return Image{
+				FSPath: path,
+				Layers: layers,
+			}, errors.Wrap(err, "getting filesystem for image")
+		}
+	} else {
+		logrus.Infof("skipping filesystem extraction for %s", imageName)
+	}
+	return Image{
+		Image:    img,
+		Source:   source,
+		FSPath:   path,
+		Digest:   imageDigest,
+		Layers:   layers,
+		Platform: platform,
+		Index:    index,
+	}, nil
+}
+
+// GetImageIndex retrieves the manifest list or OCI index that imageName refers to.
+// Only remote references and OCI layouts can refer to an index.
+func GetImageIndex(imageName string) (v1.ImageIndex, error) {
+	if IsTar(imageName) || strings.HasPrefix(imageName, daemonPrefix) {
+		return nil, fmt.Errorf("%s does not refer to a manifest list or index", imageName)
+	}
+	if strings.HasPrefix(imageName, ociPrefix) {
+		return getOCILayoutIndex(imageName)
+	}
+	imageName = strings.Replace(imageName, remotePrefix, "", -1)
+	ref, err := name.ParseReference(imageName, name.WeakValidation)
 	if err != nil {
-		return Image{}, err
+		return nil, errors.Wrap(err, "parsing image reference")
 	}
-	path, err := getExtractPathForName(RemoveTag(imageName)+"@"+imageDigest.String(), cacheDir)
+	opts, err := remoteOptions(ref)
 	if err != nil {
-		return Image{}, err
+		return nil, err
 	}
-	// extract fs into provided dir
-	if err := GetFileSystemForImage(img, path, nil); err != nil {
-		return Image{
-			FSPath: path,
-			Layers: layers,
-		}, errors.Wrap(err, "getting filesystem for image")
+	index, err := remote.Index(ref, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving remote index")
 	}
-	return Image{
-		Image:  img,
-		Source: imageName,
-		FSPath: path,
-		Digest: imageDigest,
-		Layers: layers,
+	return index, nil
+}
+
+// GetImagePlatforms returns the platforms available in the manifest list or
+// OCI index that imageName refers to, skipping entries without a platform
+// (such as build attestations).
+func GetImagePlatforms(imageName string) ([]v1.Platform, error) {
+	index, err := GetImageIndex(imageName)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading index manifest")
+	}
+	var platforms []v1.Platform
+	for _, desc := range manifest.Manifests {
+		if desc.Platform == nil || desc.Platform.OS == "unknown" {
+			continue
+		}
+		platforms = append(platforms, *desc.Platform)
+	}
+	return platforms, nil
+}
+
+func remoteOptions(ref name.Reference) ([]remote.Option, error) {
+	auth, err := authn.DefaultKeychain.Resolve(ref.Context().Registry)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving auth")
+	}
+	return []remote.Option{remote.WithAuth(auth), remote.WithTransport(BuildTransport(ref.Context().Registry))}, nil
+}
+
+// getImagePlatform returns the platform recorded in the image's config
+func getImagePlatform(img v1.Image) (*v1.Platform, error) {
+	cf, err := img.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting image config")
+	}
+	return &v1.Platform{
+		OS:           cf.OS,
+		Architecture: cf.Architecture,
+		Variant:      cf.Variant,
+		OSVersion:    cf.OSVersion,
 	}, nil
 }
 
+// configMatchesPlatform checks the platform recorded in an image's config
+// against the requested one. Configs often leave out the variant and the OS
+// version and features, so only the OS and architecture must match, and the
+// variant if the config has one.
+func configMatchesPlatform(config, want v1.Platform) bool {
+	if config.OS != want.OS || config.Architecture != want.Architecture {
+		return false
+	}
+	return config.Variant == "" || want.Variant == "" || config.Variant == want.Variant
+}
+
+// indexPlatform returns the platform of the descriptor of the image with
+// digest in index, or in the indexes it nests, or nil if there is none
+func indexPlatform(index v1.ImageIndex, digest v1.Hash) (*v1.Platform, error) {
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading index manifest")
+	}
+	for _, desc := range manifest.Manifests {
+		if desc.Digest == digest && desc.MediaType.IsImage() {
+			return desc.Platform, nil
+		}
+		if !desc.MediaType.IsIndex() {
+			continue
+		}
+		child, err := index.ImageIndex(desc.Digest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "retrieving index %s", desc.Digest)
+		}
+		if p, err := indexPlatform(child, digest); p != nil || err != nil {
+			return p, err
+		}
+	}
+	return nil, nil
+}
+
 func getExtractPathForName(name string, cacheDir string) (string, error) {
-	path := cacheDir
+	var path string
 	var err error
 	if cacheDir != "" {
-		// if cachedir doesn't exist, create it
-		if _, err := os.Stat(cacheDir); err != nil && os.IsNotExist(err) {
-			err = os.MkdirAll(cacheDir, 0700)
+		// key each filesystem by name, so that layers and images resolved to
+		// different digests (e.g. for different platforms) don't collide
+		path = filepath.Join(cacheDir, CleanFilePath(strings.Replace(name, string(os.PathSeparator), "", -1)))
+		// if the cache path doesn't exist, create it
+		if _, err := os.Stat(path); err != nil && os.IsNotExist(err) {
+			err = os.MkdirAll(path, 0700)
 			if err != nil {
 				return "", err
 			}
-			logrus.Infof("caching filesystem at %s", cacheDir)
+			logrus.Infof("caching filesystem at %s", path)
 		}
 	} else {
 		// otherwise, create tempdir
@@ -203,6 +405,15 @@ func getExtractPathForName(name string, cacheDir string) (string, error) {
 	return path, nil
 }
 
+// filterSuffix tells apart the cached filesystems extracted with different
+// path filters
+func filterSuffix() string {
+	if key := Filter.Key(); key != "" {
+		return "-filtered-" + key
+	}
+	return ""
+}
+
 func getImageDigest(image v1.Image) (digest v1.Hash, err error) {
 	start := time.Now()
 	digest, err = image.Digest()
@@ -220,12 +431,22 @@ func CleanupImage(image Image) {
 		if err := os.RemoveAll(image.FSPath); err != nil {
 			logrus.Warn(err.Error())
 		}
+		for _, suffix := range []string{indexSuffix, sizesSuffix} {
+			if err := os.RemoveAll(sidecarPath(image.FSPath, suffix)); err != nil {
+				logrus.Warn(err.Error())
+			}
+		}
 	}
 	if image.Layers != nil {
 		for _, layer := range image.Layers {
 			if err := os.RemoveAll(layer.FSPath); err != nil {
 				logrus.Warn(err.Error())
 			}
+			for _, suffix := range []string{indexSuffix, whiteoutsSuffix} {
+				if err := os.RemoveAll(sidecarPath(layer.FSPath, suffix)); err != nil {
+					logrus.Warn(err.Error())
+				}
+			}
 		}
 	}
 }
@@ -239,38 +460,92 @@ func SortMap(m map[string]string) string {
 	return strings.Join(pairs, " ")
 }
 
-// GetFileSystemForLayer unpacks a layer to local disk
+// GetFileSystemForLayer unpacks a layer to local disk, leaving out the paths
+// Filter doesn't match. Whiteouts in the layer
+// are not extracted, but recorded next to root to be read with
+// GetWhiteoutsForLayer, along with the metadata index read by
+// GetExtractionIndex.
 func GetFileSystemForLayer(layer v1.Layer, root string, whitelist []string) error {
-	empty, err := DirIsEmpty(root)
+	cached, err := useCachedFileSystem(root, indexSuffix, whiteoutsSuffix)
+	if err != nil || cached {
+		return err
+	}
+	contents, err := layer.Uncompressed()
+	if err != nil {
+		return err
+	}
+	defer contents.Close()
+	index, whiteouts, err := unpackTar(tar.NewReader(contents), root, whitelist, Filter)
 	if err != nil {
 		return err
 	}
-	if !empty {
-		logrus.Infof("using cached filesystem in %s", root)
-		return nil
+	if err := writeExtractionIndex(root, index); err != nil {
+		return err
 	}
-	contents, err := layer.Uncompressed()
+	if whiteouts == nil {
+		whiteouts = []Whiteout{}
+	}
+	whiteoutsJSON, err := json.Marshal(whiteouts)
 	if err != nil {
 		return err
 	}
-	return unpackTar(tar.NewReader(contents), root, whitelist)
+	return ioutil.WriteFile(sidecarPath(root, whiteoutsSuffix), whiteoutsJSON, 0600)
+}
+
+// GetWhiteoutsForLayer returns the whiteouts recorded when extracting a
+// layer to root
+func GetWhiteoutsForLayer(root string) ([]Whiteout, error) {
+	contents, err := ioutil.ReadFile(sidecarPath(root, whiteoutsSuffix))
+	if err != nil {
+		return nil, err
+	}
+	var whiteouts []Whiteout
+	if err := json.Unmarshal(contents, &whiteouts); err != nil {
+		return nil, errors.Wrapf(err, "reading whiteouts for %s", root)
+	}
+	return whiteouts, nil
 }
 
-// unpack image filesystem to local disk
+// unpack image filesystem to local disk, leaving out the paths Filter doesn't match
 // if provided directory is not empty, do nothing
 func GetFileSystemForImage(image v1.Image, root string, whitelist []string) error {
-	empty, err := DirIsEmpty(root)
-	if err != nil {
+	cached, err := useCachedFileSystem(root, indexSuffix)
+	if err != nil || cached {
 		return err
 	}
-	if !empty {
-		logrus.Infof("using cached filesystem in %s", root)
-		return nil
+	layers, err := image.Layers()
+	if err != nil {
+		return errors.Wrap(err, "getting image layers")
 	}
-	if err := unpackTar(tar.NewReader(mutate.Extract(image)), root, whitelist); err != nil {
-		return err
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(flattenLayers(layers, w))
+	}()
+	err = unpackFlattened(r, root, whitelist)
+	// stop the layers from being read if unpacking failed
+	r.CloseWithError(err)
+	return err
+}
+
+// useCachedFileSystem reports whether root already holds an extracted
+// filesystem, along with the given sidecar files. Filesystems cached by
+// older versions without the sidecars are removed to be extracted again.
+func useCachedFileSystem(root string, sidecars ...string) (bool, error) {
+	empty, err := DirIsEmpty(root)
+	if err != nil || empty {
+		return false, err
 	}
-	return nil
+	for _, suffix := range sidecars {
+		if _, err := os.Stat(sidecarPath(root, suffix)); err != nil {
+			logrus.Infof("re-extracting cached filesystem in %s", root)
+			if err := os.RemoveAll(root); err != nil {
+				return false, err
+			}
+			return false, os.MkdirAll(root, 0700)
+		}
+	}
+	logrus.Infof("using cached filesystem in %s", root)
+	return true, nil
 }
 
 func GetImageLayers(pathToImage string) []string {
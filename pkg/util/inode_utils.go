@@ -0,0 +1,48 @@
+//go:build !windows
+
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"os"
+	"syscall"
+)
+
+// Inode identifies a file with several hard links
+type Inode struct {
+	dev uint64
+	ino uint64
+}
+
+// getInode returns the inode of info if the file has other hard links
+func getInode(info os.FileInfo) (Inode, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return Inode{}, false
+	}
+	return Inode{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
+
+// getDiskUsage returns the space allocated to the file described by info
+func getDiskUsage(info os.FileInfo) int64 {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.Size()
+	}
+	return int64(stat.Blocks) * 512
+}
@@ -0,0 +1,642 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	opaqueWhiteout = ".wh..wh..opq"
+)
+
+// ChangedPaths records the paths a set of layers can have changed. Paths are
+// absolute within the image filesystem, e.g. /etc/passwd.
+type ChangedPaths struct {
+	// paths that were touched directly, along with their parent directories
+	paths map[string]bool
+	// paths whose whole subtree may have changed: whiteouts, opaque
+	// directories, and non-directories that may have replaced a directory
+	subtrees map[string]bool
+}
+
+func NewChangedPaths() *ChangedPaths {
+	return &ChangedPaths{
+		paths:    map[string]bool{},
+		subtrees: map[string]bool{},
+	}
+}
+
+// Add records that p was touched. If subtree is set, everything below p is
+// considered changed as well.
+func (c *ChangedPaths) Add(p string, subtree bool) {
+	p = path.Clean("/" + p)
+	if subtree {
+		c.subtrees[p] = true
+	}
+	for ; p != "/"; p = path.Dir(p) {
+		c.paths[p] = true
+	}
+}
+
+func (c *ChangedPaths) empty() bool {
+	return len(c.paths) == 0 && len(c.subtrees) == 0
+}
+
+// SharedLayerPrefix returns the number of leading layers two images have in
+// common, compared by the uncompressed digests recorded in their configs.
+func SharedLayerPrefix(img1, img2 v1.Image) (int, error) {
+	cf1, err := img1.ConfigFile()
+	if err != nil {
+		return 0, errors.Wrap(err, "getting image config")
+	}
+	cf2, err := img2.ConfigFile()
+	if err != nil {
+		return 0, errors.Wrap(err, "getting image config")
+	}
+	diffIDs1, diffIDs2 := cf1.RootFS.DiffIDs, cf2.RootFS.DiffIDs
+	shared := 0
+	for shared < len(diffIDs1) && shared < len(diffIDs2) && diffIDs1[shared] == diffIDs2[shared] {
+		shared++
+	}
+	return shared, nil
+}
+
+// Contains reports whether p was touched, or is below a path whose whole
+// subtree may have changed
+func (c *ChangedPaths) Contains(p string) bool {
+	p = path.Clean("/" + p)
+	if c.paths[p] {
+		return true
+	}
+	for ; p != "/"; p = path.Dir(p) {
+		if c.subtrees[p] {
+			return true
+		}
+	}
+	return false
+}
+
+// addHeader records the paths touched by an entry of a layer
+func (c *ChangedPaths) addHeader(header *tar.Header) {
+	if whiteout, ok := getWhiteout(header.Name); ok {
+		c.Add(whiteout.Path, true)
+		return
+	}
+	// a directory is merged with what is below it, anything else
+	// replaces it
+	c.Add(header.Name, header.Typeflag != tar.TypeDir)
+	if header.Typeflag == tar.TypeLink {
+		// a hard link can only be created along with its target
+		c.Add(header.Linkname, false)
+	}
+}
+
+// GetChangedFileSystems unpacks to the FSPath of each image the paths of its
+// flattened filesystem that are touched by the layers after the ones the two
+// images share, caching them in cacheDir1 and cacheDir2 if set. Every layer
+// is read once: the layers only one image has come first and give the
+// paths to unpack from the shared layers, which are read for both images at
+// once. Images that share no layers are unpacked in full.
+func GetChangedFileSystems(image1, image2 *Image, cacheDir1, cacheDir2 string) error {
+	shared, err := SharedLayerPrefix(image1.Image, image2.Image)
+	if err != nil {
+		return err
+	}
+	layers1, err := image1.Image.Layers()
+	if err != nil {
+		return errors.Wrap(err, "getting image layers")
+	}
+	layers2, err := image2.Image.Layers()
+	if err != nil {
+		return errors.Wrap(err, "getting image layers")
+	}
+	if shared > len(layers1) || shared > len(layers2) {
+		return errors.New("image config lists more layers than its manifest")
+	}
+
+	image1.FSPath, err = getExtractPathForName(changedFileSystemName(image1, image2), cacheDir1)
+	if err != nil {
+		return err
+	}
+	image2.FSPath, err = getExtractPathForName(changedFileSystemName(image2, image1), cacheDir2)
+	if err != nil {
+		return err
+	}
+	// with no layers of their own, nothing differs
+	if shared == len(layers1) && shared == len(layers2) {
+		for _, root := range []string{image1.FSPath, image2.FSPath} {
+			if err := writeExtractionIndex(root, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	cached := 0
+	for _, root := range []string{image1.FSPath, image2.FSPath} {
+		ok, err := useCachedFileSystem(root, indexSuffix, sizesSuffix)
+		if err != nil {
+			return err
+		}
+		if ok {
+			cached++
+		}
+	}
+	if cached == 2 {
+		return nil
+	}
+	if cached == 1 {
+		// both trees depend on the paths read from the layers of each image
+		for _, root := range []string{image1.FSPath, image2.FSPath} {
+			if err := os.RemoveAll(root); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(root, 0700); err != nil {
+				return err
+			}
+		}
+	}
+
+	logrus.Infof("unpacking paths touched by %d and %d layers after %d shared layers", len(layers1)-shared, len(layers2)-shared, shared)
+	errs := make(chan error, 2)
+	var writers []*io.PipeWriter
+	for _, root := range []string{image1.FSPath, image2.FSPath} {
+		r, w := io.Pipe()
+		writers = append(writers, w)
+		go func(r *io.PipeReader, root string) {
+			err := unpackFlattened(r, root, nil)
+			// stop the layers from being read if unpacking failed
+			r.CloseWithError(err)
+			errs <- err
+		}(r, root)
+	}
+	sizes, err := flattenChangedLayers(layers1, layers2, shared, writers[0], writers[1])
+	for _, w := range writers {
+		w.CloseWithError(err)
+	}
+	for range writers {
+		if unpackErr := <-errs; unpackErr != nil && err == nil {
+			err = unpackErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	for n, root := range []string{image1.FSPath, image2.FSPath} {
+		if err := writeDirectorySizes(root, sizes[n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changedFileSystemName names the tree GetChangedFileSystems unpacks for
+// image when it is diffed against other
+func changedFileSystemName(image, other *Image) string {
+	return "changed-" + image.Digest.String() + "-vs-" + other.Digest.String() + filterSuffix()
+}
+
+// unpackFlattened extracts the flattened filesystem read from r to root.
+// It holds no whiteouts.
+func unpackFlattened(r io.Reader, root string, whitelist []string) error {
+	index, _, err := unpackTar(tar.NewReader(r), root, whitelist, Filter)
+	if err != nil {
+		return err
+	}
+	// drain the end of the archive, so the writer isn't left blocked
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return err
+	}
+	return writeExtractionIndex(root, index)
+}
+
+// flattenLayers writes the flattened filesystem of layers to w
+func flattenLayers(layers []v1.Layer, w io.Writer) error {
+	f := newLayerFlattener(w)
+	for i := len(layers) - 1; i >= 0; i-- {
+		err := flattenLayer(layers[i], i, []*layerFlattener{f}, func(*tar.Header) bool { return true })
+		if err != nil {
+			return err
+		}
+	}
+	return f.tw.Close()
+}
+
+// flattenChangedLayers writes the flattened filesystems of two images that
+// share their first shared layers to w1 and w2, leaving out the entries of
+// the shared layers that the layers after them don't touch. The sizes of the
+// directories of both full filesystems are returned, as the directories
+// written only hold part of their contents.
+func flattenChangedLayers(layers1, layers2 []v1.Layer, shared int, w1, w2 io.Writer) ([]SizeIndex, error) {
+	flatteners := []*layerFlattener{newLayerFlattener(w1), newLayerFlattener(w2)}
+	for _, f := range flatteners {
+		f.sizes, f.links = map[string]int64{}, map[string]string{}
+	}
+	changed := NewChangedPaths()
+	for n, layers := range [][]v1.Layer{layers1, layers2} {
+		f := flatteners[n : n+1]
+		logrus.Infof("reading paths from %d of %d layers", len(layers)-shared, len(layers))
+		for i := len(layers) - 1; i >= shared; i-- {
+			err := flattenLayer(layers[i], i, f, func(header *tar.Header) bool {
+				changed.addHeader(header)
+				return true
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !changed.empty() {
+		keep := func(header *tar.Header) bool {
+			if header.Typeflag != tar.TypeLink {
+				return changed.Contains(header.Name)
+			}
+			// a hard link goes with its target, which may be in a layer
+			// below, or hidden in one image only
+			if !changed.Contains(header.Name) && !changed.Contains(header.Linkname) {
+				return false
+			}
+			changed.Add(header.Linkname, false)
+			return true
+		}
+		for i := shared - 1; i >= 0; i-- {
+			if err := flattenLayer(layers1[i], i, flatteners, keep); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var sizes []SizeIndex
+	for _, f := range flatteners {
+		if err := f.tw.Close(); err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, f.directorySizes())
+	}
+	return sizes, nil
+}
+
+// flattenLayer writes the entries of layer i that are visible to each of
+// flatteners and that keep accepts. keep is called once with every entry.
+// A hard link is held back until its target is written: if the first read
+// leaves out the target, as keep didn't accept it or a layer above hides it,
+// the layer is read again for it. A hidden target is written under the name
+// of its first link, which keeps the contents the layer gave it.
+func flattenLayer(layer v1.Layer, i int, flatteners []*layerFlattener, keep func(header *tar.Header) bool) error {
+	// seen holds the entries of the layer other than directories, and
+	// written maps those visible to each flattener to whether they were kept
+	seen := map[string]bool{}
+	written := make([]map[string]bool, len(flatteners))
+	// held holds the hard links of each flattener by target
+	held := make([]map[string][]*tar.Header, len(flatteners))
+	for n := range flatteners {
+		written[n] = map[string]bool{}
+		held[n] = map[string][]*tar.Header{}
+	}
+	reread := false
+	err := readLayer(layer, func(header *tar.Header, tr *tar.Reader) error {
+		kept := keep(header)
+		name := path.Clean("/" + header.Name)
+		var tws []*tar.Writer
+		for n, f := range flatteners {
+			if !f.next(header, i) {
+				continue
+			}
+			if header.Typeflag != tar.TypeDir {
+				written[n][name] = kept
+			}
+			if !kept {
+				continue
+			}
+			// a target that comes later, or from a layer below, is
+			// left to the unpacking
+			if header.Typeflag == tar.TypeLink {
+				target := path.Clean("/" + header.Linkname)
+				if seen[target] && !written[n][target] {
+					held[n][target] = append(held[n][target], header)
+					reread = true
+					continue
+				}
+			}
+			tws = append(tws, f.tw)
+		}
+		if header.Typeflag != tar.TypeDir {
+			seen[name] = true
+		}
+		return writeFlattenedEntry(header, tr, tws...)
+	})
+	if err != nil || !reread {
+		return err
+	}
+
+	logrus.Infof("reading layer %d again for the targets of hard links", i)
+	return readLayer(layer, func(header *tar.Header, tr *tar.Reader) error {
+		target := path.Clean("/" + header.Name)
+		needed := false
+		for n := range flatteners {
+			needed = needed || len(held[n][target]) > 0
+		}
+		if !needed {
+			return nil
+		}
+		var headers []*tar.Header
+		var tws, linked []*tar.Writer
+		var links [][]*tar.Header
+		for n, f := range flatteners {
+			waiting := held[n][target]
+			delete(held[n], target)
+			if kept, visible := written[n][target]; !visible && len(waiting) > 0 {
+				first := *header
+				first.Name = waiting[0].Name
+				headers, tws = append(headers, &first), append(tws, f.tw)
+				for _, link := range waiting[1:] {
+					link.Linkname = first.Name
+				}
+				f.detach(&first, waiting[1:])
+				waiting = waiting[1:]
+			} else if visible && !kept {
+				// every image that left it out gets it, for the diff
+				headers, tws = append(headers, header), append(tws, f.tw)
+			}
+			linked, links = append(linked, f.tw), append(links, waiting)
+		}
+		if err := writeFlattenedCopies(tr, headers, tws); err != nil {
+			return err
+		}
+		for n, tw := range linked {
+			for _, link := range links[n] {
+				if err := writeFlattenedEntry(link, nil, tw); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// readLayer calls visit with each entry of the uncompressed layer
+func readLayer(layer v1.Layer, visit func(header *tar.Header, tr *tar.Reader) error) error {
+	contents, err := layer.Uncompressed()
+	if err != nil {
+		return errors.Wrap(err, "reading layer")
+	}
+	defer contents.Close()
+	tr := tar.NewReader(contents)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "reading layer tar header")
+		}
+		if err := visit(header, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func writeFlattenedEntry(header *tar.Header, body io.Reader, tws ...*tar.Writer) error {
+	headers := make([]*tar.Header, len(tws))
+	for i := range tws {
+		headers[i] = header
+	}
+	return writeFlattenedCopies(body, headers, tws)
+}
+
+// writeFlattenedCopies writes the entry read from body to each of tws under
+// the matching header. The headers only differ in name.
+func writeFlattenedCopies(body io.Reader, headers []*tar.Header, tws []*tar.Writer) error {
+	if len(tws) == 0 {
+		return nil
+	}
+	writers := make([]io.Writer, len(tws))
+	for i, tw := range tws {
+		// PAX lifts the length limits USTAR puts on names
+		headers[i].Format = tar.FormatPAX
+		if err := tw.WriteHeader(headers[i]); err != nil {
+			return err
+		}
+		writers[i] = tw
+	}
+	header := headers[0]
+	if header.Typeflag != tar.TypeReg || header.Size == 0 {
+		return nil
+	}
+	_, err := io.CopyN(io.MultiWriter(writers...), body, header.Size)
+	return err
+}
+
+// layerFlattener tracks which entries of the layers of an image are visible
+// in its flattened filesystem. As in mutate.Extract, layers are read from the
+// top down, so an entry is visible unless a layer above has written or
+// deleted it or one of its parents.
+type layerFlattener struct {
+	tw *tar.Writer
+	// hidden maps the paths written or deleted by the layers read so far to
+	// whether they also hide everything below them
+	hidden map[string]bool
+	// opaque maps the directories made opaque to the index of the layer
+	// that did it
+	opaque map[string]int
+	// sizes, if set, records the apparent size of every visible entry
+	// other than directories, written or not, and links the targets of
+	// the visible hard links
+	sizes map[string]int64
+	links map[string]string
+}
+
+func newLayerFlattener(w io.Writer) *layerFlattener {
+	return &layerFlattener{
+		tw:     tar.NewWriter(w),
+		hidden: map[string]bool{},
+		opaque: map[string]int{},
+	}
+}
+
+// next records the entry of layer i read from header, and reports whether it
+// is visible in the flattened filesystem. Whiteouts never are.
+func (f *layerFlattener) next(header *tar.Header, i int) bool {
+	if whiteout, ok := getWhiteout(header.Name); ok {
+		if whiteout.Opaque {
+			// only the layers below lose the contents of the directory
+			if _, ok := f.opaque[whiteout.Path]; !ok && f.visible(path.Join(whiteout.Path, opaqueWhiteout), i) {
+				f.opaque[whiteout.Path] = i
+			}
+		} else if f.visible(whiteout.Path, i) {
+			f.hidden[whiteout.Path] = true
+		}
+		return false
+	}
+	name := path.Clean("/" + header.Name)
+	if !f.visible(name, i) {
+		return false
+	}
+	f.hidden[name] = header.Typeflag != tar.TypeDir
+	if f.sizes != nil && header.Typeflag != tar.TypeDir && Filter.Matches(name, false) {
+		f.sizes[name] = apparentSize(header)
+		if header.Typeflag == tar.TypeLink {
+			f.links[name] = path.Clean("/" + header.Linkname)
+		}
+	}
+	return true
+}
+
+// detach records that the hard link written as header holds the contents of
+// its hidden target, which the hard links in others now link to
+func (f *layerFlattener) detach(header *tar.Header, others []*tar.Header) {
+	name := path.Clean("/" + header.Name)
+	if _, ok := f.sizes[name]; !ok {
+		return
+	}
+	f.sizes[name] = apparentSize(header)
+	delete(f.links, name)
+	for _, link := range others {
+		if other := path.Clean("/" + link.Name); f.links[other] != "" {
+			f.links[other] = name
+		}
+	}
+}
+
+// apparentSize returns the size GetSizeIndex gives to the entry of header,
+// other than a directory, once unpacked. Hard links are counted with their
+// target.
+func apparentSize(header *tar.Header) int64 {
+	switch header.Typeflag {
+	case tar.TypeReg:
+		return header.Size
+	case tar.TypeSymlink:
+		return int64(len(header.Linkname))
+	default:
+		return 0
+	}
+}
+
+// directorySizes returns the size of each directory of the flattened
+// filesystem from the sizes recorded, as GetSizeIndex computes it once the
+// filesystem is unpacked: a file with several hard links counts towards the
+// directory of the name walked first.
+func (f *layerFlattener) directorySizes() SizeIndex {
+	groups := map[string][]string{}
+	for link, target := range f.links {
+		if _, ok := f.sizes[target]; ok {
+			groups[target] = append(groups[target], link)
+		}
+	}
+	sizes := make(map[string]int64, len(f.sizes))
+	for name, size := range f.sizes {
+		sizes[name] = size
+	}
+	for target, links := range groups {
+		first := target
+		for _, link := range links {
+			if walkedBefore(link, first) {
+				first = link
+			}
+		}
+		if first != target {
+			sizes[first], sizes[target] = sizes[target], 0
+		}
+	}
+
+	dirs := SizeIndex{}
+	for name, size := range sizes {
+		for dir := path.Dir(name); ; dir = path.Dir(dir) {
+			dirs[dir] += size
+			if dir == "/" {
+				break
+			}
+		}
+	}
+	return dirs
+}
+
+// walkedBefore reports whether a comes before b in a walk of the tree
+// holding them, which lists the entries of each directory by name
+func walkedBefore(a, b string) bool {
+	elems1, elems2 := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(elems1) && i < len(elems2); i++ {
+		if elems1[i] != elems2[i] {
+			return elems1[i] < elems2[i]
+		}
+	}
+	return len(elems1) < len(elems2)
+}
+
+func (f *layerFlattener) visible(name string, i int) bool {
+	if _, ok := f.hidden[name]; ok {
+		return false
+	}
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if f.hidden[dir] {
+			return false
+		}
+		if layer, ok := f.opaque[dir]; ok && layer > i {
+			return false
+		}
+		if dir == "/" {
+			return true
+		}
+	}
+}
+
+// ApplyWhiteouts removes the entries deleted by whiteouts from lower, the
+// entries of the layers below keyed by name, and returns them sorted by name.
+func ApplyWhiteouts(lower map[string]DirectoryEntry, whiteouts []Whiteout) []DirectoryEntry {
+	if len(whiteouts) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(lower))
+	for name := range lower {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	deleted := map[string]bool{}
+	for _, whiteout := range whiteouts {
+		if !whiteout.Opaque {
+			if _, ok := lower[whiteout.Path]; ok {
+				deleted[whiteout.Path] = true
+			}
+		}
+		// everything below the path is contiguous in the sorted names
+		prefix := strings.TrimSuffix(whiteout.Path, "/") + "/"
+		for i := sort.SearchStrings(names, prefix); i < len(names) && strings.HasPrefix(names[i], prefix); i++ {
+			deleted[names[i]] = true
+		}
+	}
+
+	var entries []DirectoryEntry
+	for _, name := range names {
+		if deleted[name] {
+			entries = append(entries, lower[name])
+			delete(lower, name)
+		}
+	}
+	return entries
+}
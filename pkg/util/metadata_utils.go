@@ -0,0 +1,139 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// indexSuffix names the file next to an extracted tree that holds the
+	// metadata of its entries
+	indexSuffix = ".index.json"
+
+	xattrPAXPrefix = "SCHILY.xattr."
+)
+
+// Entry types recorded in EntryMetadata
+const (
+	TypeFile     = "file"
+	TypeDir      = "dir"
+	TypeSymlink  = "symlink"
+	TypeHardlink = "hardlink"
+	TypeChar     = "char"
+	TypeBlock    = "block"
+	TypeFifo     = "fifo"
+)
+
+// EntryMetadata holds the metadata of an entry as recorded in the tar it was
+// extracted from. Ownership, permissions, xattrs and special files can't
+// always be reproduced on disk (e.g. when not running as root), so these are
+// the real values.
+type EntryMetadata struct {
+	Type     string
+	Mode     os.FileMode
+	Uid      int
+	Gid      int
+	Linkname string            `json:",omitempty"`
+	Devmajor int64             `json:",omitempty"`
+	Devminor int64             `json:",omitempty"`
+	Xattrs   map[string][]byte `json:",omitempty"`
+}
+
+// ExtractionIndex maps the entries of an extracted tree, named as in
+// Directory.Content, to their metadata
+type ExtractionIndex map[string]EntryMetadata
+
+func getEntryMetadata(header *tar.Header) EntryMetadata {
+	metadata := EntryMetadata{
+		Mode: header.FileInfo().Mode(),
+		Uid:  header.Uid,
+		Gid:  header.Gid,
+	}
+	switch header.Typeflag {
+	case tar.TypeDir:
+		metadata.Type = TypeDir
+	case tar.TypeSymlink:
+		metadata.Type = TypeSymlink
+		metadata.Linkname = header.Linkname
+	case tar.TypeLink:
+		metadata.Type = TypeHardlink
+		metadata.Linkname = header.Linkname
+	case tar.TypeChar:
+		metadata.Type = TypeChar
+	case tar.TypeBlock:
+		metadata.Type = TypeBlock
+	case tar.TypeFifo:
+		metadata.Type = TypeFifo
+	default:
+		metadata.Type = TypeFile
+	}
+	if header.Typeflag == tar.TypeChar || header.Typeflag == tar.TypeBlock {
+		metadata.Devmajor = header.Devmajor
+		metadata.Devminor = header.Devminor
+	}
+	for key, value := range header.PAXRecords {
+		if strings.HasPrefix(key, xattrPAXPrefix) {
+			if metadata.Xattrs == nil {
+				metadata.Xattrs = map[string][]byte{}
+			}
+			metadata.Xattrs[strings.TrimPrefix(key, xattrPAXPrefix)] = []byte(value)
+		}
+	}
+	return metadata
+}
+
+func writeExtractionIndex(root string, index ExtractionIndex) error {
+	if index == nil {
+		index = ExtractionIndex{}
+	}
+	indexJSON, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(sidecarPath(root, indexSuffix), indexJSON, 0600)
+}
+
+// GetExtractionIndex returns the metadata recorded when extracting to root,
+// or nil if none was recorded
+func GetExtractionIndex(root string) (ExtractionIndex, error) {
+	contents, err := ioutil.ReadFile(sidecarPath(root, indexSuffix))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var index ExtractionIndex
+	if err := json.Unmarshal(contents, &index); err != nil {
+		return nil, errors.Wrapf(err, "reading extraction index for %s", root)
+	}
+	return index, nil
+}
+
+// sidecarPath returns the path of a file stored next to the extracted tree
+// at root
+func sidecarPath(root, suffix string) string {
+	return filepath.Clean(root) + suffix
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// annotations used by OCI image layouts (and the tools that write them)
+	// to record the name an image was stored under
+	ociRefNameAnnotation     = "org.opencontainers.image.ref.name"
+	containerdNameAnnotation = "io.containerd.image.name"
+)
+
+// defaultPlatform mirrors the platform go-containerregistry resolves
+// manifest lists to when none is requested.
+var defaultPlatform = v1.Platform{
+	OS:           "linux",
+	Architecture: "amd64",
+}
+
+// ParseOCIReference splits an OCI layout reference of the form
+// path[:tag|@digest] into the layout path and an optional tag or digest.
+// If the whole reference is an existing path, it is returned untouched.
+func ParseOCIReference(ref string) (path, tag, digest string) {
+	ref = strings.TrimPrefix(ref, ociPrefix)
+	if _, err := os.Stat(ref); err == nil {
+		return ref, "", ""
+	}
+	if i := strings.LastIndex(ref, "@"); i >= 0 && strings.Contains(ref[i+1:], ":") {
+		return ref[:i], "", ref[i+1:]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") && i > 1 {
+		return ref[:i], ref[i+1:], ""
+	}
+	return ref, "", ""
+}
+
+// getOCILayoutImage opens the OCI image layout referenced by imageName and
+// returns the image selected by its tag (matched against the ref-name
+// annotation) or digest. If the selection is an index, the image for
+// platform (or the default platform, if nil) is returned along with the index.
+func getOCILayoutImage(imageName string, platform *v1.Platform) (v1.Image, v1.ImageIndex, error) {
+	path, tag, digest := ParseOCIReference(imageName)
+	index, err := layout.ImageIndexFromPath(path)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "opening OCI image layout %s", path)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reading OCI layout index")
+	}
+	desc, err := selectOCIDescriptor(manifest.Manifests, tag, digest)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "selecting image from OCI layout %s", path)
+	}
+	logrus.Infof("selected manifest %s from OCI layout %s", desc.Digest, path)
+	var child v1.ImageIndex
+	if desc.MediaType.IsIndex() {
+		if child, err = index.ImageIndex(desc.Digest); err != nil {
+			return nil, nil, errors.Wrapf(err, "retrieving index %s", desc.Digest)
+		}
+	}
+	img, err := imageFromDescriptor(index, desc, platform)
+	return img, child, err
+}
+
+// getOCILayoutIndex opens the OCI image layout referenced by imageName and
+// returns the nested index selected by its tag or digest.
+func getOCILayoutIndex(imageName string) (v1.ImageIndex, error) {
+	path, tag, digest := ParseOCIReference(imageName)
+	index, err := layout.ImageIndexFromPath(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening OCI image layout %s", path)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading OCI layout index")
+	}
+	desc, err := selectOCIDescriptor(manifest.Manifests, tag, digest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "selecting index from OCI layout %s", path)
+	}
+	if !desc.MediaType.IsIndex() {
+		return nil, fmt.Errorf("manifest %s in OCI layout %s is not an index", desc.Digest, path)
+	}
+	return index.ImageIndex(desc.Digest)
+}
+
+// selectOCIDescriptor picks the descriptor matching the given tag or digest
+// out of an OCI layout's index. With neither, the layout must hold exactly
+// one entry.
+func selectOCIDescriptor(manifests []v1.Descriptor, tag, digest string) (v1.Descriptor, error) {
+	for _, desc := range manifests {
+		switch {
+		case digest != "":
+			if desc.Digest.String() == digest {
+				return desc, nil
+			}
+		case tag != "":
+			if desc.Annotations[ociRefNameAnnotation] == tag ||
+				strings.HasSuffix(desc.Annotations[containerdNameAnnotation], ":"+tag) {
+				return desc, nil
+			}
+		}
+	}
+	if digest != "" {
+		return v1.Descriptor{}, fmt.Errorf("no manifest with digest %s", digest)
+	}
+	if tag != "" {
+		return v1.Descriptor{}, fmt.Errorf("no manifest tagged %s", tag)
+	}
+	if len(manifests) != 1 {
+		return v1.Descriptor{}, fmt.Errorf("layout contains %d manifests, specify one with :tag or @digest", len(manifests))
+	}
+	return manifests[0], nil
+}
+
+// imageFromDescriptor resolves desc, which may itself point at a nested
+// index, to a single image from index.
+func imageFromDescriptor(index v1.ImageIndex, desc v1.Descriptor, platform *v1.Platform) (v1.Image, error) {
+	if desc.MediaType.IsImage() {
+		return index.Image(desc.Digest)
+	}
+	if !desc.MediaType.IsIndex() {
+		return nil, fmt.Errorf("unsupported media type %s for manifest %s", desc.MediaType, desc.Digest)
+	}
+	child, err := index.ImageIndex(desc.Digest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "retrieving index %s", desc.Digest)
+	}
+	manifest, err := child.IndexManifest()
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading index %s", desc.Digest)
+	}
+	want := defaultPlatform
+	if platform != nil {
+		want = *platform
+	}
+	for _, d := range manifest.Manifests {
+		if d.Platform != nil && d.Platform.Satisfies(want) {
+			return imageFromDescriptor(child, d, platform)
+		}
+	}
+	return nil, fmt.Errorf("no manifest for platform %s in index %s", want.String(), desc.Digest)
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Provenance sets whether the file analyzer records the layer that last
+// wrote each entry of an image
+var Provenance bool
+
+// EntryProvenance records which layers of an image wrote and deleted an
+// entry. Layers are identified by their index in the image, from 0 for the
+// base layer.
+type EntryProvenance struct {
+	// Layer is the index of the layer that last wrote the entry
+	Layer int
+	// Digest is the digest of that layer
+	Digest string
+	// CreatedBy is the history line of the command that created that layer
+	CreatedBy string `json:",omitempty"`
+	// Overwritten lists the layers that replaced a version of the entry
+	// written by a layer below them
+	Overwritten []int `json:",omitempty"`
+	// Deleted lists the layers that deleted the entry before it was written
+	// again
+	Deleted []int `json:",omitempty"`
+}
+
+// GetLayerProvenance walks the extracted layers of image in order, and
+// returns the provenance of each entry of the flattened file system
+func GetLayerProvenance(image Image) (map[string]*EntryProvenance, error) {
+	history := GetLayerHistory(image)
+	provenance := map[string]*EntryProvenance{}
+	// lower holds the entries present after the layers walked so far
+	lower := map[string]DirectoryEntry{}
+	for i, layer := range image.Layers {
+		for _, entry := range ApplyWhiteouts(lower, layer.Whiteouts) {
+			p := provenance[entry.Name]
+			p.Deleted = append(p.Deleted, i)
+		}
+
+		layerDir, err := GetDirectory(layer.FSPath, true)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range layerDir.Content {
+			p, ok := provenance[name]
+			if !ok {
+				p = &EntryProvenance{}
+				provenance[name] = p
+			}
+			if _, present := lower[name]; present {
+				p.Overwritten = append(p.Overwritten, i)
+			}
+			p.Layer = i
+			p.Digest = layer.Digest.String()
+			p.CreatedBy = history[i]
+			lower[name] = DirectoryEntry{Name: name}
+		}
+	}
+	// entries deleted by the last layer to touch them aren't in the image
+	for name := range provenance {
+		if _, ok := lower[name]; !ok {
+			delete(provenance, name)
+		}
+	}
+	return provenance, nil
+}
+
+// AddProvenance sets the provenance of each of entries found in provenance
+func AddProvenance(entries []DirectoryEntry, provenance map[string]*EntryProvenance) {
+	for i := range entries {
+		entries[i].Provenance = provenance[entries[i].Name]
+	}
+}
+
+// GetLayerHistory returns the created_by history line of each layer of
+// image, skipping the history of instructions that created no layer. The
+// lines are left empty if the history doesn't match the layers.
+func GetLayerHistory(image Image) []string {
+	history := make([]string, len(image.Layers))
+	if image.Image == nil {
+		return history
+	}
+	config, err := image.Image.ConfigFile()
+	if err != nil {
+		logrus.Warnf("Could not read the config of %s: %s", image.Source, err)
+		return history
+	}
+	var lines []string
+	for _, h := range config.History {
+		if !h.EmptyLayer {
+			lines = append(lines, strings.TrimSpace(h.CreatedBy))
+		}
+	}
+	if len(lines) != len(image.Layers) {
+		logrus.Warnf("History of %s has %d entries for %d layers, ignoring it", image.Source, len(lines), len(image.Layers))
+		return history
+	}
+	return lines
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// SizeOnDisk makes sizes count the blocks allocated to files, like du,
+// rather than their apparent size
+var SizeOnDisk bool
+
+// sizesSuffix names the sidecar holding the apparent sizes of the
+// directories of a partially unpacked tree in its full filesystem
+const sizesSuffix = ".sizes.json"
+
+// rootSizeKey is the key under which a SizeIndex holds the total of its root
+const rootSizeKey = "/"
+
+// SizeIndex maps the entries under a root, named as in Directory.Content, to
+// their size. The size of a directory is the total of its contents, in which
+// a file with several hard links is only counted once. The total of the root
+// itself is kept under "/".
+type SizeIndex map[string]int64
+
+// Total returns the size of the root of the index
+func (s SizeIndex) Total() int64 {
+	return s[rootSizeKey]
+}
+
+// GetSizeIndex computes the size of every entry under root in a single walk
+func GetSizeIndex(root string) (SizeIndex, error) {
+	w := newTreeWalker(root, nil)
+	if err := w.walkRoot(); err != nil {
+		return nil, err
+	}
+	return w.sizes, nil
+}
+
+// treeWalker walks a tree in the same order as filepath.Walk, but computes
+// the sizes of directories from their contents on the way back up, so each
+// entry is only visited once
+type treeWalker struct {
+	root  string
+	sizes SizeIndex
+	// seen holds the hard linked files counted so far
+	seen map[Inode]bool
+	// visit is called for every entry under root, parents before children
+	visit func(name, path string, info os.FileInfo)
+}
+
+func newTreeWalker(root string, visit func(name, path string, info os.FileInfo)) *treeWalker {
+	return &treeWalker{
+		root:  root,
+		sizes: SizeIndex{},
+		seen:  map[Inode]bool{},
+		visit: visit,
+	}
+}
+
+func (w *treeWalker) walkRoot() error {
+	info, err := os.Lstat(w.root)
+	if err != nil {
+		return err
+	}
+	w.sizes[rootSizeKey] = w.walk(w.root, info)
+	return nil
+}
+
+// walk records the size of the entry at path, and returns what it adds to
+// the size of its parent
+func (w *treeWalker) walk(path string, info os.FileInfo) int64 {
+	name := strings.TrimPrefix(path, w.root)
+	if name != "" && w.visit != nil {
+		w.visit(name, path, info)
+	}
+	if !info.IsDir() {
+		size := getFileSize(info)
+		if name != "" {
+			w.sizes[name] = size
+		}
+		if id, ok := getInode(info); ok {
+			if w.seen[id] {
+				return 0
+			}
+			w.seen[id] = true
+		}
+		return size
+	}
+
+	var size int64
+	children, err := ioutil.ReadDir(path)
+	if err != nil {
+		logrus.Errorf("Could not read directory %s: %s", path, err)
+	}
+	for _, child := range children {
+		size += w.walk(filepath.Join(path, child.Name()), child)
+	}
+	if name != "" {
+		w.sizes[name] = size
+	}
+	return size
+}
+
+func getFileSize(info os.FileInfo) int64 {
+	if SizeOnDisk {
+		return getDiskUsage(info)
+	}
+	return info.Size()
+}
+
+// GetInode returns the inode of the file at path if it has other hard links,
+// for its size to be counted once
+func GetInode(path string) (Inode, bool) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return Inode{}, false
+	}
+	return getInode(info)
+}
+
+func writeDirectorySizes(root string, sizes SizeIndex) error {
+	sizesJSON, err := json.Marshal(sizes)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(sidecarPath(root, sizesSuffix), sizesJSON, 0600)
+}
+
+// applyDirectorySizes replaces the sizes of the directories of the tree at
+// root with their sizes in the full filesystem, if root was only partially
+// unpacked. These are apparent sizes, so are left out with SizeOnDisk.
+func applyDirectorySizes(root string, sizes SizeIndex) error {
+	if SizeOnDisk {
+		return nil
+	}
+	contents, err := ioutil.ReadFile(sidecarPath(root, sizesSuffix))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var full SizeIndex
+	if err := json.Unmarshal(contents, &full); err != nil {
+		return errors.Wrapf(err, "reading directory sizes for %s", root)
+	}
+	for name := range sizes {
+		if size, ok := full[name]; ok {
+			sizes[name] = size
+		}
+	}
+	return nil
+}
@@ -20,26 +20,48 @@ import (
 	"archive/tar"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
-	"sync"
-	"sync/atomic"
-
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
 type OriginalPerm struct {
+	// name is the entry name of the directory, and path where it was created
+	name string
 	path string
 	perm os.FileMode
 }
 
-func unpackTar(tr *tar.Reader, path string, whitelist []string) error {
-	// Thread safe Map of target:linkname
-	var hardlinks sync.Map
+// pendingHardlink is a hard link whose target wasn't extracted yet
+type pendingHardlink struct {
+	name     string
+	linkname string
+}
+
+// maxSymlinkHops bounds how many symlinks are followed when resolving a path
+// inside an extraction root, to stop symlink loops
+const maxSymlinkHops = 255
+
+// Whiteout records a path deleted by a layer from the layers below it.
+// If Opaque is set, only the contents of the directory at Path are deleted.
+type Whiteout struct {
+	Path   string
+	Opaque bool
+}
 
+// unpackTar extracts the entries of tr that filter matches into path. The
+// metadata of each entry is returned in an index, and whiteout markers are
+// returned rather than written to disk.
+func unpackTar(tr *tar.Reader, path string, whitelist []string, filter *PathFilter) (ExtractionIndex, []Whiteout, error) {
+	var hardlinks []pendingHardlink
+
+	index := ExtractionIndex{}
+	var whiteouts []Whiteout
 	originalPerms := make([]OriginalPerm, 0)
 	for {
 		header, err := tr.Next()
@@ -48,36 +70,69 @@ func unpackTar(tr *tar.Reader, path string, whitelist []string) error {
 			break
 		}
 		if err != nil {
-			return errors.Wrap(err, "Error getting next tar header")
+			return nil, nil, errors.Wrap(err, "Error getting next tar header")
+		}
+		if escapesRoot(header.Name) {
+			return nil, nil, fmt.Errorf("refusing to extract %s: path escapes the extraction root", header.Name)
+		}
+		if whiteout, ok := getWhiteout(header.Name); ok {
+			logrus.Debugf("Recording whiteout %s", header.Name)
+			whiteouts = append(whiteouts, whiteout)
+			continue
+		}
+		if !filter.Matches(header.Name, header.Typeflag == tar.TypeDir) {
+			logrus.Debugf("Not extracting %s, as it is filtered out", header.Name)
+			continue
+		}
+		// symlinks extracted earlier are followed as if path were the
+		// filesystem root, so that no entry is written outside of it
+		target, err := resolveInRoot(path, header.Name)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "resolving %s", header.Name)
 		}
-		target := filepath.Clean(filepath.Join(path, header.Name))
 		// Make sure the target isn't part of the whitelist
 		if checkWhitelist(target, whitelist) {
 			continue
 		}
+		if target == filepath.Clean(path) {
+			continue
+		}
+		rel, err := filepath.Rel(path, target)
+		if err != nil {
+			return nil, nil, err
+		}
+		index["/"+filepath.ToSlash(rel)] = getEntryMetadata(header)
 		mode := header.FileInfo().Mode()
 		switch header.Typeflag {
 
 		// if its a dir and it doesn't exist create it
 		case tar.TypeDir:
-			if _, err := os.Stat(target); os.IsNotExist(err) {
+			// a directory replaces anything else at its path
+			if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+				logrus.Debugf("Removing %s to create directory", target)
+				if err := os.Remove(target); err != nil {
+					return nil, nil, err
+				}
+			}
+			if _, err := os.Lstat(target); os.IsNotExist(err) {
 				if mode.Perm()&(1<<(uint(7))) == 0 {
 					logrus.Debugf("Write permission bit not set on %s by default; setting manually", target)
 					originalMode := mode
 					mode = mode | (1 << uint(7))
 					// keep track of original file permission to reset later
 					originalPerms = append(originalPerms, OriginalPerm{
+						name: header.Name,
 						path: target,
 						perm: originalMode,
 					})
 				}
 				logrus.Debugf("Creating directory %s with permissions %v", target, mode)
 				if err := os.MkdirAll(target, mode); err != nil {
-					return err
+					return nil, nil, err
 				}
 				// In some cases, MkdirAll doesn't change the permissions, so run Chmod
 				if err := os.Chmod(target, mode); err != nil {
-					return err
+					return nil, nil, err
 				}
 			}
 
@@ -88,16 +143,17 @@ func unpackTar(tr *tar.Reader, path string, whitelist []string) error {
 			if _, err := os.Stat(baseDir); os.IsNotExist(err) {
 				logrus.Debugf("baseDir %s for file %s does not exist. Creating", baseDir, target)
 				if err := os.MkdirAll(baseDir, 0755); err != nil {
-					return err
+					return nil, nil, err
 				}
 			}
 			// It's possible we end up creating files that can't be overwritten based on their permissions.
 			// Explicitly delete an existing file before continuing.
-			if _, err := os.Stat(target); !os.IsNotExist(err) {
+			// Lstat, so that a dangling symlink is replaced rather than followed.
+			if _, err := os.Lstat(target); !os.IsNotExist(err) {
 				logrus.Debugf("Removing %s for overwrite", target)
 				if err := os.Remove(target); err != nil {
 					logrus.Errorf("error removing file %s", target)
-					return err
+					return nil, nil, err
 				}
 			}
 
@@ -105,22 +161,26 @@ func unpackTar(tr *tar.Reader, path string, whitelist []string) error {
 			currFile, err := os.Create(target)
 			if err != nil {
 				logrus.Errorf("Error creating file %s %s", target, err)
-				return err
+				return nil, nil, err
 			}
 			// manually set permissions on file, since the default umask (022) will interfere
 			if err = os.Chmod(target, mode); err != nil {
 				logrus.Errorf("Error updating file permissions on %s", target)
-				return err
+				return nil, nil, err
 			}
 			_, err = io.Copy(currFile, tr)
 			if err != nil {
-				return err
+				return nil, nil, err
 			}
 			currFile.Close()
 		case tar.TypeSymlink:
+			// the parent directory may have been filtered out
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return nil, nil, err
+			}
 			// It's possible we end up creating files that can't be overwritten based on their permissions.
 			// Explicitly delete an existing file before continuing.
-			if _, err := os.Stat(target); !os.IsNotExist(err) {
+			if _, err := os.Lstat(target); !os.IsNotExist(err) {
 				logrus.Debugf("Removing %s to create symlink", target)
 				if err := os.RemoveAll(target); err != nil {
 					logrus.Debugf("Unable to remove %s: %s", target, err)
@@ -130,42 +190,157 @@ func unpackTar(tr *tar.Reader, path string, whitelist []string) error {
 			if err = os.Symlink(header.Linkname, target); err != nil {
 				logrus.Errorf("Failed to create symlink between %s and %s: %s", header.Linkname, target, err)
 			}
+		// device nodes and FIFOs can't be created without privileges, so an
+		// empty placeholder is left in their place; their type is recorded
+		// in the index
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return nil, nil, err
+			}
+			if _, err := os.Lstat(target); !os.IsNotExist(err) {
+				if err := os.RemoveAll(target); err != nil {
+					return nil, nil, err
+				}
+			}
+			logrus.Debugf("Creating placeholder for special file %s", target)
+			if err := ioutil.WriteFile(target, nil, 0644); err != nil {
+				return nil, nil, err
+			}
 		case tar.TypeLink:
-			linkname := filepath.Clean(filepath.Join(path, header.Linkname))
+			if escapesRoot(header.Linkname) {
+				return nil, nil, fmt.Errorf("refusing to extract hard link %s: link target %s escapes the extraction root", header.Name, header.Linkname)
+			}
+			linkname, err := resolveInRoot(path, header.Linkname)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "resolving hard link target %s", header.Linkname)
+			}
 			// Check if the linkname already exists
-			if _, err := os.Stat(linkname); !os.IsNotExist(err) {
+			if _, err := os.Lstat(linkname); !os.IsNotExist(err) {
 				// If it exists, create the hard link
+				if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+					return nil, nil, err
+				}
 				resolveHardlink(linkname, target)
 			} else {
-				hardlinks.Store(target, linkname)
+				hardlinks = append(hardlinks, pendingHardlink{name: header.Name, linkname: header.Linkname})
 			}
 		}
 	}
-	var resolveError atomic.Value
-	hardlinks.Range(func(key, value interface{}) bool {
-		target := key.(string)
-		linkname := value.(string)
-		logrus.Info("Resolving hard links")
-		if _, err := os.Stat(linkname); !os.IsNotExist(err) {
-			// If it exists, create the hard link
-			if err := resolveHardlink(linkname, target); err != nil {
-				resolveError.Store(errors.Wrap(err, fmt.Sprintf("Unable to create hard link from %s to %s", linkname, target)))
-				return false
-			}
-		}
-		return true
-	})
-	if resolveError.Load() != nil {
-		return resolveError.Load().(error)
+	if len(hardlinks) > 0 {
+		logrus.Debugf("Resolving %d hard links", len(hardlinks))
+	}
+	for _, link := range hardlinks {
+		// later entries may have replaced the parents of either path with
+		// symlinks, so both are resolved again
+		target, err := resolveInRoot(path, link.name)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "resolving %s", link.name)
+		}
+		linkname, err := resolveInRoot(path, link.linkname)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "resolving hard link target %s", link.linkname)
+		}
+		if _, err := os.Lstat(linkname); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, nil, err
+		}
+		if err := resolveHardlink(linkname, target); err != nil {
+			return nil, nil, errors.Wrap(err, fmt.Sprintf("Unable to create hard link from %s to %s", linkname, target))
+		}
 	}
 
 	// reset all original file
 	for _, perm := range originalPerms {
-		if err := os.Chmod(perm.path, perm.perm); err != nil {
-			return err
+		// only the directory created for the entry is changed: a later entry
+		// may have replaced it, or one of its parents, with a symlink
+		target, err := resolveInRoot(path, perm.name)
+		if err != nil || target != perm.path {
+			logrus.Debugf("Not resetting permissions of %s, as it was replaced", perm.path)
+			continue
+		}
+		if info, err := os.Lstat(target); err != nil || !info.IsDir() {
+			logrus.Debugf("Not resetting permissions of %s, as it was replaced", perm.path)
+			continue
+		}
+		if err := os.Chmod(target, perm.perm); err != nil {
+			return nil, nil, err
 		}
 	}
-	return nil
+	return index, whiteouts, nil
+}
+
+// escapesRoot reports whether the tar entry name climbs out of the directory
+// it is extracted to through ".." elements
+func escapesRoot(name string) bool {
+	// absolute names are taken relative to the extraction root
+	name = strings.TrimLeft(filepath.ToSlash(name), "/")
+	cleaned := path.Clean(name)
+	return cleaned == ".." || strings.HasPrefix(cleaned, "../")
+}
+
+// resolveInRoot returns the location of name inside root, following any
+// symlinks in the parent directories of name as if root were the filesystem
+// root: absolute symlinks are resolved against root, and ".." never leaves
+// it. The last element of name is not followed.
+func resolveInRoot(root, name string) (string, error) {
+	root = filepath.Clean(root)
+	// the remaining elements to resolve, and the resolved path relative to root
+	remaining := strings.Split(path.Clean("/"+filepath.ToSlash(name)), "/")
+	var resolved []string
+	hops := 0
+	for len(remaining) > 0 {
+		elem := remaining[0]
+		remaining = remaining[1:]
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) > 0 {
+				resolved = resolved[:len(resolved)-1]
+			}
+			continue
+		}
+		current := filepath.Join(root, filepath.Join(resolved...), elem)
+		if len(remaining) == 0 {
+			resolved = append(resolved, elem)
+			break
+		}
+		info, err := os.Lstat(current)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			// directories that don't exist yet will be created inside root
+			resolved = append(resolved, elem)
+			continue
+		}
+		hops++
+		if hops > maxSymlinkHops {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", name)
+		}
+		link, err := os.Readlink(current)
+		if err != nil {
+			return "", err
+		}
+		link = filepath.ToSlash(link)
+		if path.IsAbs(link) {
+			resolved = nil
+		}
+		remaining = append(strings.Split(link, "/"), remaining...)
+	}
+	return filepath.Join(root, filepath.Join(resolved...)), nil
+}
+
+// getWhiteout interprets name as a whiteout marker
+func getWhiteout(name string) (Whiteout, bool) {
+	name = path.Clean("/" + name)
+	dir, base := path.Split(name)
+	if base == opaqueWhiteout {
+		return Whiteout{Path: path.Clean(dir), Opaque: true}, true
+	}
+	if strings.HasPrefix(base, whiteoutPrefix) {
+		return Whiteout{Path: path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))}, true
+	}
+	return Whiteout{}, false
 }
 
 func resolveHardlink(linkname, target string) error {
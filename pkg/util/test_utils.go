@@ -17,8 +17,14 @@ limitations under the License.
 package util
 
 import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+
 	"github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/google/go-containerregistry/pkg/v1/types"
 )
 
@@ -73,3 +79,70 @@ func (i *TestImage) Layers() ([]v1.Layer, error) {
 func (i *TestImage) Size() (int64, error) {
 	return 0, nil
 }
+
+// TestTarEntry describes an entry of a layer built with TestLayer.
+// Regular files are created if Typeflag is unset.
+type TestTarEntry struct {
+	Name     string
+	Typeflag byte
+	Contents string
+	Linkname string
+	Mode     int64
+	Uid      int
+	Gid      int
+	Devmajor int64
+	Devminor int64
+	Xattrs   map[string]string
+}
+
+// TestLayer builds an uncompressed layer holding the given entries
+func TestLayer(entries ...TestTarEntry) (v1.Layer, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.Name,
+			Typeflag: entry.Typeflag,
+			Linkname: entry.Linkname,
+			Mode:     entry.Mode,
+			Uid:      entry.Uid,
+			Gid:      entry.Gid,
+			Devmajor: entry.Devmajor,
+			Devminor: entry.Devminor,
+		}
+		for key, value := range entry.Xattrs {
+			if header.PAXRecords == nil {
+				header.PAXRecords = map[string]string{}
+				header.Format = tar.FormatPAX
+			}
+			header.PAXRecords[xattrPAXPrefix+key] = value
+		}
+		if header.Typeflag == 0 {
+			header.Typeflag = tar.TypeReg
+		}
+		if header.Mode == 0 {
+			header.Mode = 0644
+			if header.Typeflag == tar.TypeDir {
+				header.Mode = 0755
+			}
+		}
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(entry.Contents))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := io.WriteString(tw, entry.Contents); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	contents := buf.Bytes()
+	return tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(contents)), nil
+	})
+}
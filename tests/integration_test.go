@@ -22,6 +22,7 @@ package tests
 import (
 	"bytes"
 	"context"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -64,6 +65,10 @@ const (
 	multiModifiedLocal = "daemon://gcr.io/gcp-runtimes/container-diff-tests/multi-modified"
 )
 
+// update writes the actual output of each diff and analysis to its expected
+// file, to regenerate them after a change to the output
+var update = flag.Bool("update", false, "write the actual output of each test to its expected file")
+
 type ContainerDiffRunner struct {
 	t          *testing.T
 	binaryPath string
@@ -241,11 +246,17 @@ func TestDiffAndAnalysis(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Error running command: %s. Stderr: %s", err, stderr)
 			}
+			actual = strings.TrimSpace(actual)
+			if *update {
+				if err := ioutil.WriteFile(test.expectedFile, []byte(actual), 0644); err != nil {
+					t.Fatalf("Error writing expected file output file: %s", err)
+				}
+				return
+			}
 			e, err := ioutil.ReadFile(test.expectedFile)
 			if err != nil {
 				t.Fatalf("Error reading expected file output file: %s", err)
 			}
-			actual = strings.TrimSpace(actual)
 			expected := strings.TrimSpace(string(e))
 			if actual != expected {
 				t.Errorf("Error actual output does not match expected.  \n\nExpected: %s\n\n Actual: %s\n\n, Stderr: %s", expected, actual, stderr)
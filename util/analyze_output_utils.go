@@ -53,6 +53,22 @@ func (r ListAnalyzeResult) OutputText(writer io.Writer, resultType string, forma
 
 }
 
+type IndexAnalyzeResult AnalyzeResult
+
+func (r IndexAnalyzeResult) OutputStruct() interface{} {
+	return r
+}
+
+func (r IndexAnalyzeResult) OutputText(writer io.Writer, resultType string, format string) error {
+	analysis, valid := r.Analysis.([]IndexEntry)
+	if !valid {
+		logrus.Error("Unexpected structure of Analysis.  Should be of type []IndexEntry")
+		return fmt.Errorf("Could not output %s analysis result", r.AnalyzeType)
+	}
+	r.Analysis = analysis
+	return TemplateOutputFromFormat(writer, r, "IndexAnalyze", format)
+}
+
 type MultiVersionPackageAnalyzeResult AnalyzeResult
 
 func (r MultiVersionPackageAnalyzeResult) OutputStruct() interface{} {
@@ -276,6 +292,18 @@ func (r FileAnalyzeResult) OutputText(writer io.Writer, analyzeType string, form
 	} else {
 		directoryBy(directoryNameSort).Sort(analysis)
 	}
+	if util.Provenance {
+		strResult := struct {
+			Image       string
+			AnalyzeType string
+			Analysis    []StrProvenanceEntry
+		}{
+			Image:       r.Image,
+			AnalyzeType: r.AnalyzeType,
+			Analysis:    stringifyProvenanceEntries(analysis),
+		}
+		return TemplateOutputFromFormat(writer, strResult, "FileProvenanceAnalyze", format)
+	}
 	strAnalysis := stringifyDirectoryEntries(analysis)
 
 	strResult := struct {
@@ -293,18 +321,14 @@ func (r FileAnalyzeResult) OutputText(writer io.Writer, analyzeType string, form
 type FileLayerAnalyzeResult AnalyzeResult
 
 func (r FileLayerAnalyzeResult) OutputStruct() interface{} {
-	analysis, valid := r.Analysis.([][]util.DirectoryEntry)
+	analysis, valid := r.Analysis.([]DirDiff)
 	if !valid {
-		logrus.Error("Unexpected structure of Analysis.  Should be of type []DirectoryEntry")
-		return errors.New("Could not output FileAnalyzer analysis result")
+		logrus.Error("Unexpected structure of Analysis.  Should be of type []DirDiff")
+		return errors.New("Could not output FileLayerAnalyzer analysis result")
 	}
 
-	for _, a := range analysis {
-		if SortSize {
-			directoryBy(directorySizeSort).Sort(a)
-		} else {
-			directoryBy(directoryNameSort).Sort(a)
-		}
+	for i, a := range analysis {
+		analysis[i] = sortDirDiff(a)
 	}
 
 	r.Analysis = analysis
@@ -312,32 +336,34 @@ func (r FileLayerAnalyzeResult) OutputStruct() interface{} {
 }
 
 func (r FileLayerAnalyzeResult) OutputText(writer io.Writer, analyzeType string, format string) error {
-	analysis, valid := r.Analysis.([][]util.DirectoryEntry)
+	analysis, valid := r.Analysis.([]DirDiff)
 	if !valid {
-		logrus.Error("Unexpected structure of Analysis.  Should be of type []DirectoryEntry")
-		return errors.New("Could not output FileAnalyzer analysis result")
+		logrus.Error("Unexpected structure of Analysis.  Should be of type []DirDiff")
+		return errors.New("Could not output FileLayerAnalyzer analysis result")
 	}
 
-	var strDirectoryEntries [][]StrDirectoryEntry
+	type StrLayer struct {
+		Adds []StrDirectoryEntry
+		Dels []StrDirectoryEntry
+	}
 
+	var strLayers []StrLayer
 	for _, a := range analysis {
-		if SortSize {
-			directoryBy(directorySizeSort).Sort(a)
-		} else {
-			directoryBy(directoryNameSort).Sort(a)
-		}
-		strAnalysis := stringifyDirectoryEntries(a)
-		strDirectoryEntries = append(strDirectoryEntries, strAnalysis)
+		a = sortDirDiff(a)
+		strLayers = append(strLayers, StrLayer{
+			Adds: stringifyDirectoryEntries(a.Adds),
+			Dels: stringifyDirectoryEntries(a.Dels),
+		})
 	}
 
 	strResult := struct {
 		Image       string
 		AnalyzeType string
-		Analysis    [][]StrDirectoryEntry
+		Analysis    []StrLayer
 	}{
 		Image:       r.Image,
 		AnalyzeType: r.AnalyzeType,
-		Analysis:    strDirectoryEntries,
+		Analysis:    strLayers,
 	}
 	return TemplateOutputFromFormat(writer, strResult, "FileLayerAnalyze", format)
 }
@@ -407,3 +433,74 @@ func (r SizeLayerAnalyzeResult) OutputText(writer io.Writer, analyzeType string,
 	}
 	return TemplateOutputFromFormat(writer, strResult, "SizeLayerAnalyze", format)
 }
+
+type EfficiencyAnalyzeResult AnalyzeResult
+
+func (r EfficiencyAnalyzeResult) OutputStruct() interface{} {
+	return r
+}
+
+func (r EfficiencyAnalyzeResult) OutputText(writer io.Writer, analyzeType string, format string) error {
+	analysis, valid := r.Analysis.(Efficiency)
+	if !valid {
+		logrus.Error("Unexpected structure of Analysis.  Should follow the Efficiency struct")
+		return errors.New("Could not output EfficiencyAnalyzer analysis result")
+	}
+
+	strResult := struct {
+		Image       string
+		AnalyzeType string
+		Analysis    StrEfficiency
+	}{
+		Image:       r.Image,
+		AnalyzeType: r.AnalyzeType,
+		Analysis:    stringifyEfficiency(analysis),
+	}
+	return TemplateOutputFromFormat(writer, strResult, "EfficiencyAnalyze", format)
+}
+
+type DuplicatesAnalyzeResult AnalyzeResult
+
+func (r DuplicatesAnalyzeResult) OutputStruct() interface{} {
+	analysis, valid := r.Analysis.([]util.DuplicateSet)
+	if !valid {
+		logrus.Error("Unexpected structure of Analysis.  Should be of type []DuplicateSet")
+		return errors.New("Could not output DuplicatesAnalyzer analysis result")
+	}
+	sortDuplicateSets(analysis)
+	r.Analysis = analysis
+	return r
+}
+
+func (r DuplicatesAnalyzeResult) OutputText(writer io.Writer, analyzeType string, format string) error {
+	analysis, valid := r.Analysis.([]util.DuplicateSet)
+	if !valid {
+		logrus.Error("Unexpected structure of Analysis.  Should be of type []DuplicateSet")
+		return errors.New("Could not output DuplicatesAnalyzer analysis result")
+	}
+	sortDuplicateSets(analysis)
+
+	var reclaimable int64
+	for _, set := range analysis {
+		reclaimable += set.Reclaimable
+	}
+
+	type StrAnalysis struct {
+		Sets        []StrDuplicateSet
+		Reclaimable string
+	}
+
+	strResult := struct {
+		Image       string
+		AnalyzeType string
+		Analysis    StrAnalysis
+	}{
+		Image:       r.Image,
+		AnalyzeType: r.AnalyzeType,
+		Analysis: StrAnalysis{
+			Sets:        stringifyDuplicateSets(analysis),
+			Reclaimable: stringifySize(reclaimable),
+		},
+	}
+	return TemplateOutputFromFormat(writer, strResult, "DuplicatesAnalyze", format)
+}
@@ -0,0 +1,286 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+	"github.com/sirupsen/logrus"
+)
+
+// ArchiveDepth is how many levels of nested archives file diffs look into
+// when an archive is modified: 1 diffs the entries of archives in the image,
+// 2 also those of archives inside them, and so on. 0 compares archives as
+// opaque files.
+var ArchiveDepth int
+
+// ArchiveSeparator separates the path of an archive from the path of an
+// entry inside it, e.g. /app/outer.jar!/com/foo/Bar.class
+const ArchiveSeparator = "!"
+
+var zipExtensions = []string{".zip", ".jar", ".war", ".ear", ".whl"}
+var tarExtensions = []string{".tar", ".tar.gz", ".tgz"}
+
+// archiveEntry is a regular file inside an archive
+type archiveEntry struct {
+	size int64
+	hash string
+	// contents are only kept for nested archives that are looked into
+	contents []byte
+}
+
+// IsArchive reports whether name has the extension of an archive that file
+// diffs can look into
+func IsArchive(name string) bool {
+	return isZip(name) || isTarArchive(name)
+}
+
+func isZip(name string) bool {
+	return hasExtension(name, zipExtensions)
+}
+
+func isTarArchive(name string) bool {
+	return hasExtension(name, tarExtensions)
+}
+
+func hasExtension(name string, extensions []string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range extensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffModifiedArchives looks into the archives among the modified entries of
+// d1 and d2, down to ArchiveDepth levels, and returns the entries added,
+// deleted and modified inside them
+func diffModifiedArchives(d1, d2 pkgutil.Directory, mods []string) ([]pkgutil.DirectoryEntry, []pkgutil.DirectoryEntry, []EntryDiff) {
+	var adds, dels []pkgutil.DirectoryEntry
+	var modified []EntryDiff
+	for _, name := range mods {
+		if !IsArchive(name) {
+			continue
+		}
+		entries1, err := readArchiveFile(fmt.Sprintf("%s%s", d1.Root, name), ArchiveDepth)
+		if err != nil {
+			logrus.Warnf("Could not read archive %s: %s", name, err)
+			continue
+		}
+		entries2, err := readArchiveFile(fmt.Sprintf("%s%s", d2.Root, name), ArchiveDepth)
+		if err != nil {
+			logrus.Warnf("Could not read archive %s: %s", name, err)
+			continue
+		}
+		a, d, m := diffArchiveEntries(name, entries1, entries2, ArchiveDepth)
+		adds = append(adds, a...)
+		dels = append(dels, d...)
+		modified = append(modified, m...)
+	}
+	return adds, dels, modified
+}
+
+// diffArchiveEntries diffs the entries of two versions of the archive name,
+// looking into the modified archives inside it while depth allows
+func diffArchiveEntries(name string, entries1, entries2 map[string]archiveEntry, depth int) ([]pkgutil.DirectoryEntry, []pkgutil.DirectoryEntry, []EntryDiff) {
+	var adds, dels []pkgutil.DirectoryEntry
+	var mods []EntryDiff
+	var nested []string
+	for _, inner := range sortedArchiveNames(entries1) {
+		entry1 := entries1[inner]
+		fullName := name + ArchiveSeparator + inner
+		entry2, ok := entries2[inner]
+		if !ok {
+			dels = append(dels, pkgutil.DirectoryEntry{Name: fullName, Size: entry1.size})
+			continue
+		}
+		if entry1.hash != entry2.hash {
+			mods = append(mods, EntryDiff{
+				Name:    fullName,
+				Size1:   entry1.size,
+				Size2:   entry2.size,
+				Changes: []EntryChange{{Kind: ChangeContent}},
+			})
+			if depth > 1 && IsArchive(inner) {
+				nested = append(nested, inner)
+			}
+		}
+	}
+	for _, inner := range sortedArchiveNames(entries2) {
+		if _, ok := entries1[inner]; !ok {
+			adds = append(adds, pkgutil.DirectoryEntry{Name: name + ArchiveSeparator + inner, Size: entries2[inner].size})
+		}
+	}
+
+	for _, inner := range nested {
+		fullName := name + ArchiveSeparator + inner
+		nested1, err := readArchiveContents(inner, entries1[inner].contents, depth-1)
+		if err != nil {
+			logrus.Warnf("Could not read archive %s: %s", fullName, err)
+			continue
+		}
+		nested2, err := readArchiveContents(inner, entries2[inner].contents, depth-1)
+		if err != nil {
+			logrus.Warnf("Could not read archive %s: %s", fullName, err)
+			continue
+		}
+		a, d, m := diffArchiveEntries(fullName, nested1, nested2, depth-1)
+		adds = append(adds, a...)
+		dels = append(dels, d...)
+		mods = append(mods, m...)
+	}
+	return adds, dels, mods
+}
+
+func sortedArchiveNames(entries map[string]archiveEntry) []string {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readArchiveFile reads the regular files in the archive at path. The
+// contents of nested archives are kept if depth allows looking into them.
+func readArchiveFile(archivePath string, depth int) (map[string]archiveEntry, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if isZip(archivePath) {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return readZip(f, info.Size(), depth)
+	}
+	return readTar(f, depth)
+}
+
+// readArchiveContents reads the regular files in an archive named name held
+// in memory
+func readArchiveContents(name string, contents []byte, depth int) (map[string]archiveEntry, error) {
+	if isZip(name) {
+		return readZip(bytes.NewReader(contents), int64(len(contents)), depth)
+	}
+	return readTar(bytes.NewReader(contents), depth)
+}
+
+func readZip(r io.ReaderAt, size int64, depth int) (map[string]archiveEntry, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	entries := map[string]archiveEntry{}
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		name := cleanArchiveName(f.Name)
+		entry, err := readArchiveEntry(name, rc, depth)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		entries[name] = entry
+	}
+	return entries, nil
+}
+
+// readTar reads a tar archive, which may be gzipped
+func readTar(r io.Reader, depth int) (map[string]archiveEntry, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+	tr := tar.NewReader(r)
+	entries := map[string]archiveEntry{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := cleanArchiveName(header.Name)
+		entry, err := readArchiveEntry(name, tr, depth)
+		if err != nil {
+			return nil, err
+		}
+		entries[name] = entry
+	}
+	return entries, nil
+}
+
+// readArchiveEntry hashes the contents of the entry name read from r,
+// keeping them if name is an archive that will be looked into
+func readArchiveEntry(name string, r io.Reader, depth int) (archiveEntry, error) {
+	h := sha256.New()
+	var entry archiveEntry
+	if depth > 1 && IsArchive(name) {
+		contents, err := ioutil.ReadAll(io.TeeReader(r, h))
+		if err != nil {
+			return entry, err
+		}
+		entry.contents = contents
+		entry.size = int64(len(contents))
+	} else {
+		size, err := io.Copy(h, r)
+		if err != nil {
+			return entry, err
+		}
+		entry.size = size
+	}
+	entry.hash = hex.EncodeToString(h.Sum(nil))
+	return entry, nil
+}
+
+func cleanArchiveName(name string) string {
+	return path.Clean("/" + name)
+}
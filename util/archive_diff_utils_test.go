@@ -0,0 +1,147 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+)
+
+func testZip(t *testing.T, files ...string) string {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i < len(files); i += 2 {
+		w, err := zw.Create(files[i])
+		if err != nil {
+			t.Fatalf("Error building zip: %s", err)
+		}
+		w.Write([]byte(files[i+1]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Error building zip: %s", err)
+	}
+	return buf.String()
+}
+
+func testTarGz(t *testing.T, files ...string) string {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for i := 0; i < len(files); i += 2 {
+		hdr := &tar.Header{Name: files[i], Mode: 0644, Size: int64(len(files[i+1])), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("Error building tar: %s", err)
+		}
+		tw.Write([]byte(files[i+1]))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Error building tar: %s", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Error building tar: %s", err)
+	}
+	return buf.String()
+}
+
+func TestDiffDirectoryArchives(t *testing.T) {
+	parent, err := ioutil.TempDir("", "archive-diff-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(parent)
+
+	inner1 := testZip(t, "a/A.class", "a1", "a/B.class", "b")
+	inner2 := testZip(t, "a/A.class", "a2", "a/B.class", "b", "a/C.class", "c")
+	dir1 := extractTestLayer(t, parent, "dir1",
+		pkgutil.TestTarEntry{Name: "app/outer.jar", Contents: testZip(t,
+			"META-INF/MANIFEST.MF", "v1",
+			"com/foo/Old.class", "old",
+			"lib/inner.jar", inner1,
+		)},
+		pkgutil.TestTarEntry{Name: "app/data.tgz", Contents: testTarGz(t, "./data/x", "1")},
+	)
+	dir2 := extractTestLayer(t, parent, "dir2",
+		pkgutil.TestTarEntry{Name: "app/outer.jar", Contents: testZip(t,
+			"META-INF/MANIFEST.MF", "v2",
+			"com/foo/Bar.class", "bar",
+			"lib/inner.jar", inner2,
+		)},
+		pkgutil.TestTarEntry{Name: "app/data.tgz", Contents: testTarGz(t, "./data/x", "2")},
+	)
+
+	names := func(entries []pkgutil.DirectoryEntry) (names []string) {
+		for _, entry := range entries {
+			names = append(names, entry.Name)
+		}
+		return names
+	}
+	modNames := func(entries []EntryDiff) (names []string) {
+		for _, entry := range entries {
+			names = append(names, entry.Name)
+		}
+		return names
+	}
+	tests := []struct {
+		depth int
+		adds  []string
+		dels  []string
+		mods  []string
+	}{
+		{
+			// tars of the same size aren't told apart unless looked into
+			depth: 0,
+			mods:  []string{"/app/outer.jar"},
+		},
+		{
+			depth: 1,
+			adds:  []string{"/app/outer.jar!/com/foo/Bar.class"},
+			dels:  []string{"/app/outer.jar!/com/foo/Old.class"},
+			mods: []string{"/app/data.tgz", "/app/data.tgz!/data/x", "/app/outer.jar",
+				"/app/outer.jar!/META-INF/MANIFEST.MF", "/app/outer.jar!/lib/inner.jar"},
+		},
+		{
+			depth: 2,
+			adds:  []string{"/app/outer.jar!/com/foo/Bar.class", "/app/outer.jar!/lib/inner.jar!/a/C.class"},
+			dels:  []string{"/app/outer.jar!/com/foo/Old.class"},
+			mods: []string{"/app/data.tgz", "/app/data.tgz!/data/x", "/app/outer.jar",
+				"/app/outer.jar!/META-INF/MANIFEST.MF", "/app/outer.jar!/lib/inner.jar",
+				"/app/outer.jar!/lib/inner.jar!/a/A.class"},
+		},
+	}
+	defer func() { ArchiveDepth = 0 }()
+	for _, test := range tests {
+		ArchiveDepth = test.depth
+		diff, _ := DiffDirectory(dir1, dir2)
+		if !reflect.DeepEqual(names(diff.Adds), test.adds) {
+			t.Errorf("Depth %d:\nExpected adds: %v\nGot: %v\n", test.depth, test.adds, names(diff.Adds))
+		}
+		if !reflect.DeepEqual(names(diff.Dels), test.dels) {
+			t.Errorf("Depth %d:\nExpected dels: %v\nGot: %v\n", test.depth, test.dels, names(diff.Dels))
+		}
+		if !reflect.DeepEqual(modNames(diff.Mods), test.mods) {
+			t.Errorf("Depth %d:\nExpected mods: %v\nGot: %v\n", test.depth, test.mods, modNames(diff.Mods))
+		}
+	}
+}
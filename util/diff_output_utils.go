@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
 
 	"github.com/sirupsen/logrus"
 )
@@ -249,6 +250,16 @@ func (r MetadataDiffResult) OutputText(writer io.Writer, diffType string, format
 	return TemplateOutputFromFormat(writer, r, "MetadataDiff", format)
 }
 
+type IndexDiffResult DiffResult
+
+func (r IndexDiffResult) OutputStruct() interface{} {
+	return r
+}
+
+func (r IndexDiffResult) OutputText(writer io.Writer, diffType string, format string) error {
+	return TemplateOutputFromFormat(writer, r, "IndexDiff", format)
+}
+
 type DirDiffResult DiffResult
 
 func (r DirDiffResult) OutputStruct() interface{} {
@@ -258,7 +269,15 @@ func (r DirDiffResult) OutputStruct() interface{} {
 		return errors.New("Could not output FileAnalyzer diff result")
 	}
 
-	r.Diff = sortDirDiff(diff)
+	diff = sortDirDiff(diff)
+	if SummaryDepth > 0 {
+		r.Diff = struct {
+			DirDiff
+			Summary *DirSizeDelta
+		}{diff, GetDirSizeDeltas(diff, SummaryDepth)}
+		return r
+	}
+	r.Diff = diff
 	return r
 }
 
@@ -273,11 +292,18 @@ func (r DirDiffResult) OutputText(writer io.Writer, diffType string, format stri
 	strAdds := stringifyDirectoryEntries(diff.Adds)
 	strDels := stringifyDirectoryEntries(diff.Dels)
 	strMods := stringifyEntryDiffs(diff.Mods)
+	strMoves := stringifyEntryMoves(diff.Moves)
+	var strSummary []StrDirSizeDelta
+	if SummaryDepth > 0 {
+		strSummary = stringifyDirSizeDeltas(GetDirSizeDeltas(diff, SummaryDepth), 0)
+	}
 
 	type StrDiff struct {
-		Adds []StrDirectoryEntry
-		Dels []StrDirectoryEntry
-		Mods []StrEntryDiff
+		Adds    []StrDirectoryEntry
+		Dels    []StrDirectoryEntry
+		Mods    []StrEntryDiff
+		Moves   []StrEntryMove
+		Summary []StrDirSizeDelta
 	}
 
 	strResult := struct {
@@ -290,9 +316,11 @@ func (r DirDiffResult) OutputText(writer io.Writer, diffType string, format stri
 		Image2:   r.Image2,
 		DiffType: r.DiffType,
 		Diff: StrDiff{
-			Adds: strAdds,
-			Dels: strDels,
-			Mods: strMods,
+			Adds:    strAdds,
+			Dels:    strDels,
+			Mods:    strMods,
+			Moves:   strMoves,
+			Summary: strSummary,
 		},
 	}
 	return TemplateOutputFromFormat(writer, strResult, "DirDiff", format)
@@ -396,21 +424,32 @@ func (r MultipleDirDiffResult) OutputText(writer io.Writer, diffType string, for
 	}
 
 	type StrDiff struct {
-		Adds []StrDirectoryEntry
-		Dels []StrDirectoryEntry
-		Mods []StrEntryDiff
+		Layer  string
+		Status string
+		Adds   []StrDirectoryEntry
+		Dels   []StrDirectoryEntry
+		Mods   []StrEntryDiff
+		Moves  []StrEntryMove
 	}
 
 	var strDiffs []StrDiff
-	for _, d := range diff.DirDiffs {
+	for i, d := range diff.DirDiffs {
 		strAdds := stringifyDirectoryEntries(d.Adds)
 		strDels := stringifyDirectoryEntries(d.Dels)
 		strMods := stringifyEntryDiffs(d.Mods)
+		strMoves := stringifyEntryMoves(d.Moves)
 
+		layer, status := strconv.Itoa(i), ""
+		if i < len(diff.Layers) {
+			layer, status = diff.Layers[i].Label(), diff.Layers[i].Status
+		}
 		strDiffs = append(strDiffs, StrDiff{
-			Adds: strAdds,
-			Dels: strDels,
-			Mods: strMods,
+			Layer:  layer,
+			Status: status,
+			Adds:   strAdds,
+			Dels:   strDels,
+			Mods:   strMods,
+			Moves:  strMoves,
 		})
 
 	}
@@ -431,3 +470,113 @@ func (r MultipleDirDiffResult) OutputText(writer io.Writer, diffType string, for
 	}
 	return TemplateOutputFromFormat(writer, strResult, "MultipleDirDiff", format)
 }
+
+type FileNameDiffResult DiffResult
+
+func (r FileNameDiffResult) OutputStruct() interface{} {
+	return r
+}
+
+func (r FileNameDiffResult) OutputText(writer io.Writer, diffType string, format string) error {
+	diffs, valid := r.Diff.([]FileNameDiff)
+	if !valid {
+		logrus.Error("Unexpected structure of Diff.  Should follow the FileNameDiff struct")
+		return errors.New("Could not output filename diff result")
+	}
+
+	strResult := struct {
+		Image1   string
+		Image2   string
+		DiffType string
+		Diff     []StrFileNameDiff
+	}{
+		Image1:   r.Image1,
+		Image2:   r.Image2,
+		DiffType: r.DiffType,
+		Diff:     stringifyFileNameDiffs(diffs),
+	}
+	return TemplateOutputFromFormat(writer, strResult, "FilenameDiff", format)
+}
+
+type EfficiencyDiffResult DiffResult
+
+func (r EfficiencyDiffResult) OutputStruct() interface{} {
+	return r
+}
+
+func (r EfficiencyDiffResult) OutputText(writer io.Writer, diffType string, format string) error {
+	diff, valid := r.Diff.(EfficiencyDiff)
+	if !valid {
+		logrus.Error("Unexpected structure of Diff.  Should follow the EfficiencyDiff struct")
+		return errors.New("Could not output EfficiencyAnalyzer diff result")
+	}
+
+	type StrDiff struct {
+		Efficiency1 StrEfficiency
+		Efficiency2 StrEfficiency
+	}
+
+	strResult := struct {
+		Image1   string
+		Image2   string
+		DiffType string
+		Diff     StrDiff
+	}{
+		Image1:   r.Image1,
+		Image2:   r.Image2,
+		DiffType: r.DiffType,
+		Diff: StrDiff{
+			Efficiency1: stringifyEfficiency(diff.Efficiency1),
+			Efficiency2: stringifyEfficiency(diff.Efficiency2),
+		},
+	}
+	return TemplateOutputFromFormat(writer, strResult, "EfficiencyDiff", format)
+}
+
+type DuplicatesDiffResult DiffResult
+
+func (r DuplicatesDiffResult) OutputStruct() interface{} {
+	diff, valid := r.Diff.(DuplicatesDiff)
+	if !valid {
+		logrus.Error("Unexpected structure of Diff.  Should follow the DuplicatesDiff struct")
+		return errors.New("Could not output DuplicatesAnalyzer diff result")
+	}
+	r.Diff = sortDuplicatesDiff(diff)
+	return r
+}
+
+func (r DuplicatesDiffResult) OutputText(writer io.Writer, diffType string, format string) error {
+	diff, valid := r.Diff.(DuplicatesDiff)
+	if !valid {
+		logrus.Error("Unexpected structure of Diff.  Should follow the DuplicatesDiff struct")
+		return errors.New("Could not output DuplicatesAnalyzer diff result")
+	}
+	diff = sortDuplicatesDiff(diff)
+
+	type StrDiff struct {
+		Adds         []StrDuplicateSet
+		Dels         []StrDuplicateSet
+		Mods         []StrDuplicateSetDiff
+		Reclaimable1 string
+		Reclaimable2 string
+	}
+
+	strResult := struct {
+		Image1   string
+		Image2   string
+		DiffType string
+		Diff     StrDiff
+	}{
+		Image1:   r.Image1,
+		Image2:   r.Image2,
+		DiffType: r.DiffType,
+		Diff: StrDiff{
+			Adds:         stringifyDuplicateSets(diff.Adds),
+			Dels:         stringifyDuplicateSets(diff.Dels),
+			Mods:         stringifyDuplicateSetDiffs(diff.Mods),
+			Reclaimable1: stringifySize(diff.Reclaimable1),
+			Reclaimable2: stringifySize(diff.Reclaimable2),
+		},
+	}
+	return TemplateOutputFromFormat(writer, strResult, "DuplicatesDiff", format)
+}
@@ -32,25 +32,46 @@ type DirDiff struct {
 	Adds []pkgutil.DirectoryEntry
 	Dels []pkgutil.DirectoryEntry
 	Mods []EntryDiff
+	// Moves pairs files deleted from one path and added at another, which
+	// are then not part of Adds and Dels
+	Moves []EntryMove `json:",omitempty"`
 }
 
 type MultipleDirDiff struct {
 	DirDiffs []DirDiff
+	// Layers lists the layers paired by AlignLayers, each with the DirDiff
+	// at the same index
+	Layers []LayerMatch `json:",omitempty"`
 }
 
 type FileNameDiff struct {
 	Filename    string
 	Description string
 	Diff        string
+	// Binary is set if either file holds binary content. The sizes and
+	// sha256 digests of the files are then given, and Diff is a diff of their
+	// hex dumps if HexDump is set.
+	Binary  bool   `json:",omitempty"`
+	Size1   int64  `json:",omitempty"`
+	Size2   int64  `json:",omitempty"`
+	Digest1 string `json:",omitempty"`
+	Digest2 string `json:",omitempty"`
+	// Changes lists the keys that changed between structured config files
+	// diffed with StructuredDiff
+	Changes []KeyChange `json:",omitempty"`
 }
 
 type EntryDiff struct {
 	Name  string
 	Size1 int64
 	Size2 int64
+	// Changes classifies the modification, e.g. content, mode or ownership
+	Changes []EntryChange `json:",omitempty"`
 }
 
-// Modification of difflib's unified differ
+// Modification of difflib's unified differ. GetAdditions, GetDeletions and
+// GetMatches align a and b as sequences, so are meant for lists where order
+// matters such as image history; use DiffPathSets for sets of paths.
 func GetAdditions(a, b []string) []string {
 	matcher := difflib.NewMatcher(a, b)
 	differences := matcher.GetGroupedOpCodes(0)
@@ -98,168 +119,227 @@ func GetMatches(a, b []string) []string {
 	return matches
 }
 
+// DiffPathSets compares a and b as sets of paths in linear time. It returns
+// the paths only in a, the paths only in b, and the paths in both, each in
+// the order of the slice they come from.
+func DiffPathSets(a, b []string) (onlyA, onlyB, both []string) {
+	inA := make(map[string]struct{}, len(a))
+	for _, p := range a {
+		inA[p] = struct{}{}
+	}
+	inB := make(map[string]struct{}, len(b))
+	for _, p := range b {
+		inB[p] = struct{}{}
+	}
+
+	onlyA, onlyB, both = []string{}, []string{}, []string{}
+	for _, p := range a {
+		if _, ok := inB[p]; ok {
+			both = append(both, p)
+		} else {
+			onlyA = append(onlyA, p)
+		}
+	}
+	for _, p := range b {
+		if _, ok := inA[p]; !ok {
+			onlyB = append(onlyB, p)
+		}
+	}
+	return onlyA, onlyB, both
+}
+
 // DiffDirectory takes the diff of two directories, assuming both are completely unpacked
 func DiffDirectory(d1, d2 pkgutil.Directory) (DirDiff, bool) {
-	adds := GetAddedEntries(d1, d2)
+	dels, adds, matches := DiffPathSets(d1.Content, d2.Content)
 	sort.Strings(adds)
-	addedEntries := pkgutil.CreateDirectoryEntries(d2.Root, adds)
-
-	dels := GetDeletedEntries(d1, d2)
 	sort.Strings(dels)
-	deletedEntries := pkgutil.CreateDirectoryEntries(d1.Root, dels)
+	moves, dels, adds := detectMoves(d1, d2, dels, adds)
 
-	mods := GetModifiedEntries(d1, d2)
+	addedEntries := pkgutil.GetDirectoryEntriesForNames(d2, adds)
+	deletedEntries := pkgutil.GetDirectoryEntriesForNames(d1, dels)
+
+	mods, changes := getModifiedEntries(d1, d2, matches)
 	sort.Strings(mods)
-	modifiedEntries := createEntryDiffs(d1.Root, d2.Root, mods)
+	modifiedEntries := createEntryDiffs(d1, d2, mods, changes)
+	if ArchiveDepth > 0 {
+		innerAdds, innerDels, innerMods := diffModifiedArchives(d1, d2, mods)
+		addedEntries = append(addedEntries, innerAdds...)
+		deletedEntries = append(deletedEntries, innerDels...)
+		modifiedEntries = append(modifiedEntries, innerMods...)
+		directoryBy(directoryNameSort).Sort(addedEntries)
+		directoryBy(directoryNameSort).Sort(deletedEntries)
+		entryDiffBy(entryDiffNameSort).Sort(modifiedEntries)
+	}
 
 	var same bool
-	if len(adds) == 0 && len(dels) == 0 && len(mods) == 0 {
+	if len(adds) == 0 && len(dels) == 0 && len(mods) == 0 && len(moves) == 0 {
 		same = true
 	} else {
 		same = false
 	}
 
-	return DirDiff{addedEntries, deletedEntries, modifiedEntries}, same
+	return DirDiff{addedEntries, deletedEntries, modifiedEntries, moves}, same
 }
 
 func DiffFile(image1, image2 *pkgutil.Image, filename string) (*FileNameDiff, error) {
+	if !pkgutil.Filter.Matches(filename, false) {
+		return nil, fmt.Errorf("%s is excluded by the path filters", filename)
+	}
 	//Join paths
 	image1FilePath := filepath.Join(image1.FSPath, filename)
 	image2FilePath := filepath.Join(image2.FSPath, filename)
 
-	//Get contents of files
-	image1FileContents, err := pkgutil.GetFileContents(image1FilePath)
-	if err != nil {
-		return nil, err
+	//A file missing from one image is diffed against nothing
+	_, err := os.Lstat(image1FilePath)
+	missing1 := os.IsNotExist(err)
+	_, err = os.Lstat(image2FilePath)
+	missing2 := os.IsNotExist(err)
+
+	description := ""
+	if missing1 && missing2 {
+		return nil, fmt.Errorf("%s does not exist in %s or %s", filename, image1.Source, image2.Source)
+	}
+	if missing1 {
+		description = fmt.Sprintf("%s only exists in %s", filename, image2.Source)
+	}
+	if missing2 {
+		description = fmt.Sprintf("%s only exists in %s", filename, image1.Source)
 	}
 
-	image2FileContents, err := pkgutil.GetFileContents(image2FilePath)
+	binary, err := isBinaryFile(image1FilePath, missing1)
 	if err != nil {
 		return nil, err
 	}
+	if !binary {
+		binary, err = isBinaryFile(image2FilePath, missing2)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if binary {
+		return diffBinaryFiles(image1, image2, filename, description, missing1, missing2)
+	}
+
+	//Get contents of files
+	var image1FileContents, image2FileContents *string
+	if !missing1 {
+		if image1FileContents, err = pkgutil.GetFileContents(image1FilePath); err != nil {
+			return nil, err
+		}
+	}
+	if !missing2 {
+		if image2FileContents, err = pkgutil.GetFileContents(image2FilePath); err != nil {
+			return nil, err
+		}
+	}
+
+	if missing1 || missing2 {
+		text, err := getUnifiedDiff(image1.Source, image2.Source, image1FileContents, image2FileContents)
+		if err != nil {
+			return nil, err
+		}
+		return &FileNameDiff{Filename: filename, Description: description, Diff: text}, nil
+	}
 
-	description := ""
 	//Check if file contents are empty or if they are the same
 	if image1FileContents == nil && image2FileContents == nil {
 		description := "Both files are empty"
-		return &FileNameDiff{filename, description, ""}, nil
+		return &FileNameDiff{Filename: filename, Description: description}, nil
 	}
 
 	if image1FileContents == nil {
 		description := fmt.Sprintf("%s contains an empty file, the contents of %s are:", image1.Source, image2.Source)
-		return &FileNameDiff{filename, description, *image2FileContents}, nil
+		return &FileNameDiff{Filename: filename, Description: description, Diff: *image2FileContents}, nil
 	}
 
 	if image2FileContents == nil {
 		description := fmt.Sprintf("%s contains an empty file, the contents of %s are:", image2.Source, image1.Source)
-		return &FileNameDiff{filename, description, *image1FileContents}, nil
+		return &FileNameDiff{Filename: filename, Description: description, Diff: *image1FileContents}, nil
 	}
 
 	if *image1FileContents == *image2FileContents {
 		description := "Both files are the same, the contents are:"
-		return &FileNameDiff{filename, description, *image1FileContents}, nil
+		return &FileNameDiff{Filename: filename, Description: description, Diff: *image1FileContents}, nil
 	}
 
-	//Carry on with diffing, make string array for difflib requirements
-	image1Contents := difflib.SplitLines(string(*image1FileContents))
-	image2Contents := difflib.SplitLines(string(*image2FileContents))
+	if StructuredDiff && IsStructuredFile(filename) {
+		if diff, ok := diffStructuredFiles(filename, *image1FileContents, *image2FileContents); ok {
+			return diff, nil
+		}
+	}
+
+	text, err := getUnifiedDiff(image1.Source, image2.Source, image1FileContents, image2FileContents)
+	if err != nil {
+		return nil, err
+	}
+	return &FileNameDiff{Filename: filename, Description: description, Diff: text}, nil
+}
+
+// getUnifiedDiff diffs the lines of two files, nil contents standing for an
+// empty or missing file
+func getUnifiedDiff(from, to string, contents1, contents2 *string) (string, error) {
+	//Make string array for difflib requirements
+	var image1Contents, image2Contents []string
+	if contents1 != nil {
+		image1Contents = difflib.SplitLines(*contents1)
+	}
+	if contents2 != nil {
+		image2Contents = difflib.SplitLines(*contents2)
+	}
 
 	//Run diff
 	diff := difflib.UnifiedDiff{
 		A:        image1Contents,
 		B:        image2Contents,
-		FromFile: image1.Source,
-		ToFile:   image2.Source,
+		FromFile: from,
+		ToFile:   to,
 	}
-
-	text, err := difflib.GetUnifiedDiffString(diff)
-
-	if err != nil {
-		return nil, err
-	}
-	return &FileNameDiff{filename, description, text}, nil
+	return difflib.GetUnifiedDiffString(diff)
 }
 
-// Checks for content differences between files of the same name from different directories
+// Checks for differences in content or metadata between entries of the same name from different directories
 func GetModifiedEntries(d1, d2 pkgutil.Directory) []string {
-	d1files := d1.Content
-	d2files := d2.Content
-
-	filematches := GetMatches(d1files, d2files)
+	_, _, matches := DiffPathSets(d1.Content, d2.Content)
+	modified, _ := getModifiedEntries(d1, d2, matches)
+	return modified
+}
 
+// getModifiedEntries returns the entries among filematches, the entries in
+// both d1 and d2, that differ between them, along with how each changed
+func getModifiedEntries(d1, d2 pkgutil.Directory, filematches []string) ([]string, map[string][]EntryChange) {
 	modified := []string{}
+	changes := map[string][]EntryChange{}
 	for _, f := range filematches {
-		f1path := fmt.Sprintf("%s%s", d1.Root, f)
-		f2path := fmt.Sprintf("%s%s", d2.Root, f)
-
-		f1stat, err := os.Lstat(f1path)
+		entryChanges, err := getEntryChanges(d1, d2, f)
 		if err != nil {
 			logrus.Errorf("Error checking directory entry %s: %s\n", f, err)
 			continue
 		}
-		f2stat, err := os.Lstat(f2path)
-		if err != nil {
-			logrus.Errorf("Error checking directory entry %s: %s\n", f, err)
-			continue
-		}
-
-		// If the directory entry is a symlink, make sure the symlinks point to the same place
-		if f1stat.Mode()&os.ModeSymlink != 0 && f2stat.Mode()&os.ModeSymlink != 0 {
-			same, err := pkgutil.CheckSameSymlink(f1path, f2path)
-			if err != nil {
-				logrus.Errorf("Error determining if symlink %s and %s are equivalent: %s\n", f1path, f2path, err)
-				continue
-			}
-			if !same {
-				modified = append(modified, f)
-			}
-			continue
-		}
-
-		// If the directory entry in question is a tar, verify that the two have the same size
-		if pkgutil.IsTar(f1path) {
-			if f1stat.Size() != f2stat.Size() {
-				modified = append(modified, f)
-			}
-			continue
-		}
-
-		// If the directory entry is not a tar and not a directory, then it's a file so make sure the file contents are the same
-		// Note: We skip over directory entries because to compare directories, we compare their contents
-		if !f1stat.IsDir() {
-			same, err := pkgutil.CheckSameFile(f1path, f2path)
-			if err != nil {
-				logrus.Errorf("Error diffing contents of %s and %s: %s\n", f1path, f2path, err)
-				continue
-			}
-			if !same {
-				modified = append(modified, f)
-			}
+		if len(entryChanges) > 0 {
+			modified = append(modified, f)
+			changes[f] = entryChanges
 		}
 	}
-	return modified
+	return modified, changes
 }
 
 func GetAddedEntries(d1, d2 pkgutil.Directory) []string {
-	return GetAdditions(d1.Content, d2.Content)
+	_, adds, _ := DiffPathSets(d1.Content, d2.Content)
+	return adds
 }
 
 func GetDeletedEntries(d1, d2 pkgutil.Directory) []string {
-	return GetDeletions(d1.Content, d2.Content)
+	dels, _, _ := DiffPathSets(d1.Content, d2.Content)
+	return dels
 }
 
-func createEntryDiffs(root1, root2 string, entryNames []string) (entries []EntryDiff) {
+func createEntryDiffs(d1, d2 pkgutil.Directory, entryNames []string, changes map[string][]EntryChange) (entries []EntryDiff) {
 	for _, name := range entryNames {
-		entryPath1 := filepath.Join(root1, name)
-		size1 := pkgutil.GetSize(entryPath1)
-
-		entryPath2 := filepath.Join(root2, name)
-		size2 := pkgutil.GetSize(entryPath2)
-
 		entry := EntryDiff{
-			Name:  name,
-			Size1: size1,
-			Size2: size2,
+			Name:    name,
+			Size1:   pkgutil.GetEntrySize(d1, name),
+			Size2:   pkgutil.GetEntrySize(d2, name),
+			Changes: changes[name],
 		}
 		entries = append(entries, entry)
 	}
@@ -0,0 +1,83 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"reflect"
+
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+)
+
+// DuplicateSetDiff is a set of duplicate files found in both images, with
+// copies at different paths
+type DuplicateSetDiff struct {
+	Sha256       string
+	Size         int64
+	Files1       []string
+	Files2       []string
+	Reclaimable1 int64
+	Reclaimable2 int64
+}
+
+// DuplicatesDiff compares the duplicate files of two images, sets being
+// matched by the hash of their contents
+type DuplicatesDiff struct {
+	// Adds lists the sets only duplicated in the second image
+	Adds []pkgutil.DuplicateSet
+	// Dels lists the sets only duplicated in the first image
+	Dels []pkgutil.DuplicateSet
+	Mods []DuplicateSetDiff
+	// Reclaimable1 and Reclaimable2 are the total reclaimable space of the
+	// duplicates of each image
+	Reclaimable1 int64
+	Reclaimable2 int64
+}
+
+// DiffDuplicates compares the duplicate sets of two images
+func DiffDuplicates(sets1, sets2 []pkgutil.DuplicateSet) DuplicatesDiff {
+	diff := DuplicatesDiff{}
+	bySha := map[string]pkgutil.DuplicateSet{}
+	for _, set := range sets1 {
+		bySha[set.Sha256] = set
+		diff.Reclaimable1 += set.Reclaimable
+	}
+	for _, set := range sets2 {
+		diff.Reclaimable2 += set.Reclaimable
+		set1, ok := bySha[set.Sha256]
+		if !ok {
+			diff.Adds = append(diff.Adds, set)
+			continue
+		}
+		delete(bySha, set.Sha256)
+		if !reflect.DeepEqual(set1.Files, set.Files) {
+			diff.Mods = append(diff.Mods, DuplicateSetDiff{
+				Sha256:       set.Sha256,
+				Size:         set.Size,
+				Files1:       set1.Files,
+				Files2:       set.Files,
+				Reclaimable1: set1.Reclaimable,
+				Reclaimable2: set.Reclaimable,
+			})
+		}
+	}
+	for _, set := range sets1 {
+		if _, ok := bySha[set.Sha256]; ok {
+			diff.Dels = append(diff.Dels, set)
+		}
+	}
+	return diff
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+)
+
+func TestGetDuplicates(t *testing.T) {
+	parent, err := ioutil.TempDir("", "duplicates-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(parent)
+
+	dir := extractTestLayer(t, parent, "root",
+		pkgutil.TestTarEntry{Name: "usr/lib/libfoo.so", Contents: "libfoo"},
+		// a hard link isn't a copy of its own
+		pkgutil.TestTarEntry{Name: "usr/lib/libfoo.so.1", Typeflag: '1', Linkname: "usr/lib/libfoo.so"},
+		pkgutil.TestTarEntry{Name: "app/vendor/libfoo.so", Contents: "libfoo"},
+		pkgutil.TestTarEntry{Name: "opt/libfoo.so", Contents: "libfoo"},
+		// same size, different contents
+		pkgutil.TestTarEntry{Name: "opt/libbar.so", Contents: "libbar"},
+		pkgutil.TestTarEntry{Name: "etc/empty1", Contents: ""},
+		pkgutil.TestTarEntry{Name: "etc/empty2", Contents: ""},
+	)
+
+	sets, err := pkgutil.GetDuplicates(dir.Root)
+	if err != nil {
+		t.Fatalf("Error getting duplicates: %s", err)
+	}
+	if len(sets) != 1 {
+		t.Fatalf("Expected one duplicate set, got %v", sets)
+	}
+	expected := pkgutil.DuplicateSet{
+		Sha256:      "3213244fb8a3fecdecf01b10b5cb1b1c853dde9d7e9f232bd6e001c442951185",
+		Size:        6,
+		Files:       []string{"/app/vendor/libfoo.so", "/opt/libfoo.so", "/usr/lib/libfoo.so"},
+		Reclaimable: 12,
+	}
+	if !reflect.DeepEqual(sets[0], expected) {
+		t.Errorf("\nExpected: %v\nGot: %v\n", expected, sets[0])
+	}
+}
+
+func TestDiffDuplicates(t *testing.T) {
+	sets1 := []pkgutil.DuplicateSet{
+		{Sha256: "lib", Size: 10, Files: []string{"/a/lib.so", "/b/lib.so"}, Reclaimable: 10},
+		{Sha256: "old", Size: 5, Files: []string{"/a/old", "/b/old"}, Reclaimable: 5},
+	}
+	sets2 := []pkgutil.DuplicateSet{
+		{Sha256: "lib", Size: 10, Files: []string{"/a/lib.so", "/b/lib.so", "/c/lib.so"}, Reclaimable: 20},
+		{Sha256: "new", Size: 1, Files: []string{"/a/new", "/b/new"}, Reclaimable: 1},
+	}
+	expected := DuplicatesDiff{
+		Adds: []pkgutil.DuplicateSet{sets2[1]},
+		Dels: []pkgutil.DuplicateSet{sets1[1]},
+		Mods: []DuplicateSetDiff{
+			{Sha256: "lib", Size: 10, Files1: sets1[0].Files, Files2: sets2[0].Files, Reclaimable1: 10, Reclaimable2: 20},
+		},
+		Reclaimable1: 15,
+		Reclaimable2: 21,
+	}
+	if diff := DiffDuplicates(sets1, sets2); !reflect.DeepEqual(diff, expected) {
+		t.Errorf("\nExpected: %v\nGot: %v\n", expected, diff)
+	}
+}
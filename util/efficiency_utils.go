@@ -0,0 +1,220 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"path/filepath"
+	"sort"
+
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+)
+
+// EfficiencyTop is the number of wasted entries the efficiency analyzer
+// reports, largest first. A negative value reports them all.
+var EfficiencyTop = 20
+
+// Reasons for a WastedEntry
+const (
+	// WasteOverwritten is a file replaced by a later layer
+	WasteOverwritten = "overwritten"
+	// WasteDeleted is a file deleted by a whiteout in a later layer
+	WasteDeleted = "deleted"
+	// WasteDuplicate is a file with the same contents as a file written by
+	// an earlier layer
+	WasteDuplicate = "duplicate"
+)
+
+// WastedEntry is a copy of a file that takes up space in a layer of an image
+// without being needed in its final file system
+type WastedEntry struct {
+	Name string
+	Size int64
+	// Layer is the index of the layer that introduced the wasted copy
+	Layer  int
+	Digest string
+	// CreatedBy is the history line of the command that created that layer
+	CreatedBy string `json:",omitempty"`
+	Reason    string
+	// By is the index of the layer that overwrote or deleted the copy, or
+	// that wrote the first copy of a duplicate
+	By int
+	// Original is the first copy of a duplicate
+	Original string `json:",omitempty"`
+}
+
+// Efficiency reports how much of the files stored in the layers of an image
+// end up in its final file system
+type Efficiency struct {
+	// TotalSize is the size of the regular files stored in all the layers
+	TotalSize int64
+	// WastedSize is the size of the copies overwritten, deleted or
+	// duplicated by a later layer
+	WastedSize int64
+	// Score is the share of TotalSize that isn't wasted, from 0 to 1
+	Score float64
+	// Wasted lists the EfficiencyTop largest wasted copies
+	Wasted []WastedEntry
+}
+
+// EfficiencyDiff compares the efficiency of two images
+type EfficiencyDiff struct {
+	Efficiency1 Efficiency
+	Efficiency2 Efficiency
+}
+
+// GetEfficiency walks the extracted layers of image in order, and finds the
+// files that later layers overwrite or delete, as well as the files of the
+// final file system duplicated across layers.
+func GetEfficiency(image pkgutil.Image) (Efficiency, error) {
+	history := pkgutil.GetLayerHistory(image)
+	efficiency := Efficiency{Score: 1}
+	var wasted []WastedEntry
+	waste := func(entry pkgutil.DirectoryEntry, reason string, by int) {
+		wasted = append(wasted, WastedEntry{
+			Name:      entry.Name,
+			Size:      entry.Size,
+			Layer:     entry.Provenance.Layer,
+			Digest:    entry.Provenance.Digest,
+			CreatedBy: entry.Provenance.CreatedBy,
+			Reason:    reason,
+			By:        by,
+		})
+	}
+
+	// lower holds the regular files present after the layers walked so
+	// far, their provenance recording the layer that wrote them
+	lower := map[string]pkgutil.DirectoryEntry{}
+	// links maps each name of a file hard linked within the layer that
+	// wrote it to all of its names. One name carries the size of the file,
+	// handed over to another name when it goes, so the file is only wasted
+	// once all its names are gone.
+	links := map[string][]string{}
+	discard := func(entry pkgutil.DirectoryEntry, reason string, by int) {
+		names := links[entry.Name]
+		delete(links, entry.Name)
+		for _, name := range names {
+			if other, ok := lower[name]; ok && other.Provenance.Layer == entry.Provenance.Layer {
+				other.Size += entry.Size
+				lower[name] = other
+				return
+			}
+		}
+		if len(names) > 0 && entry.Size == 0 {
+			// another name carried the size of the file
+			return
+		}
+		waste(entry, reason, by)
+	}
+	for i, layer := range image.Layers {
+		for _, entry := range pkgutil.ApplyWhiteouts(lower, layer.Whiteouts) {
+			discard(entry, WasteDeleted, i)
+		}
+
+		layerDir, err := pkgutil.GetDirectoryWithHashes(layer.FSPath)
+		if err != nil {
+			return efficiency, err
+		}
+		inodes := map[pkgutil.Inode][]string{}
+		for _, entry := range pkgutil.GetDirectoryEntries(layerDir) {
+			// whatever the layer writes over a file replaces it
+			if old, ok := lower[entry.Name]; ok {
+				delete(lower, entry.Name)
+				discard(old, WasteOverwritten, i)
+			}
+			if entry.Sha256 == "" {
+				continue
+			}
+			if id, ok := pkgutil.GetInode(filepath.Join(layer.FSPath, entry.Name)); ok {
+				if len(inodes[id]) > 0 {
+					entry.Size = 0
+				}
+				inodes[id] = append(inodes[id], entry.Name)
+			}
+			entry.Provenance = &pkgutil.EntryProvenance{Layer: i, Digest: layer.Digest.String(), CreatedBy: history[i]}
+			efficiency.TotalSize += entry.Size
+			lower[entry.Name] = entry
+		}
+		for _, names := range inodes {
+			for _, name := range names {
+				links[name] = names
+			}
+		}
+	}
+	wasted = append(wasted, getDuplicates(lower)...)
+
+	for _, entry := range wasted {
+		efficiency.WastedSize += entry.Size
+	}
+	if efficiency.TotalSize > 0 {
+		efficiency.Score = float64(efficiency.TotalSize-efficiency.WastedSize) / float64(efficiency.TotalSize)
+	}
+	sort.SliceStable(wasted, func(i, j int) bool {
+		if wasted[i].Size != wasted[j].Size {
+			return wasted[i].Size > wasted[j].Size
+		}
+		return wasted[i].Name < wasted[j].Name
+	})
+	if EfficiencyTop >= 0 && len(wasted) > EfficiencyTop {
+		wasted = wasted[:EfficiencyTop]
+	}
+	efficiency.Wasted = wasted
+	return efficiency, nil
+}
+
+// getDuplicates groups the files of the final file system by contents, and
+// returns those written by a later layer than the first copy. Copies in the
+// same layer as the first one aren't counted, as they aren't duplicated
+// across layers.
+func getDuplicates(files map[string]pkgutil.DirectoryEntry) []WastedEntry {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	first := map[string]pkgutil.DirectoryEntry{}
+	for _, name := range names {
+		entry := files[name]
+		if entry.Size == 0 {
+			continue
+		}
+		if original, ok := first[entry.Sha256]; !ok || entry.Provenance.Layer < original.Provenance.Layer {
+			first[entry.Sha256] = entry
+		}
+	}
+
+	var duplicates []WastedEntry
+	for _, name := range names {
+		entry := files[name]
+		original, ok := first[entry.Sha256]
+		// further names of a hard linked file take no space
+		if !ok || entry.Size == 0 || entry.Provenance.Layer == original.Provenance.Layer {
+			continue
+		}
+		duplicates = append(duplicates, WastedEntry{
+			Name:      entry.Name,
+			Size:      entry.Size,
+			Layer:     entry.Provenance.Layer,
+			Digest:    entry.Provenance.Digest,
+			CreatedBy: entry.Provenance.CreatedBy,
+			Reason:    WasteDuplicate,
+			By:        original.Provenance.Layer,
+			Original:  original.Name,
+		})
+	}
+	return duplicates
+}
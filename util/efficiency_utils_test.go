@@ -0,0 +1,138 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+)
+
+func TestGetEfficiency(t *testing.T) {
+	parent, err := ioutil.TempDir("", "efficiency-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(parent)
+
+	layers := extractTestLayers(t, parent, [][]pkgutil.TestTarEntry{
+		{
+			{Name: "etc/a", Contents: "aaaa"},
+			{Name: "etc/b", Contents: "bbbbbb"},
+			{Name: "lib/x", Contents: "shared!"},
+		},
+		{
+			{Name: "etc/a", Contents: "a1"},
+			{Name: "etc/.wh.b"},
+			{Name: "opt/y", Contents: "shared!"},
+		},
+		{
+			// copies within a layer aren't duplicated across layers
+			{Name: "etc/c", Contents: "c"},
+			{Name: "etc/d", Contents: "c"},
+		},
+	})
+	image := pkgutil.Image{Layers: layers}
+
+	expected := Efficiency{
+		TotalSize:  28,
+		WastedSize: 17,
+		Score:      11.0 / 28,
+		Wasted: []WastedEntry{
+			{Name: "/opt/y", Size: 7, Layer: 1, Digest: layers[1].Digest.String(), Reason: WasteDuplicate, By: 0, Original: "/lib/x"},
+			{Name: "/etc/b", Size: 6, Layer: 0, Digest: layers[0].Digest.String(), Reason: WasteDeleted, By: 1},
+			{Name: "/etc/a", Size: 4, Layer: 0, Digest: layers[0].Digest.String(), Reason: WasteOverwritten, By: 1},
+		},
+	}
+	efficiency, err := GetEfficiency(image)
+	if err != nil {
+		t.Fatalf("Error getting efficiency: %s", err)
+	}
+	if !reflect.DeepEqual(efficiency, expected) {
+		t.Errorf("\nExpected: %v\nGot: %v\n", expected, efficiency)
+	}
+
+	defer func() { EfficiencyTop = 20 }()
+	EfficiencyTop = 1
+	expected.Wasted = expected.Wasted[:1]
+	efficiency, err = GetEfficiency(image)
+	if err != nil {
+		t.Fatalf("Error getting efficiency: %s", err)
+	}
+	if !reflect.DeepEqual(efficiency, expected) {
+		t.Errorf("\nExpected: %v\nGot: %v\n", expected, efficiency)
+	}
+}
+
+func TestGetEfficiencyReplacedAndHardLinkedFiles(t *testing.T) {
+	parent, err := ioutil.TempDir("", "efficiency-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(parent)
+
+	layers := extractTestLayers(t, parent, [][]pkgutil.TestTarEntry{
+		{
+			{Name: "etc/a", Contents: "aaaa"},
+			{Name: "etc/b", Contents: "bbbbbb"},
+			// hard links are counted once
+			{Name: "lib/x", Contents: "xxxxxxxx"},
+			{Name: "lib/y", Typeflag: tar.TypeLink, Linkname: "lib/x"},
+			{Name: "lib/z", Typeflag: tar.TypeLink, Linkname: "lib/x"},
+			{Name: "lib/w", Contents: "ww"},
+			{Name: "lib/v", Typeflag: tar.TypeLink, Linkname: "lib/w"},
+		},
+		{
+			// files replaced by a symlink and a directory
+			{Name: "etc/a", Typeflag: tar.TypeSymlink, Linkname: "b"},
+			{Name: "etc/b/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "etc/b/c", Contents: "c"},
+			// the other names of lib/x still hold its contents
+			{Name: "lib/x", Contents: "x1"},
+			{Name: "lib/.wh.w"},
+			{Name: "lib/.wh.v"},
+		},
+		{
+			{Name: "lib/y", Contents: "y"},
+			{Name: "lib/z", Contents: "z"},
+		},
+	})
+	image := pkgutil.Image{Layers: layers}
+
+	expected := Efficiency{
+		TotalSize:  25,
+		WastedSize: 20,
+		Score:      5.0 / 25,
+		Wasted: []WastedEntry{
+			{Name: "/lib/z", Size: 8, Layer: 0, Digest: layers[0].Digest.String(), Reason: WasteOverwritten, By: 2},
+			{Name: "/etc/b", Size: 6, Layer: 0, Digest: layers[0].Digest.String(), Reason: WasteOverwritten, By: 1},
+			{Name: "/etc/a", Size: 4, Layer: 0, Digest: layers[0].Digest.String(), Reason: WasteOverwritten, By: 1},
+			{Name: "/lib/v", Size: 2, Layer: 0, Digest: layers[0].Digest.String(), Reason: WasteDeleted, By: 1},
+		},
+	}
+	efficiency, err := GetEfficiency(image)
+	if err != nil {
+		t.Fatalf("Error getting efficiency: %s", err)
+	}
+	if !reflect.DeepEqual(efficiency, expected) {
+		t.Errorf("\nExpected: %v\nGot: %v\n", expected, efficiency)
+	}
+}
@@ -0,0 +1,291 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/sirupsen/logrus"
+)
+
+// HexDump sets whether binary files are diffed by their hex dumps
+var HexDump bool
+
+// binarySniffSize is how much of a file is searched for a NUL byte to tell
+// whether it is binary, as git does
+const binarySniffSize = 8000
+
+// maxHexDumpSize bounds the size of the binary files diffed by hex dump, as
+// the diff is quadratic in the number of lines
+const maxHexDumpSize = 1 << 20
+
+// DiffFiles diffs the files named by filenames between two images. A name
+// may be the path of a file, which is always diffed, or the path of a
+// directory or a glob with the syntax of path filters, which select the
+// regular files that changed under them.
+func DiffFiles(image1, image2 *pkgutil.Image, filenames []string) ([]FileNameDiff, error) {
+	names, err := expandFilenames(image1, image2, filenames)
+	if err != nil {
+		return nil, err
+	}
+	diffs := []FileNameDiff{}
+	for _, name := range names {
+		diff, err := DiffFile(image1, image2, name)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, *diff)
+	}
+	return diffs, nil
+}
+
+// imageFiles lists the regular files of an image, walking it on first use
+type imageFiles struct {
+	image *pkgutil.Image
+	files []string
+}
+
+func (f *imageFiles) get() ([]string, error) {
+	if f.files != nil {
+		return f.files, nil
+	}
+	dir, err := pkgutil.GetDirectory(f.image.FSPath, true)
+	if err != nil {
+		return nil, err
+	}
+	f.files = []string{}
+	for _, name := range dir.Content {
+		if isRegularFile(filepath.Join(f.image.FSPath, name)) {
+			f.files = append(f.files, name)
+		}
+	}
+	return f.files, nil
+}
+
+// expandFilenames resolves filenames to the paths of the files to diff,
+// keeping the order of filenames
+func expandFilenames(image1, image2 *pkgutil.Image, filenames []string) ([]string, error) {
+	files1 := &imageFiles{image: image1}
+	files2 := &imageFiles{image: image2}
+	names := []string{}
+	seen := map[string]bool{}
+	for _, filename := range filenames {
+		var matches []string
+		var err error
+		if isGlob(filename) {
+			matches, err = globChangedFiles(image1, image2, files1, files2, filename)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				logrus.Warnf("No changed files match %s", filename)
+			}
+		} else {
+			filename = path.Clean("/" + filename)
+			if isDir(filepath.Join(image1.FSPath, filename)) || isDir(filepath.Join(image2.FSPath, filename)) {
+				matches, err = getChangedFilesUnder(image1, image2, files1, files2, filename)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				matches = []string{filename}
+			}
+		}
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				names = append(names, match)
+			}
+		}
+	}
+	return names, nil
+}
+
+// globChangedFiles returns the files matching pattern that differ between
+// the images
+func globChangedFiles(image1, image2 *pkgutil.Image, files1, files2 *imageFiles, pattern string) ([]string, error) {
+	filter, err := pkgutil.NewPathFilter([]string{pattern}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return getChangedFiles(image1, image2, files1, files2, func(name string) bool {
+		return filter.Matches(name, false)
+	})
+}
+
+// getChangedFilesUnder returns the files under the directory dir that differ
+// between the images
+func getChangedFilesUnder(image1, image2 *pkgutil.Image, files1, files2 *imageFiles, dir string) ([]string, error) {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	return getChangedFiles(image1, image2, files1, files2, func(name string) bool {
+		return strings.HasPrefix(name, prefix)
+	})
+}
+
+// getChangedFiles returns the sorted files selected by match that were
+// added, deleted or modified between the images
+func getChangedFiles(image1, image2 *pkgutil.Image, files1, files2 *imageFiles, match func(string) bool) ([]string, error) {
+	list1, err := files1.get()
+	if err != nil {
+		return nil, err
+	}
+	list2, err := files2.get()
+	if err != nil {
+		return nil, err
+	}
+	onlyA, onlyB, both := DiffPathSets(selectNames(list1, match), selectNames(list2, match))
+	changed := append(onlyA, onlyB...)
+	for _, name := range both {
+		same, err := pkgutil.CheckSameFile(filepath.Join(image1.FSPath, name), filepath.Join(image2.FSPath, name))
+		if err != nil {
+			return nil, fmt.Errorf("comparing %s: %s", name, err)
+		}
+		if !same {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+func selectNames(names []string, match func(string) bool) []string {
+	selected := []string{}
+	for _, name := range names {
+		if match(name) {
+			selected = append(selected, name)
+		}
+	}
+	return selected
+}
+
+func isGlob(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+func isDir(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.IsDir()
+}
+
+func isRegularFile(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// isBinaryFile reports whether the start of the file at path holds a NUL
+// byte. A missing file isn't binary.
+func isBinaryFile(path string, missing bool) (bool, error) {
+	if missing {
+		return false, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	buf := make([]byte, binarySniffSize)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	return bytes.IndexByte(buf[:n], 0) != -1, nil
+}
+
+// diffBinaryFiles compares filename between the images by size and digest,
+// diffing hex dumps if HexDump is set. A description is given if the file
+// is missing from one of the images.
+func diffBinaryFiles(image1, image2 *pkgutil.Image, filename, description string, missing1, missing2 bool) (*FileNameDiff, error) {
+	diff := &FileNameDiff{Filename: filename, Description: description, Binary: true}
+	var err error
+	if !missing1 {
+		if diff.Size1, diff.Digest1, err = getBinaryFileInfo(filepath.Join(image1.FSPath, filename)); err != nil {
+			return nil, err
+		}
+	}
+	if !missing2 {
+		if diff.Size2, diff.Digest2, err = getBinaryFileInfo(filepath.Join(image2.FSPath, filename)); err != nil {
+			return nil, err
+		}
+	}
+	if diff.Description == "" {
+		if diff.Digest1 == diff.Digest2 {
+			diff.Description = "Binary files are the same"
+			return diff, nil
+		}
+		diff.Description = "Binary files differ"
+	}
+	if !HexDump {
+		return diff, nil
+	}
+	if diff.Size1 > maxHexDumpSize || diff.Size2 > maxHexDumpSize {
+		logrus.Warnf("Not diffing hex dumps of %s, larger than %d bytes", filename, maxHexDumpSize)
+		return diff, nil
+	}
+	dump1, err := getHexDump(filepath.Join(image1.FSPath, filename), missing1)
+	if err != nil {
+		return nil, err
+	}
+	dump2, err := getHexDump(filepath.Join(image2.FSPath, filename), missing2)
+	if err != nil {
+		return nil, err
+	}
+	diff.Diff, err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        dump1,
+		B:        dump2,
+		FromFile: image1.Source,
+		ToFile:   image2.Source,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+func getBinaryFileInfo(path string) (int64, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, "", err
+	}
+	hash, err := pkgutil.GetFileHash(path)
+	if err != nil {
+		return 0, "", err
+	}
+	return info.Size(), "sha256:" + hash, nil
+}
+
+// getHexDump returns the lines of the hex dump of the file at path
+func getHexDump(path string, missing bool) ([]string, error) {
+	if missing {
+		return nil, nil
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return difflib.SplitLines(hex.Dump(contents)), nil
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+)
+
+func TestDiffFiles(t *testing.T) {
+	parent, err := ioutil.TempDir("", "file-diff-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(parent)
+
+	dir1 := extractTestLayer(t, parent, "dir1",
+		pkgutil.TestTarEntry{Name: "etc/hosts", Contents: "127.0.0.1 localhost\n"},
+		pkgutil.TestTarEntry{Name: "etc/os-release", Contents: "VERSION=1\n"},
+		pkgutil.TestTarEntry{Name: "etc/ssl/old.conf", Contents: "old\n"},
+		pkgutil.TestTarEntry{Name: "etc/ssl/openssl.conf", Contents: "a\n"},
+	)
+	dir2 := extractTestLayer(t, parent, "dir2",
+		pkgutil.TestTarEntry{Name: "etc/hosts", Contents: "127.0.0.1 localhost\n"},
+		pkgutil.TestTarEntry{Name: "etc/os-release", Contents: "VERSION=2\n"},
+		pkgutil.TestTarEntry{Name: "etc/ssl/new.conf", Contents: "new\n"},
+		pkgutil.TestTarEntry{Name: "etc/ssl/openssl.conf", Contents: "b\n"},
+	)
+	image1 := &pkgutil.Image{FSPath: dir1.Root, Source: "image1"}
+	image2 := &pkgutil.Image{FSPath: dir2.Root, Source: "image2"}
+
+	tests := []struct {
+		descrip   string
+		filenames []string
+		expected  []string
+	}{
+		{
+			descrip:   "file",
+			filenames: []string{"/etc/hosts"},
+			expected:  []string{"/etc/hosts"},
+		},
+		{
+			descrip:   "directory",
+			filenames: []string{"/etc"},
+			expected:  []string{"/etc/os-release", "/etc/ssl/new.conf", "/etc/ssl/old.conf", "/etc/ssl/openssl.conf"},
+		},
+		{
+			descrip:   "glob",
+			filenames: []string{"/etc/ssl/*.conf", "/etc/os-release"},
+			expected:  []string{"/etc/ssl/new.conf", "/etc/ssl/old.conf", "/etc/ssl/openssl.conf", "/etc/os-release"},
+		},
+		{
+			descrip:   "repeated",
+			filenames: []string{"/etc/ssl/openssl.conf", "/etc/ssl"},
+			expected:  []string{"/etc/ssl/openssl.conf", "/etc/ssl/new.conf", "/etc/ssl/old.conf"},
+		},
+	}
+	for _, test := range tests {
+		diffs, err := DiffFiles(image1, image2, test.filenames)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.descrip, err)
+			continue
+		}
+		var names []string
+		for _, diff := range diffs {
+			names = append(names, diff.Filename)
+		}
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("%s:\nExpected: %v\nGot: %v\n", test.descrip, test.expected, names)
+		}
+	}
+
+	added, err := DiffFile(image1, image2, "/etc/ssl/new.conf")
+	if err != nil {
+		t.Fatalf("Error diffing added file: %s", err)
+	}
+	expected := FileNameDiff{
+		Filename:    "/etc/ssl/new.conf",
+		Description: "/etc/ssl/new.conf only exists in image2",
+		Diff:        "--- image1\n+++ image2\n@@ -0,0 +1,2 @@\n+new\n+\n",
+	}
+	if !reflect.DeepEqual(*added, expected) {
+		t.Errorf("\nExpected: %v\nGot: %v\n", expected, *added)
+	}
+
+	if _, err := DiffFile(image1, image2, "/etc/missing"); err == nil {
+		t.Errorf("Expected an error diffing a file missing from both images")
+	}
+}
+
+func TestDiffBinaryFile(t *testing.T) {
+	parent, err := ioutil.TempDir("", "binary-diff-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(parent)
+
+	dir1 := extractTestLayer(t, parent, "dir1",
+		pkgutil.TestTarEntry{Name: "bin/app", Contents: "\x7fELF\x00\x01"},
+	)
+	dir2 := extractTestLayer(t, parent, "dir2",
+		pkgutil.TestTarEntry{Name: "bin/app", Contents: "\x7fELF\x00\x02"},
+	)
+	image1 := &pkgutil.Image{FSPath: dir1.Root, Source: "image1"}
+	image2 := &pkgutil.Image{FSPath: dir2.Root, Source: "image2"}
+
+	expected := FileNameDiff{
+		Filename:    "/bin/app",
+		Description: "Binary files differ",
+		Binary:      true,
+		Size1:       6,
+		Size2:       6,
+		Digest1:     "sha256:7ab58c495f91ca5dc2d23ab025598caa2dab044538c8a05bd3ec27e4d41b95e6",
+		Digest2:     "sha256:9ac5a273a5dc04c4b544e638ae7dbb8f9ba07cc4a6edda056cb9628ac9994d82",
+	}
+	diff, err := DiffFile(image1, image2, "/bin/app")
+	if err != nil {
+		t.Fatalf("Error diffing binary file: %s", err)
+	}
+	if !reflect.DeepEqual(*diff, expected) {
+		t.Errorf("\nExpected: %v\nGot: %v\n", expected, *diff)
+	}
+
+	defer func() { HexDump = false }()
+	HexDump = true
+	diff, err = DiffFile(image1, image2, "/bin/app")
+	if err != nil {
+		t.Fatalf("Error diffing binary file: %s", err)
+	}
+	expected.Diff = "--- image1\n+++ image2\n@@ -1 +1 @@\n" +
+		"-00000000  7f 45 4c 46 00 01                                 |.ELF..|\n" +
+		"+00000000  7f 45 4c 46 00 02                                 |.ELF..|\n"
+	if !reflect.DeepEqual(*diff, expected) {
+		t.Errorf("\nExpected: %v\nGot: %v\n", expected, *diff)
+	}
+}
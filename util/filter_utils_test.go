@@ -0,0 +1,145 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+)
+
+func TestPathFilterMatches(t *testing.T) {
+	tests := []struct {
+		description string
+		include     []string
+		exclude     []string
+		name        string
+		isDir       bool
+		expected    bool
+	}{
+		{description: "no patterns", name: "/var/cache/yum", expected: true},
+		{description: "anchored directory", exclude: []string{"/var/cache"}, name: "/var/cache", isDir: true, expected: false},
+		{description: "inside anchored directory", exclude: []string{"/var/cache"}, name: "/var/cache/yum/x86_64", expected: false},
+		{description: "sibling of anchored directory", exclude: []string{"/var/cache"}, name: "/var/cachet", expected: true},
+		{description: "name at any depth", exclude: []string{"__pycache__"}, name: "/usr/lib/python3/__pycache__/os.pyc", expected: false},
+		{description: "directory only pattern on file", exclude: []string{"tmp/"}, name: "/etc/tmp", expected: true},
+		{description: "directory only pattern on directory", exclude: []string{"tmp/"}, name: "/tmp", isDir: true, expected: false},
+		{description: "star doesn't cross directories", exclude: []string{"/usr/*.so"}, name: "/usr/lib/x.so", expected: true},
+		{description: "double star crosses directories", exclude: []string{"/usr/**/*.so"}, name: "/usr/lib/x86_64/x.so", expected: false},
+		{description: "negation", exclude: []string{"*.log", "!keep.log"}, name: "/var/log/keep.log", expected: true},
+		{description: "negation inside excluded directory", exclude: []string{"/var/log", "!keep.log"}, name: "/var/log/keep.log", expected: false},
+		{description: "included", include: []string{"/usr/lib"}, name: "/usr/lib/x.so", expected: true},
+		{description: "not included", include: []string{"/usr/lib"}, name: "/usr/bin/sh", expected: false},
+		{description: "parent of included", include: []string{"/usr/lib"}, name: "/usr", isDir: true, expected: false},
+		{description: "included and excluded", include: []string{"/usr"}, exclude: []string{"*.pyc"}, name: "/usr/lib/x.pyc", expected: false},
+	}
+	for _, test := range tests {
+		filter, err := pkgutil.NewPathFilter(test.include, test.exclude)
+		if err != nil {
+			t.Fatalf("%s: error creating filter: %s", test.description, err)
+		}
+		if actual := filter.Matches(test.name, test.isDir); actual != test.expected {
+			t.Errorf("%s: expected Matches(%s) to be %v, got %v", test.description, test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestPathFilterIgnoreFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ignore-file-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	ignoreFile := filepath.Join(dir, pkgutil.IgnoreFile)
+	contents := "# caches\n/var/cache\n\n/tmp/\n__pycache__\n"
+	if err := ioutil.WriteFile(ignoreFile, []byte(contents), 0644); err != nil {
+		t.Fatalf("Error writing ignore file: %s", err)
+	}
+
+	filter, err := pkgutil.NewPathFilter(nil, nil)
+	if err != nil {
+		t.Fatalf("Error creating filter: %s", err)
+	}
+	if key := filter.Key(); key != "" {
+		t.Errorf("Expected an empty filter to have no key, got %s", key)
+	}
+	if err := filter.AddIgnoreFile(ignoreFile); err != nil {
+		t.Fatalf("Error reading ignore file: %s", err)
+	}
+	if filter.Key() == "" {
+		t.Errorf("Expected a filter with patterns to have a key")
+	}
+	for name, expected := range map[string]bool{
+		"/var/cache/yum":            false,
+		"/var/lib/rpm":              true,
+		"/tmp/x":                    false,
+		"/app/__pycache__/main.pyc": false,
+		"/app/main.py":              true,
+		"/caches":                   true,
+	} {
+		if actual := filter.Matches(name, false); actual != expected {
+			t.Errorf("Expected Matches(%s) to be %v, got %v", name, expected, actual)
+		}
+	}
+}
+
+func TestGetFileSystemForLayerFiltered(t *testing.T) {
+	layer, err := pkgutil.TestLayer(
+		pkgutil.TestTarEntry{Name: "app/", Typeflag: tar.TypeDir},
+		pkgutil.TestTarEntry{Name: "app/main.py", Contents: "main"},
+		pkgutil.TestTarEntry{Name: "app/__pycache__/", Typeflag: tar.TypeDir},
+		pkgutil.TestTarEntry{Name: "app/__pycache__/main.pyc", Contents: "pyc"},
+		pkgutil.TestTarEntry{Name: "var/cache/yum/", Typeflag: tar.TypeDir},
+		pkgutil.TestTarEntry{Name: "var/cache/yum/pkg.rpm", Contents: "rpm"},
+		pkgutil.TestTarEntry{Name: "var/lib/", Typeflag: tar.TypeDir},
+		pkgutil.TestTarEntry{Name: "var/lib/app", Typeflag: tar.TypeSymlink, Linkname: "/app"},
+	)
+	if err != nil {
+		t.Fatalf("Error building layer: %s", err)
+	}
+	parent, err := ioutil.TempDir("", "filter-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(parent)
+	root := filepath.Join(parent, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatalf("Error creating %s: %s", root, err)
+	}
+
+	pkgutil.Filter, err = pkgutil.NewPathFilter(nil, []string{"/var/cache", "__pycache__"})
+	if err != nil {
+		t.Fatalf("Error creating filter: %s", err)
+	}
+	defer func() { pkgutil.Filter = nil }()
+	if err := pkgutil.GetFileSystemForLayer(layer, root, nil); err != nil {
+		t.Fatalf("Error extracting layer: %s", err)
+	}
+	dir, err := pkgutil.GetDirectory(root, true)
+	if err != nil {
+		t.Fatalf("Error reading extracted layer: %s", err)
+	}
+	expected := []string{"/app", "/app/main.py", "/var", "/var/lib", "/var/lib/app"}
+	if !reflect.DeepEqual(dir.Content, expected) {
+		t.Errorf("\nExpected: %v\nGot: %v\n", expected, dir.Content)
+	}
+}
@@ -33,16 +33,23 @@ var templates = map[string]string{
 	"MultiVersionPackageDiff":          MultiVersionDiffOutput,
 	"HistDiff":                         HistoryDiffOutput,
 	"MetadataDiff":                     MetadataDiffOutput,
+	"IndexDiff":                        IndexDiffOutput,
 	"DirDiff":                          FSDiffOutput,
 	"MultipleDirDiff":                  FSLayerDiffOutput,
 	"FilenameDiff":                     FilenameDiffOutput,
 	"ListAnalyze":                      ListAnalysisOutput,
+	"IndexAnalyze":                     IndexAnalysisOutput,
 	"FileAnalyze":                      FileAnalysisOutput,
+	"FileProvenanceAnalyze":            FileProvenanceAnalysisOutput,
 	"FileLayerAnalyze":                 FileLayerAnalysisOutput,
 	"SizeAnalyze":                      SizeAnalysisOutput,
 	"SizeLayerAnalyze":                 SizeLayerAnalysisOutput,
 	"SizeDiff":                         SizeDiffOutput,
 	"SizeLayerDiff":                    SizeLayerDiffOutput,
+	"EfficiencyAnalyze":                EfficiencyAnalysisOutput,
+	"EfficiencyDiff":                   EfficiencyDiffOutput,
+	"DuplicatesAnalyze":                DuplicatesAnalysisOutput,
+	"DuplicatesDiff":                   DuplicatesDiffOutput,
 	"MultiVersionPackageAnalyze":       MultiVersionPackageOutput,
 	"SingleVersionPackageAnalyze":      SingleVersionPackageOutput,
 	"SingleVersionPackageLayerAnalyze": SingleVersionPackageLayerOutput,
@@ -17,6 +17,11 @@ limitations under the License.
 package util
 
 import (
+	"archive/tar"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -64,6 +69,62 @@ func TestGetDeletedEntries(t *testing.T) {
 	}
 }
 
+func TestDiffPathSets(t *testing.T) {
+	a := []string{"/a", "/a/b", "/a.txt", "/c", "/d"}
+	b := []string{"/a", "/a/b", "/a/c", "/a.txt", "/d", "/e"}
+	onlyA, onlyB, both := DiffPathSets(a, b)
+	if expected := []string{"/c"}; !reflect.DeepEqual(onlyA, expected) {
+		t.Errorf("\nExpected: %s\nGot: %s\n", expected, onlyA)
+	}
+	if expected := []string{"/a/c", "/e"}; !reflect.DeepEqual(onlyB, expected) {
+		t.Errorf("\nExpected: %s\nGot: %s\n", expected, onlyB)
+	}
+	if expected := []string{"/a", "/a/b", "/a.txt", "/d"}; !reflect.DeepEqual(both, expected) {
+		t.Errorf("\nExpected: %s\nGot: %s\n", expected, both)
+	}
+}
+
+// syntheticTree lists the paths of a node_modules heavy image with about
+// entries paths, in the order a walk would produce them. Every removeEvery-th
+// file is left out, and packages from version on get an extra file.
+func syntheticTree(entries, removeEvery, version int) []string {
+	paths := []string{"/app", "/app/node_modules"}
+	for pkg := 0; len(paths) < entries; pkg++ {
+		pkgDir := fmt.Sprintf("/app/node_modules/pkg%d", pkg)
+		paths = append(paths, pkgDir, pkgDir+"/lib")
+		for file := 0; file < 20; file++ {
+			if removeEvery > 0 && (pkg*20+file)%removeEvery == 0 {
+				continue
+			}
+			paths = append(paths, fmt.Sprintf("%s/lib/file%d.js", pkgDir, file))
+		}
+		if version > 0 && pkg >= version {
+			paths = append(paths, pkgDir+"/lib/new.js")
+		}
+		paths = append(paths, pkgDir+"/package.json")
+	}
+	return paths
+}
+
+func BenchmarkDiffPathSets(b *testing.B) {
+	tree1 := syntheticTree(500000, 0, 0)
+	tree2 := syntheticTree(500000, 100, 10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		DiffPathSets(tree1, tree2)
+	}
+}
+
+func BenchmarkDiffDirectoryEntries(b *testing.B) {
+	d1 := pkgutil.Directory{Root: "Dir1", Content: syntheticTree(500000, 0, 0)}
+	d2 := pkgutil.Directory{Root: "Dir2", Content: syntheticTree(500000, 100, 10000)}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		GetAddedEntries(d1, d2)
+		GetDeletedEntries(d1, d2)
+	}
+}
+
 func TestGetModifiedEntries(t *testing.T) {
 	var testdir1 = pkgutil.Directory{Root: "test_files/dir1/", Content: []string{"file1", "file2", "file3"}}
 	var testdir2 = pkgutil.Directory{Root: "test_files/dir2/", Content: []string{"file1", "file2", "file4"}}
@@ -85,6 +146,147 @@ func TestGetModifiedEntries(t *testing.T) {
 	}
 }
 
+func extractTestLayer(t *testing.T, parent, name string, entries ...pkgutil.TestTarEntry) pkgutil.Directory {
+	layer, err := pkgutil.TestLayer(entries...)
+	if err != nil {
+		t.Fatalf("Error building layer: %s", err)
+	}
+	root := filepath.Join(parent, name)
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatalf("Error creating %s: %s", root, err)
+	}
+	if err := pkgutil.GetFileSystemForLayer(layer, root, nil); err != nil {
+		t.Fatalf("Error extracting layer: %s", err)
+	}
+	dir, err := pkgutil.GetDirectory(root, true)
+	if err != nil {
+		t.Fatalf("Error reading extracted layer: %s", err)
+	}
+	return dir
+}
+
+func TestDiffDirectoryChanges(t *testing.T) {
+	parent, err := ioutil.TempDir("", "metadata-diff-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(parent)
+
+	dir1 := extractTestLayer(t, parent, "dir1",
+		pkgutil.TestTarEntry{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0755},
+		pkgutil.TestTarEntry{Name: "usr/bin/sudo", Contents: "sudo", Mode: 0755},
+		pkgutil.TestTarEntry{Name: "usr/bin/ping", Contents: "ping", Mode: 0755},
+		pkgutil.TestTarEntry{Name: "usr/bin/sh", Typeflag: tar.TypeSymlink, Linkname: "/bin/bash"},
+		pkgutil.TestTarEntry{Name: "etc/config", Contents: "old", Mode: 0644},
+		pkgutil.TestTarEntry{Name: "etc/owned", Contents: "same", Mode: 0644, Uid: 0, Gid: 0},
+		pkgutil.TestTarEntry{Name: "etc/same", Contents: "same", Mode: 0644},
+		pkgutil.TestTarEntry{Name: "opt/app", Contents: "app", Mode: 0755},
+		pkgutil.TestTarEntry{Name: "dev/tty", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 5, Devminor: 0},
+	)
+	dir2 := extractTestLayer(t, parent, "dir2",
+		pkgutil.TestTarEntry{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0755},
+		pkgutil.TestTarEntry{Name: "usr/bin/sudo", Contents: "sudo", Mode: 04755},
+		pkgutil.TestTarEntry{Name: "usr/bin/ping", Contents: "ping", Mode: 0755, Xattrs: map[string]string{"security.capability": "\x01"}},
+		pkgutil.TestTarEntry{Name: "usr/bin/sh", Typeflag: tar.TypeSymlink, Linkname: "/bin/dash"},
+		pkgutil.TestTarEntry{Name: "etc/config", Contents: "newer", Mode: 0600},
+		pkgutil.TestTarEntry{Name: "etc/owned", Contents: "same", Mode: 0644, Uid: 1000, Gid: 100},
+		pkgutil.TestTarEntry{Name: "etc/same", Contents: "same", Mode: 0644},
+		pkgutil.TestTarEntry{Name: "opt/app/", Typeflag: tar.TypeDir, Mode: 0755},
+		pkgutil.TestTarEntry{Name: "dev/tty", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 5, Devminor: 1},
+	)
+
+	diff, same := DiffDirectory(dir1, dir2)
+	if same {
+		t.Fatalf("Expected directories to differ")
+	}
+	expected := map[string][]EntryChange{
+		"/dev/tty":      {{Kind: ChangeDevice, Before: "5,0", After: "5,1"}},
+		"/etc/config":   {{Kind: ChangeContent}, {Kind: ChangeMode, Before: "0644", After: "0600"}},
+		"/etc/owned":    {{Kind: ChangeOwner, Before: "0:0", After: "1000:100"}},
+		"/opt/app":      {{Kind: ChangeType, Before: pkgutil.TypeFile, After: pkgutil.TypeDir}},
+		"/usr/bin/ping": {{Kind: ChangeXattrs, Before: "", After: `security.capability="\x01"`}},
+		"/usr/bin/sh":   {{Kind: ChangeSymlink, Before: "/bin/bash", After: "/bin/dash"}},
+		"/usr/bin/sudo": {{Kind: ChangeMode, Before: "0755", After: "4755"}},
+	}
+	actual := map[string][]EntryChange{}
+	for _, mod := range diff.Mods {
+		actual[mod.Name] = mod.Changes
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("\nExpected: %v\nGot: %v\n", expected, actual)
+	}
+}
+
+func TestDiffDirectoryMoves(t *testing.T) {
+	parent, err := ioutil.TempDir("", "move-diff-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(parent)
+
+	config := "a = 1\nb = 2\nc = 3\nd = 4\n"
+	dir1 := extractTestLayer(t, parent, "dir1",
+		pkgutil.TestTarEntry{Name: "app/lib/x.so", Contents: "\x7fELF x"},
+		pkgutil.TestTarEntry{Name: "app/lib/y.so", Contents: "\x7fELF y"},
+		pkgutil.TestTarEntry{Name: "app/empty"},
+		pkgutil.TestTarEntry{Name: "app/app.conf", Contents: config},
+		pkgutil.TestTarEntry{Name: "app/gone", Contents: "gone"},
+	)
+	dir2 := extractTestLayer(t, parent, "dir2",
+		pkgutil.TestTarEntry{Name: "usr/lib/x.so", Contents: "\x7fELF x"},
+		pkgutil.TestTarEntry{Name: "usr/lib/renamed.so", Contents: "\x7fELF y"},
+		pkgutil.TestTarEntry{Name: "usr/empty"},
+		pkgutil.TestTarEntry{Name: "etc/app.conf", Contents: config + "e = 5\n"},
+	)
+
+	names := func(entries []pkgutil.DirectoryEntry) (names []string) {
+		for _, entry := range entries {
+			names = append(names, entry.Name)
+		}
+		return names
+	}
+	tests := []struct {
+		similarity int
+		moves      []EntryMove
+		adds       []string
+		dels       []string
+	}{
+		{
+			similarity: 0,
+			moves: []EntryMove{
+				{From: "/app/lib/x.so", To: "/usr/lib/x.so", Size: 6, Similarity: 100},
+				{From: "/app/lib/y.so", To: "/usr/lib/renamed.so", Size: 6, Similarity: 100},
+			},
+			adds: []string{"/etc", "/etc/app.conf", "/usr", "/usr/empty", "/usr/lib"},
+			dels: []string{"/app", "/app/app.conf", "/app/empty", "/app/gone", "/app/lib"},
+		},
+		{
+			similarity: 50,
+			moves: []EntryMove{
+				{From: "/app/app.conf", To: "/etc/app.conf", Size: 30, Similarity: 90},
+				{From: "/app/lib/x.so", To: "/usr/lib/x.so", Size: 6, Similarity: 100},
+				{From: "/app/lib/y.so", To: "/usr/lib/renamed.so", Size: 6, Similarity: 100},
+			},
+			adds: []string{"/etc", "/usr", "/usr/empty", "/usr/lib"},
+			dels: []string{"/app", "/app/empty", "/app/gone", "/app/lib"},
+		},
+	}
+	defer func() { MoveSimilarity = 0 }()
+	for _, test := range tests {
+		MoveSimilarity = test.similarity
+		diff, _ := DiffDirectory(dir1, dir2)
+		if !reflect.DeepEqual(diff.Moves, test.moves) {
+			t.Errorf("Similarity %d:\nExpected moves: %v\nGot: %v\n", test.similarity, test.moves, diff.Moves)
+		}
+		if !reflect.DeepEqual(names(diff.Adds), test.adds) {
+			t.Errorf("Similarity %d:\nExpected adds: %v\nGot: %v\n", test.similarity, test.adds, names(diff.Adds))
+		}
+		if !reflect.DeepEqual(names(diff.Dels), test.dels) {
+			t.Errorf("Similarity %d:\nExpected dels: %v\nGot: %v\n", test.similarity, test.dels, names(diff.Dels))
+		}
+	}
+}
+
 func TestGetDirectory(t *testing.T) {
 	tests := []struct {
 		descrip  string
@@ -98,6 +300,8 @@ func TestGetDirectory(t *testing.T) {
 			expected: pkgutil.Directory{
 				Root:    "testTars/la-croix3-full",
 				Content: []string{"/lime.txt", "/nest", "/nest/f1.txt", "/nested-dir", "/nested-dir/f2.txt", "/passionfruit.txt", "/peach-pear.txt"},
+				Sizes: pkgutil.SizeIndex{"/": 0, "/lime.txt": 0, "/nest": 0, "/nest/f1.txt": 0, "/nested-dir": 0,
+					"/nested-dir/f2.txt": 0, "/passionfruit.txt": 0, "/peach-pear.txt": 0},
 			},
 			deep: true,
 		},
@@ -121,7 +325,7 @@ func TestGetDirectory(t *testing.T) {
 		expectedDir := testCase.expected
 
 		if !reflect.DeepEqual(actualDir, expectedDir) {
-			t.Errorf("%s test was incorrect\nExpected: %s\nGot: %s", testCase.descrip, expectedDir, actualDir)
+			t.Errorf("%s test was incorrect\nExpected: %v\nGot: %v", testCase.descrip, expectedDir, actualDir)
 		}
 	}
 }
@@ -157,6 +361,87 @@ func TestCheckSameFile(t *testing.T) {
 	}
 }
 
+func TestGetDirectoryWithHashes(t *testing.T) {
+	file1Hash := "f02d5a72cd2d57fa802840a76b44c6c6920a8b8e6b90b20e26c03876275069e0"
+	dir, err := pkgutil.GetDirectoryWithHashes("test_files/dir1")
+	if err != nil {
+		t.Fatalf("Error walking directory: %s", err)
+	}
+	expected := map[string]string{"/file1": file1Hash, "/file2": file1Hash, "/file3": file1Hash}
+	if !reflect.DeepEqual(dir.Hashes, expected) {
+		t.Errorf("\nExpected: %v\nGot: %v\n", expected, dir.Hashes)
+	}
+	for _, entry := range pkgutil.GetDirectoryEntries(dir) {
+		if entry.Sha256 != file1Hash {
+			t.Errorf("Expected %s to have hash %s, got %s", entry.Name, file1Hash, entry.Sha256)
+		}
+	}
+
+	hash, err := pkgutil.GetFileHash("test_files/file2")
+	if err != nil {
+		t.Fatalf("Error hashing file: %s", err)
+	}
+	if hash != "0337a01222bc13a1bd751c6129843ad389233068f16d400c49f926fa326b884c" {
+		t.Errorf("Got unexpected hash %s for test_files/file2", hash)
+	}
+}
+
+func TestGetSizeIndex(t *testing.T) {
+	root, err := ioutil.TempDir("", "size-index-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(root)
+	for name, contents := range map[string]string{
+		"a/one":   "1",
+		"a/b/two": "22",
+		"c/three": "333",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Error creating %s: %s", filepath.Dir(path), err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("Error writing %s: %s", path, err)
+		}
+	}
+	// a hard linked file only counts once towards the totals
+	if err := os.Link(filepath.Join(root, "c/three"), filepath.Join(root, "a/b/three")); err != nil {
+		t.Fatalf("Error creating hard link: %s", err)
+	}
+
+	sizes, err := pkgutil.GetSizeIndex(root)
+	if err != nil {
+		t.Fatalf("Error building size index: %s", err)
+	}
+	expected := pkgutil.SizeIndex{
+		"/":          6,
+		"/a":         6,
+		"/a/one":     1,
+		"/a/b":       5,
+		"/a/b/two":   2,
+		"/a/b/three": 3,
+		"/c":         0,
+		"/c/three":   3,
+	}
+	if !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("\nExpected: %v\nGot: %v\n", expected, sizes)
+	}
+	if size := pkgutil.GetSize(root); size != 6 {
+		t.Errorf("Expected size 6 for %s, got %d", root, size)
+	}
+
+	pkgutil.SizeOnDisk = true
+	defer func() { pkgutil.SizeOnDisk = false }()
+	sizes, err = pkgutil.GetSizeIndex(root)
+	if err != nil {
+		t.Fatalf("Error building size index: %s", err)
+	}
+	if sizes["/a/one"] == 1 || sizes["/a/one"]%512 != 0 {
+		t.Errorf("Expected the disk usage of /a/one to be a number of blocks, got %d", sizes["/a/one"])
+	}
+}
+
 func TestHasFilepathPrefix(t *testing.T) {
 	type test struct {
 		prefix       string
@@ -17,9 +17,16 @@ limitations under the License.
 package util
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
 
 	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
 )
 
 func TestImageTags(t *testing.T) {
@@ -51,3 +58,194 @@ func TestImageTags(t *testing.T) {
 		}
 	}
 }
+
+func TestParseOCIReference(t *testing.T) {
+	tests := []struct {
+		ref    string
+		path   string
+		tag    string
+		digest string
+	}{
+		{
+			ref:  "oci://build/layout",
+			path: "build/layout",
+		},
+		{
+			ref:  "oci://build/layout:v1",
+			path: "build/layout",
+			tag:  "v1",
+		},
+		{
+			ref:    "oci://build/layout@sha256:abc",
+			path:   "build/layout",
+			digest: "sha256:abc",
+		},
+		{
+			ref:  "oci:///abs/path.d/layout:latest",
+			path: "/abs/path.d/layout",
+			tag:  "latest",
+		},
+	}
+
+	for _, test := range tests {
+		path, tag, digest := pkgutil.ParseOCIReference(test.ref)
+		if path != test.path || tag != test.tag || digest != test.digest {
+			t.Errorf("Error parsing %s: got (%s, %s, %s), expected (%s, %s, %s)",
+				test.ref, path, tag, digest, test.path, test.tag, test.digest)
+		}
+	}
+}
+
+func TestGetOCILayoutImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-layout")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, err := layout.Write(dir, empty.Index)
+	if err != nil {
+		t.Fatalf("Error writing OCI layout: %s", err)
+	}
+	var digests []v1.Hash
+	for _, tag := range []string{"v1", "v2"} {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatalf("Error creating random image: %s", err)
+		}
+		annotations := map[string]string{"org.opencontainers.image.ref.name": tag}
+		if err := p.AppendImage(img, layout.WithAnnotations(annotations)); err != nil {
+			t.Fatalf("Error appending image to layout: %s", err)
+		}
+		digest, err := img.Digest()
+		if err != nil {
+			t.Fatalf("Error getting image digest: %s", err)
+		}
+		digests = append(digests, digest)
+	}
+
+	tests := []struct {
+		ref         string
+		expected    v1.Hash
+		shouldError bool
+	}{
+		{ref: "oci://" + dir + ":v1", expected: digests[0]},
+		{ref: "oci://" + dir + ":v2", expected: digests[1]},
+		{ref: "oci://" + dir + "@" + digests[1].String(), expected: digests[1]},
+		{ref: "oci://" + dir + ":v3", shouldError: true},
+		{ref: "oci://" + dir, shouldError: true},
+	}
+
+	for _, test := range tests {
+		image, err := pkgutil.GetImage(test.ref, pkgutil.RequireRootFS, "", nil)
+		pkgutil.CleanupImage(image)
+		if test.shouldError {
+			if err == nil {
+				t.Errorf("Expected error retrieving %s but got none", test.ref)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error retrieving %s: %s", test.ref, err)
+			continue
+		}
+		if image.Digest != test.expected {
+			t.Errorf("Wrong image selected for %s: expected %s, got %s", test.ref, test.expected, image.Digest)
+		}
+	}
+}
+
+// platformImage returns a random image whose config records platform
+func platformImage(t *testing.T, platform v1.Platform) v1.Image {
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatalf("Error creating random image: %s", err)
+	}
+	cf, err := img.ConfigFile()
+	if err != nil {
+		t.Fatalf("Error getting image config: %s", err)
+	}
+	cf = cf.DeepCopy()
+	cf.OS, cf.Architecture, cf.Variant = platform.OS, platform.Architecture, platform.Variant
+	img, err = mutate.ConfigFile(img, cf)
+	if err != nil {
+		t.Fatalf("Error setting image config: %s", err)
+	}
+	return img
+}
+
+func TestGetOCILayoutImageForPlatform(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-layout")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// configs without the variant the index or the caller gives
+	arm64 := v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	amd64 := v1.Platform{OS: "linux", Architecture: "amd64"}
+	armImage := platformImage(t, v1.Platform{OS: "linux", Architecture: "arm64"})
+	amdImage := platformImage(t, amd64)
+	index := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: amdImage, Descriptor: v1.Descriptor{Platform: &amd64}},
+		mutate.IndexAddendum{Add: armImage, Descriptor: v1.Descriptor{Platform: &arm64}},
+	)
+	p, err := layout.Write(dir, empty.Index)
+	if err != nil {
+		t.Fatalf("Error writing OCI layout: %s", err)
+	}
+	if err := p.AppendIndex(index, layout.WithAnnotations(map[string]string{"org.opencontainers.image.ref.name": "multi"})); err != nil {
+		t.Fatalf("Error appending index to layout: %s", err)
+	}
+	if err := p.AppendImage(armImage, layout.WithAnnotations(map[string]string{"org.opencontainers.image.ref.name": "single"})); err != nil {
+		t.Fatalf("Error appending image to layout: %s", err)
+	}
+	armDigest, err := armImage.Digest()
+	if err != nil {
+		t.Fatalf("Error getting image digest: %s", err)
+	}
+
+	platforms, err := pkgutil.GetImagePlatforms("oci://" + dir + ":multi")
+	if err != nil {
+		t.Fatalf("Error getting platforms: %s", err)
+	}
+	if len(platforms) != 2 || !platforms[1].Equals(arm64) {
+		t.Fatalf("Expected platforms %s and %s, got %v", amd64.String(), arm64.String(), platforms)
+	}
+
+	tests := []struct {
+		ref         string
+		platform    v1.Platform
+		expected    string
+		shouldError bool
+	}{
+		// the platform of the descriptor, as diff-platforms passes it
+		{ref: "oci://" + dir + ":multi", platform: platforms[1], expected: "linux/arm64/v8"},
+		{ref: "oci://" + dir + ":multi", platform: v1.Platform{OS: "linux", Architecture: "arm64"}, expected: "linux/arm64/v8"},
+		// without an index, the config is checked only for what it records
+		{ref: "oci://" + dir + ":single", platform: arm64, expected: "linux/arm64"},
+		{ref: "oci://" + dir + ":single", platform: v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8", OSVersion: "1"}, expected: "linux/arm64"},
+		{ref: "oci://" + dir + ":single", platform: amd64, shouldError: true},
+		{ref: "oci://" + dir + ":single", platform: v1.Platform{OS: "windows", Architecture: "arm64"}, shouldError: true},
+	}
+	for _, test := range tests {
+		platform := test.platform
+		image, err := pkgutil.GetImage(test.ref, pkgutil.RequireConfig, "", &platform)
+		if test.shouldError {
+			if err == nil {
+				t.Errorf("Expected error retrieving %s for %s but got none", test.ref, platform.String())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error retrieving %s for %s: %s", test.ref, platform.String(), err)
+			continue
+		}
+		if image.Digest != armDigest {
+			t.Errorf("Wrong image selected for %s: expected %s, got %s", test.ref, armDigest, image.Digest)
+		}
+		if image.Platform.String() != test.expected {
+			t.Errorf("Wrong platform for %s: expected %s, got %s", test.ref, test.expected, image.Platform.String())
+		}
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import "sort"
+
+// IndexEntry describes one manifest of a manifest list or OCI index
+type IndexEntry struct {
+	Platform    string
+	Digest      string
+	MediaType   string
+	Size        int64
+	Annotations map[string]string `json:",omitempty"`
+}
+
+// IndexDiff holds the differences between two manifest lists or OCI indexes:
+// Adds and Dels are platforms found only in the second and first index,
+// Mods are platforms whose manifest digest or annotations changed, and
+// Annotations are changes to the annotations of the indexes themselves.
+type IndexDiff struct {
+	Adds        []IndexEntry
+	Dels        []IndexEntry
+	Mods        []IndexEntryDiff
+	Annotations []AnnotationDiff
+}
+
+type IndexEntryDiff struct {
+	Platform    string
+	Digest1     string
+	Digest2     string
+	Annotations []AnnotationDiff `json:",omitempty"`
+}
+
+// AnnotationDiff records a changed annotation; a missing value is empty
+type AnnotationDiff struct {
+	Key    string
+	Value1 string
+	Value2 string
+}
+
+// GetAnnotationDiff returns the annotations that differ between a1 and a2, sorted by key
+func GetAnnotationDiff(a1, a2 map[string]string) []AnnotationDiff {
+	keys := map[string]bool{}
+	for k := range a1 {
+		keys[k] = true
+	}
+	for k := range a2 {
+		keys[k] = true
+	}
+	sortedKeys := []string{}
+	for k := range keys {
+		sortedKeys = append(sortedKeys, k)
+	}
+	sort.Strings(sortedKeys)
+
+	diffs := []AnnotationDiff{}
+	for _, k := range sortedKeys {
+		v1, ok1 := a1[k]
+		v2, ok2 := a2[k]
+		if ok1 != ok2 || v1 != v2 {
+			diffs = append(diffs, AnnotationDiff{Key: k, Value1: v1, Value2: v2})
+		}
+	}
+	return diffs
+}
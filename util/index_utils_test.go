@@ -0,0 +1,66 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetAnnotationDiff(t *testing.T) {
+	testCases := []struct {
+		descrip  string
+		a1       map[string]string
+		a2       map[string]string
+		expected []AnnotationDiff
+	}{
+		{
+			descrip:  "no annotations",
+			expected: []AnnotationDiff{},
+		},
+		{
+			descrip:  "same annotations",
+			a1:       map[string]string{"a": "1", "b": "2"},
+			a2:       map[string]string{"b": "2", "a": "1"},
+			expected: []AnnotationDiff{},
+		},
+		{
+			descrip: "added, removed and changed annotations sorted by key",
+			a1:      map[string]string{"same": "x", "removed": "old", "changed": "1"},
+			a2:      map[string]string{"same": "x", "changed": "2", "added": "new"},
+			expected: []AnnotationDiff{
+				{Key: "added", Value1: "", Value2: "new"},
+				{Key: "changed", Value1: "1", Value2: "2"},
+				{Key: "removed", Value1: "old", Value2: ""},
+			},
+		},
+		{
+			descrip: "empty value is still a change from no value",
+			a1:      map[string]string{},
+			a2:      map[string]string{"empty": ""},
+			expected: []AnnotationDiff{
+				{Key: "empty", Value1: "", Value2: ""},
+			},
+		},
+	}
+	for _, test := range testCases {
+		diff := GetAnnotationDiff(test.a1, test.a2)
+		if !reflect.DeepEqual(diff, test.expected) {
+			t.Errorf("%s\nExpected: %v\nGot: %v", test.descrip, test.expected, diff)
+		}
+	}
+}
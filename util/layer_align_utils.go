@@ -0,0 +1,143 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"strconv"
+
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+)
+
+// Statuses of a LayerMatch
+const (
+	// LayerUnchanged is a layer with the same digest in both images
+	LayerUnchanged = "unchanged"
+	// LayerChanged is a layer created by the same history line in both
+	// images, with different contents
+	LayerChanged = "changed"
+	// LayerRemoved is a layer of the first image with no match in the second
+	LayerRemoved = "removed"
+	// LayerInserted is a layer of the second image with no match in the first
+	LayerInserted = "inserted"
+)
+
+// LayerMatch pairs a layer of the first image of a diff with a layer of the
+// second. Index1 or Index2 is -1 for a layer found in only one of them.
+type LayerMatch struct {
+	Index1     int
+	Index2     int
+	Digest1    string `json:",omitempty"`
+	Digest2    string `json:",omitempty"`
+	CreatedBy1 string `json:",omitempty"`
+	CreatedBy2 string `json:",omitempty"`
+	Status     string
+}
+
+// Label names the layers paired by m by their indexes, e.g. "3" for the
+// fourth layer of both images, "3 -> 4" for a layer that moved up, and
+// "3 -> -" for a removed layer
+func (m LayerMatch) Label() string {
+	if m.Index1 == m.Index2 {
+		return strconv.Itoa(m.Index1)
+	}
+	index := func(i int) string {
+		if i == -1 {
+			return "-"
+		}
+		return strconv.Itoa(i)
+	}
+	return fmt.Sprintf("%s -> %s", index(m.Index1), index(m.Index2))
+}
+
+// AlignLayers aligns the layer stacks of two images with a longest common
+// subsequence, layers matching if they have the same digest or, failing
+// that, the same created_by history line. Digest matches weigh more, so
+// that an unchanged layer isn't paired with a rebuilt one. Layers left
+// unmatched are reported as removed or inserted, in stack order.
+func AlignLayers(image1, image2 pkgutil.Image) []LayerMatch {
+	layers1, layers2 := image1.Layers, image2.Layers
+	history1, history2 := pkgutil.GetLayerHistory(image1), pkgutil.GetLayerHistory(image2)
+	score := func(i, j int) int {
+		if layers1[i].Digest.Hex != "" && layers1[i].Digest == layers2[j].Digest {
+			return 2
+		}
+		if history1[i] != "" && history1[i] == history2[j] {
+			return 1
+		}
+		return 0
+	}
+
+	// lcs[i][j] is the best score aligning the first i and j layers
+	n, m := len(layers1), len(layers2)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := 1; i <= n; i++ {
+		for j := 1; j <= m; j++ {
+			best := lcs[i-1][j]
+			if lcs[i][j-1] > best {
+				best = lcs[i][j-1]
+			}
+			if s := score(i-1, j-1); s > 0 && lcs[i-1][j-1]+s > best {
+				best = lcs[i-1][j-1] + s
+			}
+			lcs[i][j] = best
+		}
+	}
+
+	// walk back from the top of the stacks, so removed layers end up before
+	// the layers inserted in their place
+	var matches []LayerMatch
+	i, j := n, m
+	for i > 0 || j > 0 {
+		switch {
+		case i > 0 && j > 0 && score(i-1, j-1) > 0 && lcs[i][j] == lcs[i-1][j-1]+score(i-1, j-1):
+			status := LayerChanged
+			if score(i-1, j-1) == 2 {
+				status = LayerUnchanged
+			}
+			matches = append(matches, LayerMatch{
+				Index1: i - 1, Index2: j - 1,
+				Digest1: layers1[i-1].Digest.String(), Digest2: layers2[j-1].Digest.String(),
+				CreatedBy1: history1[i-1], CreatedBy2: history2[j-1],
+				Status: status,
+			})
+			i--
+			j--
+		case j > 0 && (i == 0 || lcs[i][j] == lcs[i][j-1]):
+			matches = append(matches, LayerMatch{
+				Index1: -1, Index2: j - 1,
+				Digest2: layers2[j-1].Digest.String(), CreatedBy2: history2[j-1],
+				Status: LayerInserted,
+			})
+			j--
+		default:
+			matches = append(matches, LayerMatch{
+				Index1: i - 1, Index2: -1,
+				Digest1: layers1[i-1].Digest.String(), CreatedBy1: history1[i-1],
+				Status: LayerRemoved,
+			})
+			i--
+		}
+	}
+	for l, r := 0, len(matches)-1; l < r; l, r = l+1, r-1 {
+		matches[l], matches[r] = matches[r], matches[l]
+	}
+	return matches
+}
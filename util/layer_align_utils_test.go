@@ -0,0 +1,73 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+// testLayerImage builds an image whose layers have the given digests, each
+// created by the history line of the same index
+func testLayerImage(digests []string, history []string) pkgutil.Image {
+	image := pkgutil.Image{Image: &pkgutil.TestImage{Config: &v1.ConfigFile{}}}
+	config, _ := image.Image.ConfigFile()
+	for i, digest := range digests {
+		image.Layers = append(image.Layers, pkgutil.Layer{Digest: v1.Hash{Algorithm: "sha256", Hex: digest}})
+		config.History = append(config.History, v1.History{CreatedBy: history[i]})
+	}
+	return image
+}
+
+func TestAlignLayers(t *testing.T) {
+	image1 := testLayerImage(
+		[]string{"base", "apt", "app1", "old"},
+		[]string{"ADD rootfs /", "RUN apt-get install", "COPY app /app", "RUN old"},
+	)
+	image2 := testLayerImage(
+		[]string{"base", "apt", "certs", "app2"},
+		[]string{"ADD rootfs /", "RUN apt-get install", "COPY certs /etc/ssl", "COPY app /app"},
+	)
+
+	var labels, statuses []string
+	for _, match := range AlignLayers(image1, image2) {
+		labels = append(labels, match.Label())
+		statuses = append(statuses, match.Status)
+	}
+	expectedLabels := []string{"0", "1", "- -> 2", "2 -> 3", "3 -> -"}
+	expectedStatuses := []string{LayerUnchanged, LayerUnchanged, LayerInserted, LayerChanged, LayerRemoved}
+	if !reflect.DeepEqual(labels, expectedLabels) {
+		t.Errorf("\nExpected labels: %v\nGot: %v\n", expectedLabels, labels)
+	}
+	if !reflect.DeepEqual(statuses, expectedStatuses) {
+		t.Errorf("\nExpected statuses: %v\nGot: %v\n", expectedStatuses, statuses)
+	}
+
+	// a digest match wins over a history match
+	image1 = testLayerImage([]string{"a", "b"}, []string{"RUN x", "RUN x"})
+	image2 = testLayerImage([]string{"b"}, []string{"RUN x"})
+	expected := []LayerMatch{
+		{Index1: 0, Index2: -1, Digest1: "sha256:a", CreatedBy1: "RUN x", Status: LayerRemoved},
+		{Index1: 1, Index2: 0, Digest1: "sha256:b", Digest2: "sha256:b", CreatedBy1: "RUN x", CreatedBy2: "RUN x", Status: LayerUnchanged},
+	}
+	if matches := AlignLayers(image1, image2); !reflect.DeepEqual(matches, expected) {
+		t.Errorf("\nExpected: %v\nGot: %v\n", expected, matches)
+	}
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestApplyWhiteouts(t *testing.T) {
+	lowerEntries := []pkgutil.DirectoryEntry{
+		{Name: "/etc", Size: 30},
+		{Name: "/etc/group", Size: 10},
+		{Name: "/etc/passwd", Size: 20},
+		{Name: "/var", Size: 5},
+		{Name: "/var/lib", Size: 5},
+		{Name: "/var/lib/apt", Size: 5},
+		{Name: "/var/lib/apt/lists", Size: 5},
+		{Name: "/var/lib/apt-extra", Size: 1},
+	}
+	tests := []struct {
+		description string
+		whiteouts   []pkgutil.Whiteout
+		expected    []pkgutil.DirectoryEntry
+		remaining   int
+	}{
+		{
+			description: "no whiteouts",
+			remaining:   8,
+		},
+		{
+			description: "file whiteout",
+			whiteouts:   []pkgutil.Whiteout{{Path: "/etc/passwd"}},
+			expected:    []pkgutil.DirectoryEntry{{Name: "/etc/passwd", Size: 20}},
+			remaining:   7,
+		},
+		{
+			description: "directory whiteout removes its contents",
+			whiteouts:   []pkgutil.Whiteout{{Path: "/var/lib/apt"}},
+			expected: []pkgutil.DirectoryEntry{
+				{Name: "/var/lib/apt", Size: 5},
+				{Name: "/var/lib/apt/lists", Size: 5},
+			},
+			remaining: 6,
+		},
+		{
+			description: "opaque directory keeps the directory",
+			whiteouts:   []pkgutil.Whiteout{{Path: "/etc", Opaque: true}},
+			expected: []pkgutil.DirectoryEntry{
+				{Name: "/etc/group", Size: 10},
+				{Name: "/etc/passwd", Size: 20},
+			},
+			remaining: 6,
+		},
+		{
+			description: "whiteout of a path not in lower layers",
+			whiteouts:   []pkgutil.Whiteout{{Path: "/opt"}},
+			remaining:   8,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			lower := map[string]pkgutil.DirectoryEntry{}
+			for _, entry := range lowerEntries {
+				lower[entry.Name] = entry
+			}
+			actual := pkgutil.ApplyWhiteouts(lower, test.whiteouts)
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("Expected deleted entries %v, got %v", test.expected, actual)
+			}
+			if len(lower) != test.remaining {
+				t.Errorf("Expected %d entries to remain, got %d", test.remaining, len(lower))
+			}
+		})
+	}
+}
+
+// extractTestLayers builds a layer from each list of entries, and extracts
+// it to a numbered directory under parent
+func extractTestLayers(t *testing.T, parent string, layerEntries [][]pkgutil.TestTarEntry) []pkgutil.Layer {
+	var layers []pkgutil.Layer
+	for i, entries := range layerEntries {
+		layer, err := pkgutil.TestLayer(entries...)
+		if err != nil {
+			t.Fatalf("Error building layer: %s", err)
+		}
+		root := filepath.Join(parent, string(rune('0'+i)))
+		if err := os.Mkdir(root, 0755); err != nil {
+			t.Fatalf("Error creating %s: %s", root, err)
+		}
+		if err := pkgutil.GetFileSystemForLayer(layer, root, nil); err != nil {
+			t.Fatalf("Error extracting layer: %s", err)
+		}
+		whiteouts, err := pkgutil.GetWhiteoutsForLayer(root)
+		if err != nil {
+			t.Fatalf("Error reading whiteouts: %s", err)
+		}
+		digest, err := layer.Digest()
+		if err != nil {
+			t.Fatalf("Error getting layer digest: %s", err)
+		}
+		layers = append(layers, pkgutil.Layer{FSPath: root, Digest: digest, Whiteouts: whiteouts})
+	}
+	return layers
+}
+
+func TestGetLayerProvenance(t *testing.T) {
+	parent, err := ioutil.TempDir("", "provenance-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(parent)
+
+	layerEntries := [][]pkgutil.TestTarEntry{
+		{
+			{Name: "etc/", Typeflag: '5'},
+			{Name: "etc/a", Contents: "a0"},
+			{Name: "etc/b", Contents: "b0"},
+		},
+		{
+			{Name: "etc/", Typeflag: '5'},
+			{Name: "etc/a", Contents: "a1"},
+			{Name: "etc/.wh.b"},
+		},
+		{
+			{Name: "etc/", Typeflag: '5'},
+			{Name: "etc/b", Contents: "b2"},
+		},
+	}
+	layers := extractTestLayers(t, parent, layerEntries)
+	image := pkgutil.Image{
+		Layers: layers,
+		Image: &pkgutil.TestImage{
+			Config: &v1.ConfigFile{
+				History: []v1.History{
+					{CreatedBy: "ADD base /"},
+					{CreatedBy: "ENV A=1", EmptyLayer: true},
+					{CreatedBy: "RUN update a"},
+					{CreatedBy: " COPY b /etc/b "},
+				},
+			},
+		},
+	}
+
+	provenance, err := pkgutil.GetLayerProvenance(image)
+	if err != nil {
+		t.Fatalf("Error getting provenance: %s", err)
+	}
+	expected := map[string]*pkgutil.EntryProvenance{
+		"/etc":   {Layer: 2, Digest: layers[2].Digest.String(), CreatedBy: "COPY b /etc/b", Overwritten: []int{1, 2}},
+		"/etc/a": {Layer: 1, Digest: layers[1].Digest.String(), CreatedBy: "RUN update a", Overwritten: []int{1}},
+		"/etc/b": {Layer: 2, Digest: layers[2].Digest.String(), CreatedBy: "COPY b /etc/b", Deleted: []int{1}},
+	}
+	if !reflect.DeepEqual(provenance, expected) {
+		t.Errorf("\nExpected: %v\nGot: %v\n", expected, provenance)
+	}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+)
+
+// Kinds of EntryChange
+const (
+	ChangeContent = "content"
+	ChangeMode    = "mode"
+	ChangeOwner   = "owner"
+	ChangeXattrs  = "xattrs"
+	ChangeSymlink = "symlink"
+	ChangeType    = "type"
+	ChangeDevice  = "device"
+)
+
+// EntryChange describes one way in which an entry differs between two
+// directories, with its value in each of them. Content changes carry no
+// values; their sizes are in the EntryDiff.
+type EntryChange struct {
+	Kind   string
+	Before string `json:",omitempty"`
+	After  string `json:",omitempty"`
+}
+
+// getEntryChanges classifies how the entry at name differs between d1 and d2.
+// Metadata recorded at extraction is preferred over what is on disk, since
+// ownership, xattrs and special files can't always be reproduced there.
+// Ownership and xattrs are only compared when both sides recorded them.
+func getEntryChanges(d1, d2 pkgutil.Directory, name string) ([]EntryChange, error) {
+	f1path := fmt.Sprintf("%s%s", d1.Root, name)
+	f2path := fmt.Sprintf("%s%s", d2.Root, name)
+
+	m1, indexed1, err := getMetadataForEntry(d1, f1path, name)
+	if err != nil {
+		return nil, err
+	}
+	m2, indexed2, err := getMetadataForEntry(d2, f2path, name)
+	if err != nil {
+		return nil, err
+	}
+
+	type1, type2 := comparableType(m1.Type), comparableType(m2.Type)
+	if type1 != type2 {
+		return []EntryChange{{Kind: ChangeType, Before: type1, After: type2}}, nil
+	}
+
+	var changes []EntryChange
+	switch type1 {
+	case pkgutil.TypeSymlink:
+		if m1.Linkname != m2.Linkname {
+			changes = append(changes, EntryChange{Kind: ChangeSymlink, Before: m1.Linkname, After: m2.Linkname})
+		}
+	case pkgutil.TypeChar, pkgutil.TypeBlock:
+		dev1, dev2 := formatDevice(m1), formatDevice(m2)
+		if dev1 != dev2 {
+			changes = append(changes, EntryChange{Kind: ChangeDevice, Before: dev1, After: dev2})
+		}
+	case pkgutil.TypeFile:
+		same, err := sameContent(d1, d2, name)
+		if err != nil {
+			return nil, err
+		}
+		if !same {
+			changes = append(changes, EntryChange{Kind: ChangeContent})
+		}
+	}
+
+	// the permissions of a symlink are meaningless
+	if type1 != pkgutil.TypeSymlink {
+		mode1, mode2 := formatMode(m1.Mode), formatMode(m2.Mode)
+		if mode1 != mode2 {
+			changes = append(changes, EntryChange{Kind: ChangeMode, Before: mode1, After: mode2})
+		}
+	}
+
+	if indexed1 && indexed2 {
+		owner1, owner2 := fmt.Sprintf("%d:%d", m1.Uid, m1.Gid), fmt.Sprintf("%d:%d", m2.Uid, m2.Gid)
+		if owner1 != owner2 {
+			changes = append(changes, EntryChange{Kind: ChangeOwner, Before: owner1, After: owner2})
+		}
+		xattrs1, xattrs2 := formatXattrs(m1.Xattrs), formatXattrs(m2.Xattrs)
+		if xattrs1 != xattrs2 {
+			changes = append(changes, EntryChange{Kind: ChangeXattrs, Before: xattrs1, After: xattrs2})
+		}
+	}
+	return changes, nil
+}
+
+// getMetadataForEntry returns the metadata recorded for name in d.Index, or
+// else the metadata that can be read from the filesystem at path. The bool
+// reports whether the metadata came from the index.
+func getMetadataForEntry(d pkgutil.Directory, path, name string) (pkgutil.EntryMetadata, bool, error) {
+	if metadata, ok := d.Index[name]; ok {
+		return metadata, true, nil
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return pkgutil.EntryMetadata{}, false, err
+	}
+	metadata := pkgutil.EntryMetadata{Mode: info.Mode()}
+	switch {
+	case info.IsDir():
+		metadata.Type = pkgutil.TypeDir
+	case info.Mode()&os.ModeSymlink != 0:
+		metadata.Type = pkgutil.TypeSymlink
+		if metadata.Linkname, err = os.Readlink(path); err != nil {
+			return pkgutil.EntryMetadata{}, false, err
+		}
+	case info.Mode()&os.ModeNamedPipe != 0:
+		metadata.Type = pkgutil.TypeFifo
+	case info.Mode()&os.ModeCharDevice != 0:
+		metadata.Type = pkgutil.TypeChar
+	case info.Mode()&os.ModeDevice != 0:
+		metadata.Type = pkgutil.TypeBlock
+	default:
+		metadata.Type = pkgutil.TypeFile
+	}
+	return metadata, false, nil
+}
+
+// comparableType treats a hard link as the regular file it links to
+func comparableType(entryType string) string {
+	if entryType == pkgutil.TypeHardlink {
+		return pkgutil.TypeFile
+	}
+	return entryType
+}
+
+// sameContent compares the regular file name in d1 and d2 by their sha256,
+// using the hashes computed when walking the directories if there are any
+func sameContent(d1, d2 pkgutil.Directory, name string) (bool, error) {
+	f1path := fmt.Sprintf("%s%s", d1.Root, name)
+	f2path := fmt.Sprintf("%s%s", d2.Root, name)
+	// tars are only compared by size, unless they are looked into
+	if pkgutil.IsTar(f1path) && ArchiveDepth == 0 {
+		f1stat, err := os.Lstat(f1path)
+		if err != nil {
+			return false, err
+		}
+		f2stat, err := os.Lstat(f2path)
+		if err != nil {
+			return false, err
+		}
+		return f1stat.Size() == f2stat.Size(), nil
+	}
+	hash1, ok1 := d1.Hashes[name]
+	hash2, ok2 := d2.Hashes[name]
+	if ok1 && ok2 {
+		return hash1 == hash2, nil
+	}
+	return pkgutil.CheckSameFile(f1path, f2path)
+}
+
+// formatMode renders the permission bits of mode in octal, including the
+// setuid, setgid and sticky bits, e.g. 4755
+func formatMode(mode os.FileMode) string {
+	bits := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return fmt.Sprintf("%04o", bits)
+}
+
+func formatDevice(metadata pkgutil.EntryMetadata) string {
+	return fmt.Sprintf("%d,%d", metadata.Devmajor, metadata.Devminor)
+}
+
+// formatXattrs renders xattrs as a sorted list of key="value" pairs, so that
+// binary values such as security.capability stay readable
+func formatXattrs(xattrs map[string][]byte) string {
+	pairs := []string{}
+	for key, value := range xattrs {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", key, value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/sirupsen/logrus"
+)
+
+// MoveSimilarity is the percentage of lines a deleted and an added text file
+// must share to be reported as a move. Files with identical contents are
+// always reported as moves; 0 disables matching files that aren't identical.
+var MoveSimilarity int
+
+// maxSimilaritySize bounds the size of the text files compared for
+// similarity, as the comparison is quadratic in the number of lines
+const maxSimilaritySize = 1 << 20
+
+// EntryMove records a file deleted from one path and added at another.
+// Similarity is the percentage of lines they share, 100 when the contents
+// are identical.
+type EntryMove struct {
+	From       string
+	To         string
+	Size       int64
+	Similarity int
+}
+
+// moveCandidate is a deleted or added regular file that may be part of a move
+type moveCandidate struct {
+	name string
+	path string
+	size int64
+}
+
+// detectMoves pairs the deleted entries of d1 with the added entries of d2
+// that have the same contents, or similar contents if MoveSimilarity is set.
+// It returns the moves along with the deletions and additions left unpaired.
+func detectMoves(d1, d2 pkgutil.Directory, dels, adds []string) ([]EntryMove, []string, []string) {
+	delCandidates := getMoveCandidates(d1, dels)
+	addCandidates := getMoveCandidates(d2, adds)
+	if len(delCandidates) == 0 || len(addCandidates) == 0 {
+		return nil, dels, adds
+	}
+
+	moves := []EntryMove{}
+	moved := map[string]bool{}
+	added := map[string]bool{}
+
+	// only files of the same size can be identical, so only those are hashed
+	delsBySize := map[int64][]moveCandidate{}
+	for _, del := range delCandidates {
+		delsBySize[del.size] = append(delsBySize[del.size], del)
+	}
+	hashes1 := map[string]string{}
+	hashes2 := map[string]string{}
+	for _, add := range addCandidates {
+		var match *moveCandidate
+		for i, del := range delsBySize[add.size] {
+			if moved[del.name] {
+				continue
+			}
+			if !sameHash(d1, d2, del, add, hashes1, hashes2) {
+				continue
+			}
+			// prefer a file that kept its name
+			if match == nil || (path.Base(del.name) == path.Base(add.name) && path.Base(match.name) != path.Base(add.name)) {
+				match = &delsBySize[add.size][i]
+			}
+		}
+		if match != nil {
+			moved[match.name] = true
+			added[add.name] = true
+			moves = append(moves, EntryMove{From: match.name, To: add.name, Size: add.size, Similarity: 100})
+		}
+	}
+
+	if MoveSimilarity > 0 {
+		for _, move := range getSimilarMoves(delCandidates, addCandidates, moved, added) {
+			moved[move.From] = true
+			added[move.To] = true
+			moves = append(moves, move)
+		}
+	}
+
+	sort.Slice(moves, func(i, j int) bool {
+		return moves[i].From < moves[j].From
+	})
+	return moves, removeNames(dels, moved), removeNames(adds, added)
+}
+
+// getMoveCandidates returns the non-empty regular files among names
+func getMoveCandidates(d pkgutil.Directory, names []string) []moveCandidate {
+	var candidates []moveCandidate
+	for _, name := range names {
+		entryPath := fmt.Sprintf("%s%s", d.Root, name)
+		info, err := os.Lstat(entryPath)
+		if err != nil || !info.Mode().IsRegular() || info.Size() == 0 {
+			continue
+		}
+		candidates = append(candidates, moveCandidate{name: name, path: entryPath, size: info.Size()})
+	}
+	return candidates
+}
+
+// sameHash compares the sha256 of del in d1 and add in d2, hashing each file
+// at most once through the given caches
+func sameHash(d1, d2 pkgutil.Directory, del, add moveCandidate, hashes1, hashes2 map[string]string) bool {
+	hash1, err := getCachedHash(d1, del, hashes1)
+	if err != nil {
+		logrus.Errorf("Error hashing %s: %s", del.path, err)
+		return false
+	}
+	hash2, err := getCachedHash(d2, add, hashes2)
+	if err != nil {
+		logrus.Errorf("Error hashing %s: %s", add.path, err)
+		return false
+	}
+	return hash1 == hash2
+}
+
+func getCachedHash(d pkgutil.Directory, c moveCandidate, cache map[string]string) (string, error) {
+	if hash, ok := d.Hashes[c.name]; ok {
+		return hash, nil
+	}
+	if hash, ok := cache[c.name]; ok {
+		return hash, nil
+	}
+	hash, err := pkgutil.GetFileHash(c.path)
+	if err != nil {
+		return "", err
+	}
+	cache[c.name] = hash
+	return hash, nil
+}
+
+// getSimilarMoves pairs the text files left over by exact matching whose
+// lines are at least MoveSimilarity percent alike, best matches first
+func getSimilarMoves(dels, adds []moveCandidate, moved, added map[string]bool) []EntryMove {
+	delLines := getTextLines(dels, moved)
+	addLines := getTextLines(adds, added)
+
+	threshold := float64(MoveSimilarity) / 100
+	var candidates []EntryMove
+	for _, add := range adds {
+		b, ok := addLines[add.name]
+		if !ok {
+			continue
+		}
+		for _, del := range dels {
+			a, ok := delLines[del.name]
+			if !ok {
+				continue
+			}
+			// files of very different sizes can't be similar enough
+			if float64(minSize(del.size, add.size))/float64(maxSize(del.size, add.size)) < threshold {
+				continue
+			}
+			matcher := difflib.NewMatcher(a, b)
+			if matcher.RealQuickRatio() < threshold || matcher.QuickRatio() < threshold {
+				continue
+			}
+			ratio := matcher.Ratio()
+			if ratio < threshold {
+				continue
+			}
+			candidates = append(candidates, EntryMove{From: del.name, To: add.name, Size: add.size, Similarity: int(ratio * 100)})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Similarity != candidates[j].Similarity {
+			return candidates[i].Similarity > candidates[j].Similarity
+		}
+		if candidates[i].From != candidates[j].From {
+			return candidates[i].From < candidates[j].From
+		}
+		return candidates[i].To < candidates[j].To
+	})
+	var moves []EntryMove
+	pairedFrom, pairedTo := map[string]bool{}, map[string]bool{}
+	for _, candidate := range candidates {
+		if pairedFrom[candidate.From] || pairedTo[candidate.To] {
+			continue
+		}
+		pairedFrom[candidate.From] = true
+		pairedTo[candidate.To] = true
+		moves = append(moves, candidate)
+	}
+	return moves
+}
+
+// getTextLines reads the lines of the candidates that are text files, leaving
+// out those in skip
+func getTextLines(candidates []moveCandidate, skip map[string]bool) map[string][]string {
+	lines := map[string][]string{}
+	for _, c := range candidates {
+		if skip[c.name] || c.size > maxSimilaritySize {
+			continue
+		}
+		contents, err := ioutil.ReadFile(c.path)
+		if err != nil {
+			logrus.Errorf("Error reading %s: %s", c.path, err)
+			continue
+		}
+		if bytes.IndexByte(contents, 0) != -1 {
+			// binary file
+			continue
+		}
+		lines[c.name] = difflib.SplitLines(string(contents))
+	}
+	return lines
+}
+
+func removeNames(names []string, remove map[string]bool) []string {
+	if len(remove) == 0 {
+		return names
+	}
+	kept := []string{}
+	for _, name := range names {
+		if !remove[name] {
+			kept = append(kept, name)
+		}
+	}
+	return kept
+}
+
+func minSize(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxSize(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
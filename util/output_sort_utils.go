@@ -227,17 +227,26 @@ var directorySizeSort = func(e1, e2 *pkgutil.DirectoryEntry) bool {
 }
 
 func sortDirDiff(diff DirDiff) DirDiff {
-	adds, dels, mods := diff.Adds, diff.Dels, diff.Mods
+	adds, dels, mods, moves := diff.Adds, diff.Dels, diff.Mods, diff.Moves
 	if SortSize {
 		directoryBy(directorySizeSort).Sort(adds)
 		directoryBy(directorySizeSort).Sort(dels)
 		entryDiffBy(entryDiffSizeSort).Sort(mods)
+		sort.SliceStable(moves, func(i, j int) bool {
+			if moves[i].Size == moves[j].Size {
+				return moves[i].From < moves[j].From
+			}
+			return moves[i].Size > moves[j].Size
+		})
 	} else {
 		directoryBy(directoryNameSort).Sort(adds)
 		directoryBy(directoryNameSort).Sort(dels)
 		entryDiffBy(entryDiffSizeSort).Sort(mods)
+		sort.SliceStable(moves, func(i, j int) bool {
+			return moves[i].From < moves[j].From
+		})
 	}
-	return DirDiff{adds, dels, mods}
+	return DirDiff{adds, dels, mods, moves}
 }
 
 type entryDiffBy func(a, b *EntryDiff) bool
@@ -275,3 +284,27 @@ var entryDiffNameSort = func(a, b *EntryDiff) bool {
 var entryDiffSizeSort = func(a, b *EntryDiff) bool {
 	return a.Size1 > b.Size1
 }
+
+// sortDuplicateSets sorts duplicate sets by the name of their first file, or
+// by descending reclaimable space if SortSize is set
+func sortDuplicateSets(sets []pkgutil.DuplicateSet) {
+	sort.SliceStable(sets, func(i, j int) bool {
+		if SortSize && sets[i].Reclaimable != sets[j].Reclaimable {
+			return sets[i].Reclaimable > sets[j].Reclaimable
+		}
+		return sets[i].Files[0] < sets[j].Files[0]
+	})
+}
+
+func sortDuplicatesDiff(diff DuplicatesDiff) DuplicatesDiff {
+	sortDuplicateSets(diff.Adds)
+	sortDuplicateSets(diff.Dels)
+	sort.SliceStable(diff.Mods, func(i, j int) bool {
+		a, b := diff.Mods[i], diff.Mods[j]
+		if SortSize && a.Reclaimable2 != b.Reclaimable2 {
+			return a.Reclaimable2 > b.Reclaimable2
+		}
+		return a.Files2[0] < b.Files2[0]
+	})
+	return diff
+}
@@ -17,6 +17,11 @@ limitations under the License.
 package util
 
 import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
 	"code.cloudfoundry.org/bytefmt"
 	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
 )
@@ -109,19 +114,35 @@ func stringifyDirectoryEntries(entries []pkgutil.DirectoryEntry) (strEntries []S
 }
 
 type StrEntryDiff struct {
-	Name  string
-	Size1 string
-	Size2 string
+	Name    string
+	Size1   string
+	Size2   string
+	Changes []EntryChange
 }
 
 func stringifyEntryDiffs(entries []EntryDiff) (strEntries []StrEntryDiff) {
 	for _, entry := range entries {
-		strEntry := StrEntryDiff{Name: entry.Name, Size1: stringifySize(entry.Size1), Size2: stringifySize(entry.Size2)}
+		strEntry := StrEntryDiff{Name: entry.Name, Size1: stringifySize(entry.Size1), Size2: stringifySize(entry.Size2), Changes: entry.Changes}
 		strEntries = append(strEntries, strEntry)
 	}
 	return
 }
 
+type StrEntryMove struct {
+	From       string
+	To         string
+	Size       string
+	Similarity string
+}
+
+func stringifyEntryMoves(moves []EntryMove) (strMoves []StrEntryMove) {
+	for _, move := range moves {
+		strMove := StrEntryMove{From: move.From, To: move.To, Size: stringifySize(move.Size), Similarity: fmt.Sprintf("%d%%", move.Similarity)}
+		strMoves = append(strMoves, strMove)
+	}
+	return
+}
+
 type StrSizeEntry struct {
 	Name   string
 	Digest string
@@ -149,3 +170,178 @@ func stringifySizeDiffs(entries []SizeDiff) (strEntries []StrSizeDiff) {
 	}
 	return
 }
+
+type StrFileNameDiff struct {
+	Filename    string
+	Description string
+	Diff        string
+	Binary      bool
+	Size1       string
+	Size2       string
+	Digest1     string
+	Digest2     string
+}
+
+func stringifyFileNameDiffs(diffs []FileNameDiff) (strDiffs []StrFileNameDiff) {
+	for _, diff := range diffs {
+		strDiff := StrFileNameDiff{Filename: diff.Filename, Description: diff.Description, Diff: diff.Diff, Binary: diff.Binary,
+			Size1: "-", Size2: "-", Digest1: "-", Digest2: "-"}
+		// a file missing from an image has no digest
+		if diff.Digest1 != "" {
+			strDiff.Size1, strDiff.Digest1 = stringifySize(diff.Size1), diff.Digest1
+		}
+		if diff.Digest2 != "" {
+			strDiff.Size2, strDiff.Digest2 = stringifySize(diff.Size2), diff.Digest2
+		}
+		strDiffs = append(strDiffs, strDiff)
+	}
+	return
+}
+
+type StrProvenanceEntry struct {
+	Name        string
+	Size        string
+	Layer       string
+	Digest      string
+	CreatedBy   string
+	Overwritten string
+	Deleted     string
+}
+
+func stringifyLayerIndexes(layers []int) string {
+	if len(layers) == 0 {
+		return "-"
+	}
+	strLayers := make([]string, len(layers))
+	for i, layer := range layers {
+		strLayers[i] = strconv.Itoa(layer)
+	}
+	return strings.Join(strLayers, ",")
+}
+
+func stringifyProvenanceEntries(entries []pkgutil.DirectoryEntry) (strEntries []StrProvenanceEntry) {
+	for _, entry := range entries {
+		strEntry := StrProvenanceEntry{Name: entry.Name, Size: stringifySize(entry.Size), Layer: "-", Overwritten: "-", Deleted: "-"}
+		if p := entry.Provenance; p != nil {
+			strEntry.Layer = strconv.Itoa(p.Layer)
+			strEntry.Digest = p.Digest
+			strEntry.CreatedBy = p.CreatedBy
+			strEntry.Overwritten = stringifyLayerIndexes(p.Overwritten)
+			strEntry.Deleted = stringifyLayerIndexes(p.Deleted)
+		}
+		strEntries = append(strEntries, strEntry)
+	}
+	return
+}
+
+type StrDirSizeDelta struct {
+	Name     string
+	Entries  int
+	Added    string
+	Deleted  string
+	Modified string
+	Delta    string
+}
+
+// stringifySizeDelta formats a change in size with its sign, e.g. +1.5M
+func stringifySizeDelta(delta int64) string {
+	switch {
+	case delta > 0:
+		return "+" + stringifySize(delta)
+	case delta < 0:
+		return "-" + stringifySize(-delta)
+	}
+	return stringifySize(0)
+}
+
+// stringifyDirSizeDeltas flattens the tree under node to rows, naming each
+// directory by its base name, indented by its depth
+func stringifyDirSizeDeltas(node *DirSizeDelta, depth int) (strDeltas []StrDirSizeDelta) {
+	name := node.Name
+	if depth > 0 {
+		name = strings.Repeat("  ", depth) + path.Base(node.Name)
+	}
+	strDeltas = append(strDeltas, StrDirSizeDelta{
+		Name:     name,
+		Entries:  node.Entries,
+		Added:    stringifySize(node.Added),
+		Deleted:  stringifySize(node.Deleted),
+		Modified: stringifySizeDelta(node.Modified),
+		Delta:    stringifySizeDelta(node.Delta),
+	})
+	for _, child := range node.Children {
+		strDeltas = append(strDeltas, stringifyDirSizeDeltas(child, depth+1)...)
+	}
+	return
+}
+
+type StrWastedEntry struct {
+	Name      string
+	Size      string
+	Layer     string
+	CreatedBy string
+	Reason    string
+}
+
+type StrEfficiency struct {
+	TotalSize  string
+	WastedSize string
+	Score      string
+	Wasted     []StrWastedEntry
+}
+
+func stringifyEfficiency(efficiency Efficiency) StrEfficiency {
+	strEfficiency := StrEfficiency{
+		TotalSize:  stringifySize(efficiency.TotalSize),
+		WastedSize: stringifySize(efficiency.WastedSize),
+		Score:      fmt.Sprintf("%.1f%%", efficiency.Score*100),
+	}
+	for _, entry := range efficiency.Wasted {
+		reason := fmt.Sprintf("%s by layer %d", entry.Reason, entry.By)
+		if entry.Reason == WasteDuplicate {
+			reason = fmt.Sprintf("duplicate of %s from layer %d", entry.Original, entry.By)
+		}
+		strEfficiency.Wasted = append(strEfficiency.Wasted, StrWastedEntry{
+			Name:      entry.Name,
+			Size:      stringifySize(entry.Size),
+			Layer:     strconv.Itoa(entry.Layer),
+			CreatedBy: entry.CreatedBy,
+			Reason:    reason,
+		})
+	}
+	return strEfficiency
+}
+
+type StrDuplicateSet struct {
+	Size        string
+	Reclaimable string
+	Files       []string
+}
+
+func stringifyDuplicateSets(sets []pkgutil.DuplicateSet) (strSets []StrDuplicateSet) {
+	for _, set := range sets {
+		strSets = append(strSets, StrDuplicateSet{Size: stringifySize(set.Size), Reclaimable: stringifySize(set.Reclaimable), Files: set.Files})
+	}
+	return
+}
+
+type StrDuplicateSetDiff struct {
+	Size         string
+	Files1       []string
+	Files2       []string
+	Reclaimable1 string
+	Reclaimable2 string
+}
+
+func stringifyDuplicateSetDiffs(diffs []DuplicateSetDiff) (strDiffs []StrDuplicateSetDiff) {
+	for _, diff := range diffs {
+		strDiffs = append(strDiffs, StrDuplicateSetDiff{
+			Size:         stringifySize(diff.Size),
+			Files1:       diff.Files1,
+			Files2:       diff.Files2,
+			Reclaimable1: stringifySize(diff.Reclaimable1),
+			Reclaimable2: stringifySize(diff.Reclaimable2),
+		})
+	}
+	return
+}
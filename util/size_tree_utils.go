@@ -0,0 +1,148 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// SummaryDepth is how many levels of directories the summary of a file diff
+// aggregates size changes into. 0 lists the changed entries instead.
+var SummaryDepth int
+
+// DirSizeDelta aggregates the size changes of the entries under a directory
+// of an image. Added and Deleted are the bytes of the files added to and
+// deleted from the directory, including files moved in and out of it, and
+// Modified is the net change in size of its modified files.
+type DirSizeDelta struct {
+	Name     string
+	Entries  int
+	Added    int64
+	Deleted  int64
+	Modified int64
+	Delta    int64
+	Children []*DirSizeDelta `json:",omitempty"`
+}
+
+// GetDirSizeDeltas aggregates the size changes of diff per directory, down
+// to depth levels below the root. Directories are left out when their
+// contents are listed too, so that no bytes are counted twice, as are
+// entries inside archives, which are counted in the archive's size.
+func GetDirSizeDeltas(diff DirDiff, depth int) *DirSizeDelta {
+	root := &DirSizeDelta{Name: "/"}
+	nodes := map[string]*DirSizeDelta{"/": root}
+	parents := getParentNames(diff)
+	add := func(name string, added, deleted, modified int64) {
+		if parents[name] || strings.Contains(name, ArchiveSeparator+"/") {
+			return
+		}
+		for _, node := range getDirSizeNodes(root, nodes, path.Dir(name), depth) {
+			node.Entries++
+			node.Added += added
+			node.Deleted += deleted
+			node.Modified += modified
+			node.Delta += added - deleted + modified
+		}
+	}
+	for _, entry := range diff.Adds {
+		add(entry.Name, entry.Size, 0, 0)
+	}
+	for _, entry := range diff.Dels {
+		add(entry.Name, 0, entry.Size, 0)
+	}
+	for _, entry := range diff.Mods {
+		add(entry.Name, 0, 0, entry.Size2-entry.Size1)
+	}
+	for _, move := range diff.Moves {
+		add(move.From, 0, move.Size, 0)
+		add(move.To, move.Size, 0, 0)
+	}
+	sortDirSizeDeltas(root)
+	return root
+}
+
+// getParentNames returns the names of the entries of diff that are the
+// parent directory of another entry
+func getParentNames(diff DirDiff) map[string]bool {
+	parents := map[string]bool{}
+	mark := func(name string) {
+		for dir := path.Dir(name); dir != "/" && dir != "." && !parents[dir]; dir = path.Dir(dir) {
+			parents[dir] = true
+		}
+	}
+	for _, entry := range diff.Adds {
+		mark(entry.Name)
+	}
+	for _, entry := range diff.Dels {
+		mark(entry.Name)
+	}
+	for _, entry := range diff.Mods {
+		mark(entry.Name)
+	}
+	for _, move := range diff.Moves {
+		mark(move.From)
+		mark(move.To)
+	}
+	return parents
+}
+
+// getDirSizeNodes returns the nodes of the directory dir and its ancestors,
+// down to depth levels below the root, creating them as needed
+func getDirSizeNodes(root *DirSizeDelta, nodes map[string]*DirSizeDelta, dir string, depth int) []*DirSizeDelta {
+	result := []*DirSizeDelta{root}
+	parent := root
+	current := ""
+	for i, elem := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if elem == "" || i >= depth {
+			break
+		}
+		current += "/" + elem
+		node, ok := nodes[current]
+		if !ok {
+			node = &DirSizeDelta{Name: current}
+			nodes[current] = node
+			parent.Children = append(parent.Children, node)
+		}
+		result = append(result, node)
+		parent = node
+	}
+	return result
+}
+
+// sortDirSizeDeltas sorts the children of each directory by descending
+// absolute delta if SortSize is set, and by name otherwise
+func sortDirSizeDeltas(node *DirSizeDelta) {
+	sort.Slice(node.Children, func(i, j int) bool {
+		a, b := node.Children[i], node.Children[j]
+		if SortSize && abs(a.Delta) != abs(b.Delta) {
+			return abs(a.Delta) > abs(b.Delta)
+		}
+		return a.Name < b.Name
+	})
+	for _, child := range node.Children {
+		sortDirSizeDeltas(child)
+	}
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
+)
+
+func TestGetDirSizeDeltas(t *testing.T) {
+	diff := DirDiff{
+		Adds: []pkgutil.DirectoryEntry{
+			{Name: "/usr", Size: 300},
+			{Name: "/usr/lib", Size: 300},
+			{Name: "/usr/lib/libnew.so", Size: 200},
+			{Name: "/usr/lib/python/mod.py", Size: 100},
+			{Name: "/app.jar!/inner.class", Size: 50},
+		},
+		Dels: []pkgutil.DirectoryEntry{
+			{Name: "/tmp/cache", Size: 40},
+			{Name: "/README", Size: 10},
+		},
+		Mods: []EntryDiff{
+			{Name: "/usr/bin/app", Size1: 100, Size2: 80},
+			{Name: "/app.jar", Size1: 60, Size2: 110},
+		},
+		Moves: []EntryMove{
+			{From: "/tmp/data", To: "/usr/share/data", Size: 30},
+		},
+	}
+
+	expected := &DirSizeDelta{Name: "/", Entries: 8, Added: 330, Deleted: 80, Modified: 30, Delta: 280, Children: []*DirSizeDelta{
+		{Name: "/tmp", Entries: 2, Deleted: 70, Delta: -70},
+		{Name: "/usr", Entries: 4, Added: 330, Modified: -20, Delta: 310, Children: []*DirSizeDelta{
+			{Name: "/usr/bin", Entries: 1, Modified: -20, Delta: -20},
+			{Name: "/usr/lib", Entries: 2, Added: 300, Delta: 300},
+			{Name: "/usr/share", Entries: 1, Added: 30, Delta: 30},
+		}},
+	}}
+	if summary := GetDirSizeDeltas(diff, 2); !reflect.DeepEqual(summary, expected) {
+		t.Errorf("\nExpected: %v\nGot: %v\n", expected, summary)
+	}
+
+	// sorted by absolute delta, deeper directories being aggregated
+	defer func() { SortSize = false }()
+	SortSize = true
+	expected.Children = []*DirSizeDelta{
+		{Name: "/usr", Entries: 4, Added: 330, Modified: -20, Delta: 310},
+		{Name: "/tmp", Entries: 2, Deleted: 70, Delta: -70},
+	}
+	if summary := GetDirSizeDeltas(diff, 1); !reflect.DeepEqual(summary, expected) {
+		t.Errorf("\nExpected: %v\nGot: %v\n", expected, summary)
+	}
+}
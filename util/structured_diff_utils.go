@@ -0,0 +1,302 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// StructuredDiff sets whether config files in a format DiffFile can parse
+// are diffed key by key rather than line by line
+var StructuredDiff bool
+
+// Kinds of KeyChange
+const (
+	KeyAdded   = "added"
+	KeyRemoved = "removed"
+	KeyChanged = "changed"
+)
+
+// KeyChange is a change to the value at a key path of a structured config
+// file, such as spring.datasource.url. Values are left empty for the side
+// the key is missing from.
+type KeyChange struct {
+	Key    string
+	Kind   string
+	Value1 string `json:",omitempty"`
+	Value2 string `json:",omitempty"`
+}
+
+// configParser flattens the contents of a config file to the values at each
+// key path
+type configParser func(contents string) (map[string]string, error)
+
+var configParsers = map[string]configParser{
+	".json":       parseJSONConfig,
+	".yaml":       parseYAMLConfig,
+	".yml":        parseYAMLConfig,
+	".toml":       parseTOMLConfig,
+	".ini":        parseINIConfig,
+	".properties": parsePropertiesConfig,
+}
+
+// IsStructuredFile reports whether name has the extension of a config file
+// format that can be diffed key by key
+func IsStructuredFile(name string) bool {
+	_, ok := configParsers[strings.ToLower(path.Ext(name))]
+	return ok
+}
+
+// diffStructuredFiles diffs two versions of the config file filename key by
+// key. It returns false if either can't be parsed, for a text diff to be
+// taken instead.
+func diffStructuredFiles(filename, contents1, contents2 string) (*FileNameDiff, bool) {
+	parse := configParsers[strings.ToLower(path.Ext(filename))]
+	keys1, err := parse(contents1)
+	if err != nil {
+		logrus.Infof("Falling back to a text diff of %s: %s", filename, err)
+		return nil, false
+	}
+	keys2, err := parse(contents2)
+	if err != nil {
+		logrus.Infof("Falling back to a text diff of %s: %s", filename, err)
+		return nil, false
+	}
+
+	changes := diffKeys(keys1, keys2)
+	diff := &FileNameDiff{Filename: filename, Changes: changes}
+	if len(changes) == 0 {
+		diff.Description = "The files only differ in formatting or key order"
+		return diff, true
+	}
+	diff.Description = "Keys that changed:"
+	var text strings.Builder
+	for _, change := range changes {
+		switch change.Kind {
+		case KeyAdded:
+			fmt.Fprintf(&text, "+%s: %s\n", change.Key, change.Value2)
+		case KeyRemoved:
+			fmt.Fprintf(&text, "-%s: %s\n", change.Key, change.Value1)
+		default:
+			fmt.Fprintf(&text, "%s: %s -> %s\n", change.Key, change.Value1, change.Value2)
+		}
+	}
+	diff.Diff = text.String()
+	return diff, true
+}
+
+// diffKeys compares the values of two flattened config files, sorted by key
+func diffKeys(keys1, keys2 map[string]string) []KeyChange {
+	changes := []KeyChange{}
+	for key, value1 := range keys1 {
+		value2, ok := keys2[key]
+		if !ok {
+			changes = append(changes, KeyChange{Key: key, Kind: KeyRemoved, Value1: value1})
+		} else if value1 != value2 {
+			changes = append(changes, KeyChange{Key: key, Kind: KeyChanged, Value1: value1, Value2: value2})
+		}
+	}
+	for key, value2 := range keys2 {
+		if _, ok := keys1[key]; !ok {
+			changes = append(changes, KeyChange{Key: key, Kind: KeyAdded, Value2: value2})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+// flattenConfig records the leaves of a parsed config value under their key
+// paths, joining map keys with "." and indexing lists with [i]
+func flattenConfig(prefix string, value interface{}, keys map[string]string) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			keys[prefix] = "{}"
+		}
+		for key, child := range v {
+			flattenConfig(join(key), child, keys)
+		}
+	case map[interface{}]interface{}:
+		if len(v) == 0 {
+			keys[prefix] = "{}"
+		}
+		for key, child := range v {
+			flattenConfig(join(fmt.Sprint(key)), child, keys)
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			keys[prefix] = "[]"
+		}
+		for i, child := range v {
+			flattenConfig(fmt.Sprintf("%s[%d]", prefix, i), child, keys)
+		}
+	case []map[string]interface{}:
+		// TOML arrays of tables
+		if len(v) == 0 {
+			keys[prefix] = "[]"
+		}
+		for i, child := range v {
+			flattenConfig(fmt.Sprintf("%s[%d]", prefix, i), child, keys)
+		}
+	case nil:
+		keys[prefix] = "null"
+	default:
+		keys[prefix] = fmt.Sprint(v)
+	}
+}
+
+func parseJSONConfig(contents string) (map[string]string, error) {
+	decoder := json.NewDecoder(strings.NewReader(contents))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	keys := map[string]string{}
+	flattenConfig("", value, keys)
+	return keys, nil
+}
+
+// parseYAMLConfig parses a YAML stream, the documents of a stream with more
+// than one being indexed like a list
+func parseYAMLConfig(contents string) (map[string]string, error) {
+	decoder := yaml.NewDecoder(strings.NewReader(contents))
+	var docs []interface{}
+	for {
+		var doc interface{}
+		err := decoder.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	keys := map[string]string{}
+	if len(docs) == 1 {
+		flattenConfig("", docs[0], keys)
+	} else {
+		flattenConfig("", docs, keys)
+	}
+	return keys, nil
+}
+
+func parseTOMLConfig(contents string) (map[string]string, error) {
+	var value map[string]interface{}
+	if _, err := toml.Decode(contents, &value); err != nil {
+		return nil, err
+	}
+	keys := map[string]string{}
+	flattenConfig("", value, keys)
+	return keys, nil
+}
+
+// parseINIConfig reads key=value lines, keys in a [section] being prefixed
+// with its name
+func parseINIConfig(contents string) (map[string]string, error) {
+	keys := map[string]string{}
+	section := ""
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: unterminated section %s", n, line)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		sep := strings.IndexAny(line, "=:")
+		if sep == -1 {
+			return nil, fmt.Errorf("line %d: expected key=value, got %s", n, line)
+		}
+		key := strings.TrimSpace(line[:sep])
+		if section != "" {
+			key = section + "." + key
+		}
+		keys[key] = strings.TrimSpace(line[sep+1:])
+	}
+	return keys, scanner.Err()
+}
+
+// parsePropertiesConfig reads Java properties: key=value, key:value or
+// "key value" lines, with lines ending in a backslash continued on the next
+func parsePropertiesConfig(contents string) (map[string]string, error) {
+	keys := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	var logical bytes.Buffer
+	for scanner.Scan() {
+		line := strings.TrimLeft(scanner.Text(), " \t\f")
+		if logical.Len() == 0 && (line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!")) {
+			continue
+		}
+		if strings.HasSuffix(line, "\\") && !strings.HasSuffix(line, "\\\\") {
+			logical.WriteString(strings.TrimSuffix(line, "\\"))
+			continue
+		}
+		logical.WriteString(line)
+		key, value := splitProperty(logical.String())
+		keys[key] = value
+		logical.Reset()
+	}
+	if logical.Len() > 0 {
+		key, value := splitProperty(logical.String())
+		keys[key] = value
+	}
+	return keys, scanner.Err()
+}
+
+// splitProperty splits a properties line at the first unescaped "=", ":" or
+// whitespace
+func splitProperty(line string) (string, string) {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '=', ':', ' ', '\t', '\f':
+			value := strings.TrimLeft(line[i:], " \t\f")
+			if value != "" && (value[0] == '=' || value[0] == ':') {
+				value = value[1:]
+			}
+			return line[:i], strings.TrimLeft(value, " \t\f")
+		}
+	}
+	return line, ""
+}
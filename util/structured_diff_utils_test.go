@@ -0,0 +1,109 @@
+/*
+Copyright 2018 Google, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffStructuredFiles(t *testing.T) {
+	tests := []struct {
+		descrip   string
+		filename  string
+		contents1 string
+		contents2 string
+		expected  []KeyChange
+	}{
+		{
+			descrip:   "yaml",
+			filename:  "/app/application.yml",
+			contents1: "spring:\n  datasource:\n    url: a\n    user: sa\n  profiles: [dev, test]\n",
+			contents2: "spring:\n  profiles: [dev, prod]\n  datasource: {user: sa, url: b, pool: 10}\n",
+			expected: []KeyChange{
+				{Key: "spring.datasource.pool", Kind: KeyAdded, Value2: "10"},
+				{Key: "spring.datasource.url", Kind: KeyChanged, Value1: "a", Value2: "b"},
+				{Key: "spring.profiles[1]", Kind: KeyChanged, Value1: "test", Value2: "prod"},
+			},
+		},
+		{
+			descrip:   "json",
+			filename:  "/etc/config.json",
+			contents1: `{"a": 1, "b": {"c": true, "d": null}}`,
+			contents2: `{"b": {"c": false}, "a": 1}`,
+			expected: []KeyChange{
+				{Key: "b.c", Kind: KeyChanged, Value1: "true", Value2: "false"},
+				{Key: "b.d", Kind: KeyRemoved, Value1: "null"},
+			},
+		},
+		{
+			descrip:   "toml",
+			filename:  "/etc/app.toml",
+			contents1: "title = \"x\"\n[server]\nport = 80\n",
+			contents2: "[server]\nport = 8080\n[[plugins]]\nname = \"p\"\n",
+			expected: []KeyChange{
+				{Key: "plugins[0].name", Kind: KeyAdded, Value2: "p"},
+				{Key: "server.port", Kind: KeyChanged, Value1: "80", Value2: "8080"},
+				{Key: "title", Kind: KeyRemoved, Value1: "x"},
+			},
+		},
+		{
+			descrip:   "ini",
+			filename:  "/etc/php.ini",
+			contents1: "; comment\n[PHP]\nmemory_limit = 128M\n",
+			contents2: "[PHP]\nmemory_limit=256M\n",
+			expected: []KeyChange{
+				{Key: "PHP.memory_limit", Kind: KeyChanged, Value1: "128M", Value2: "256M"},
+			},
+		},
+		{
+			descrip:   "properties",
+			filename:  "/app/app.properties",
+			contents1: "# comment\nspring.datasource.url=a\nnames = x, \\\n  y\n",
+			contents2: "spring.datasource.url: b\nnames x, y\n",
+			expected: []KeyChange{
+				{Key: "spring.datasource.url", Kind: KeyChanged, Value1: "a", Value2: "b"},
+			},
+		},
+		{
+			descrip:   "reordered",
+			filename:  "/etc/config.json",
+			contents1: `{"a": 1, "b": 2}`,
+			contents2: "{\n  \"b\": 2,\n  \"a\": 1\n}\n",
+			expected:  []KeyChange{},
+		},
+	}
+	for _, test := range tests {
+		diff, ok := diffStructuredFiles(test.filename, test.contents1, test.contents2)
+		if !ok {
+			t.Errorf("%s: expected a structured diff", test.descrip)
+			continue
+		}
+		if !reflect.DeepEqual(diff.Changes, test.expected) {
+			t.Errorf("%s:\nExpected: %v\nGot: %v\n", test.descrip, test.expected, diff.Changes)
+		}
+	}
+
+	diff, ok := diffStructuredFiles("/app/application.yml", "a: 1\n", "a: b: c\n")
+	if ok {
+		t.Errorf("Expected invalid YAML to fall back to a text diff, got %v", diff)
+	}
+	diff, _ = diffStructuredFiles("/app/application.yml", "spring:\n  url: a\n", "spring:\n  url: b\n  pool: 1\n")
+	if expected := "+spring.pool: 1\nspring.url: a -> b\n"; diff.Diff != expected {
+		t.Errorf("\nExpected: %q\nGot: %q\n", expected, diff.Diff)
+	}
+}
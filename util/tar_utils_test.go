@@ -17,6 +17,12 @@ limitations under the License.
 package util
 
 import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	pkgutil "github.com/EyeCantCU/container-diff/pkg/util"
@@ -37,3 +43,295 @@ func TestIsTar(t *testing.T) {
 		}
 	}
 }
+
+func TestGetFileSystemForLayerWhiteouts(t *testing.T) {
+	layer, err := pkgutil.TestLayer(
+		pkgutil.TestTarEntry{Name: "etc/", Typeflag: tar.TypeDir},
+		pkgutil.TestTarEntry{Name: "etc/.wh.passwd"},
+		pkgutil.TestTarEntry{Name: "etc/group", Contents: "root:x:0:"},
+		pkgutil.TestTarEntry{Name: "var/lib/apt/.wh..wh..opq"},
+		pkgutil.TestTarEntry{Name: ".wh.tmp"},
+	)
+	if err != nil {
+		t.Fatalf("Error building layer: %s", err)
+	}
+	parent, err := ioutil.TempDir("", "whiteout-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(parent)
+	root := filepath.Join(parent, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatalf("Error creating %s: %s", root, err)
+	}
+
+	if err := pkgutil.GetFileSystemForLayer(layer, root, nil); err != nil {
+		t.Fatalf("Error extracting layer: %s", err)
+	}
+	dir, err := pkgutil.GetDirectory(root, true)
+	if err != nil {
+		t.Fatalf("Error reading extracted layer: %s", err)
+	}
+	expectedContent := []string{"/etc", "/etc/group"}
+	if !reflect.DeepEqual(dir.Content, expectedContent) {
+		t.Errorf("Expected extracted content %v, got %v", expectedContent, dir.Content)
+	}
+
+	whiteouts, err := pkgutil.GetWhiteoutsForLayer(root)
+	if err != nil {
+		t.Fatalf("Error reading whiteouts: %s", err)
+	}
+	expectedWhiteouts := []pkgutil.Whiteout{
+		{Path: "/etc/passwd"},
+		{Path: "/var/lib/apt", Opaque: true},
+		{Path: "/tmp"},
+	}
+	if !reflect.DeepEqual(whiteouts, expectedWhiteouts) {
+		t.Errorf("Expected whiteouts %v, got %v", expectedWhiteouts, whiteouts)
+	}
+}
+
+func TestUnpackTarStaysInRoot(t *testing.T) {
+	tests := []struct {
+		description string
+		entries     []pkgutil.TestTarEntry
+		shouldError bool
+		// files expected inside the root, relative to it
+		expected []string
+	}{
+		{
+			description: "relative path traversal",
+			entries:     []pkgutil.TestTarEntry{{Name: "../escaped", Contents: "evil"}},
+			shouldError: true,
+		},
+		{
+			description: "nested path traversal",
+			entries:     []pkgutil.TestTarEntry{{Name: "etc/../../escaped", Contents: "evil"}},
+			shouldError: true,
+		},
+		{
+			description: "absolute paths are rooted",
+			entries:     []pkgutil.TestTarEntry{{Name: "/etc/hostname", Contents: "host"}},
+			expected:    []string{"etc/hostname"},
+		},
+		{
+			description: "dot dot inside the root",
+			entries:     []pkgutil.TestTarEntry{{Name: "etc/../hostname", Contents: "host"}},
+			expected:    []string{"hostname"},
+		},
+		{
+			description: "absolute symlink to outside directory",
+			entries: []pkgutil.TestTarEntry{
+				{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "OUTSIDE"},
+				{Name: "link/escaped", Contents: "evil"},
+			},
+			expected: []string{"OUTSIDE/escaped"},
+		},
+		{
+			description: "relative symlink climbing out of the root",
+			entries: []pkgutil.TestTarEntry{
+				{Name: "dir/", Typeflag: tar.TypeDir},
+				{Name: "dir/link", Typeflag: tar.TypeSymlink, Linkname: "../../../../../../"},
+				{Name: "dir/link/escaped", Contents: "evil"},
+			},
+			expected: []string{"escaped"},
+		},
+		{
+			description: "file over dangling symlink",
+			entries: []pkgutil.TestTarEntry{
+				{Name: "dangling", Typeflag: tar.TypeSymlink, Linkname: "OUTSIDE/created"},
+				{Name: "dangling", Contents: "replaced"},
+			},
+			expected: []string{"dangling"},
+		},
+		{
+			description: "hard link traversal",
+			entries: []pkgutil.TestTarEntry{
+				{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"},
+			},
+			shouldError: true,
+		},
+		{
+			description: "hard link through symlink",
+			entries: []pkgutil.TestTarEntry{
+				{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "OUTSIDE"},
+				{Name: "target", Contents: "inside"},
+				{Name: "hardlink", Typeflag: tar.TypeLink, Linkname: "link/target"},
+			},
+			expected: []string{"target"},
+		},
+		{
+			description: "read-only directory replaced by symlink",
+			entries: []pkgutil.TestTarEntry{
+				{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0500},
+				{Name: "dir", Typeflag: tar.TypeSymlink, Linkname: "OUTSIDE"},
+				{Name: "file", Contents: "inside"},
+			},
+			expected: []string{"file"},
+		},
+		{
+			description: "read-only directory under parent replaced by symlink",
+			entries: []pkgutil.TestTarEntry{
+				{Name: "parent/", Typeflag: tar.TypeDir},
+				{Name: "parent/outside/", Typeflag: tar.TypeDir, Mode: 0500},
+				{Name: "parent", Typeflag: tar.TypeSymlink, Linkname: "OUTSIDE/.."},
+			},
+		},
+		{
+			description: "pending hard link under parent replaced by symlink",
+			entries: []pkgutil.TestTarEntry{
+				{Name: "dir/", Typeflag: tar.TypeDir},
+				{Name: "dir/x", Typeflag: tar.TypeLink, Linkname: "target"},
+				{Name: "dir", Typeflag: tar.TypeSymlink, Linkname: "OUTSIDE"},
+				{Name: "target", Contents: "inside"},
+			},
+			expected: []string{"target", "OUTSIDE/x"},
+		},
+		{
+			description: "pending hard link to target under parent replaced by symlink",
+			entries: []pkgutil.TestTarEntry{
+				{Name: "x", Typeflag: tar.TypeLink, Linkname: "dir/target"},
+				{Name: "dir/", Typeflag: tar.TypeDir},
+				{Name: "dir/target", Contents: "inside"},
+				{Name: "dir", Typeflag: tar.TypeSymlink, Linkname: "OUTSIDE"},
+			},
+		},
+		{
+			description: "symlink loop",
+			entries: []pkgutil.TestTarEntry{
+				{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "b"},
+				{Name: "b", Typeflag: tar.TypeSymlink, Linkname: "a"},
+				{Name: "a/file", Contents: "loop"},
+			},
+			shouldError: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			parent, err := ioutil.TempDir("", "unpack-test")
+			if err != nil {
+				t.Fatalf("Error creating temp dir: %s", err)
+			}
+			defer os.RemoveAll(parent)
+			root := filepath.Join(parent, "root")
+			outside := filepath.Join(parent, "outside")
+			for _, dir := range []string{root, outside} {
+				if err := os.Mkdir(dir, 0755); err != nil {
+					t.Fatalf("Error creating %s: %s", dir, err)
+				}
+			}
+
+			outsideInfo, err := os.Stat(outside)
+			if err != nil {
+				t.Fatalf("Error reading %s: %s", outside, err)
+			}
+
+			var entries []pkgutil.TestTarEntry
+			for _, entry := range test.entries {
+				entry.Linkname = strings.Replace(entry.Linkname, "OUTSIDE", outside, 1)
+				entries = append(entries, entry)
+			}
+			layer, err := pkgutil.TestLayer(entries...)
+			if err != nil {
+				t.Fatalf("Error building layer: %s", err)
+			}
+			err = pkgutil.GetFileSystemForLayer(layer, root, nil)
+			if test.shouldError && err == nil {
+				t.Errorf("Expected error extracting layer, got none")
+			} else if !test.shouldError && err != nil {
+				t.Errorf("Unexpected error extracting layer: %s", err)
+			}
+
+			written, err := ioutil.ReadDir(outside)
+			if err != nil {
+				t.Fatalf("Error reading %s: %s", outside, err)
+			}
+			if len(written) != 0 {
+				t.Errorf("Extraction wrote outside of the root: %v", written)
+			}
+			if info, err := os.Stat(outside); err != nil {
+				t.Fatalf("Error reading %s: %s", outside, err)
+			} else if info.Mode() != outsideInfo.Mode() {
+				t.Errorf("Extraction changed the mode of %s from %s to %s", outside, outsideInfo.Mode(), info.Mode())
+			}
+			if info, err := os.Stat(parent); err != nil {
+				t.Fatalf("Error reading %s: %s", parent, err)
+			} else if info.Mode().Perm() != 0700 {
+				t.Errorf("Extraction changed the mode of %s to %s", parent, info.Mode())
+			}
+			for _, name := range []string{"escaped", "etc/passwd"} {
+				if _, err := os.Lstat(filepath.Join(parent, name)); err == nil {
+					t.Errorf("Extraction wrote %s outside of the root", name)
+				}
+			}
+			for _, expected := range test.expected {
+				expected = strings.Replace(expected, "OUTSIDE", outside, 1)
+				info, err := os.Lstat(filepath.Join(root, expected))
+				if err != nil {
+					t.Errorf("Expected %s to be extracted inside the root: %s", expected, err)
+				} else if !info.Mode().IsRegular() {
+					t.Errorf("Expected %s to be a regular file, got %s", expected, info.Mode())
+				}
+			}
+		})
+	}
+}
+
+func TestGetFileSystemForLayerMetadata(t *testing.T) {
+	layer, err := pkgutil.TestLayer(
+		pkgutil.TestTarEntry{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0555},
+		pkgutil.TestTarEntry{Name: "usr/bin/sudo", Contents: "sudo", Mode: 04755, Uid: 0, Gid: 0},
+		pkgutil.TestTarEntry{Name: "usr/bin/ping", Contents: "ping", Mode: 0755, Xattrs: map[string]string{"security.capability": "\x01\x00\x00\x02"}},
+		pkgutil.TestTarEntry{Name: "home/user/file", Contents: "data", Mode: 0600, Uid: 1000, Gid: 1000},
+		pkgutil.TestTarEntry{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 1, Devminor: 3},
+		pkgutil.TestTarEntry{Name: "run/fifo", Typeflag: tar.TypeFifo, Mode: 0644},
+		pkgutil.TestTarEntry{Name: "usr/bin/sh", Typeflag: tar.TypeSymlink, Linkname: "/bin/bash", Mode: 0777},
+	)
+	if err != nil {
+		t.Fatalf("Error building layer: %s", err)
+	}
+	parent, err := ioutil.TempDir("", "metadata-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(parent)
+	root := filepath.Join(parent, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatalf("Error creating %s: %s", root, err)
+	}
+	if err := pkgutil.GetFileSystemForLayer(layer, root, nil); err != nil {
+		t.Fatalf("Error extracting layer: %s", err)
+	}
+
+	dir, err := pkgutil.GetDirectory(root, true)
+	if err != nil {
+		t.Fatalf("Error reading extracted layer: %s", err)
+	}
+	entries := map[string]pkgutil.DirectoryEntry{}
+	for _, entry := range pkgutil.GetDirectoryEntries(dir) {
+		entries[entry.Name] = entry
+	}
+	expected := map[string]pkgutil.EntryMetadata{
+		"/usr":            {Type: pkgutil.TypeDir, Mode: os.ModeDir | 0555},
+		"/usr/bin/sudo":   {Type: pkgutil.TypeFile, Mode: os.ModeSetuid | 0755},
+		"/usr/bin/ping":   {Type: pkgutil.TypeFile, Mode: 0755, Xattrs: map[string][]byte{"security.capability": []byte("\x01\x00\x00\x02")}},
+		"/home/user/file": {Type: pkgutil.TypeFile, Mode: 0600, Uid: 1000, Gid: 1000},
+		"/dev/null":       {Type: pkgutil.TypeChar, Mode: os.ModeDevice | os.ModeCharDevice | 0666, Devmajor: 1, Devminor: 3},
+		"/run/fifo":       {Type: pkgutil.TypeFifo, Mode: os.ModeNamedPipe | 0644},
+		"/usr/bin/sh":     {Type: pkgutil.TypeSymlink, Mode: os.ModeSymlink | 0777, Linkname: "/bin/bash"},
+	}
+	for name, metadata := range expected {
+		entry, ok := entries[name]
+		if !ok {
+			t.Errorf("Expected %s to be extracted", name)
+			continue
+		}
+		if entry.Metadata == nil {
+			t.Errorf("Expected metadata for %s", name)
+			continue
+		}
+		if !reflect.DeepEqual(*entry.Metadata, metadata) {
+			t.Errorf("Wrong metadata for %s\nexpected: %+v\ngot: %+v", name, metadata, *entry.Metadata)
+		}
+	}
+}
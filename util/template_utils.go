@@ -26,7 +26,13 @@ These entries have been deleted from {{.Image2}}:{{if not .Diff.Dels}} None{{els
 FILE	SIZE{{range .Diff.Dels}}{{"\n"}}{{.Name}}	{{.Size}}{{end}}{{end}}
 
 These entries have been changed between {{.Image1}} and {{.Image2}}:{{if not .Diff.Mods}} None{{else}}
-FILE	SIZE1	SIZE2{{range .Diff.Mods}}{{"\n"}}{{.Name}}	{{.Size1}}	{{.Size2}}{{end}}
+FILE	SIZE1	SIZE2{{range .Diff.Mods}}{{"\n"}}{{.Name}}	{{.Size1}}	{{.Size2}}{{range .Changes}}{{"\n"}}{{print "-"}}{{.Kind}}{{if or .Before .After}}	{{.Before}}	{{.After}}{{end}}{{end}}{{end}}{{end}}
+
+These entries have been moved between {{.Image1}} and {{.Image2}}:{{if not .Diff.Moves}} None{{else}}
+FROM	TO	SIZE	SIMILARITY{{range .Diff.Moves}}{{"\n"}}{{.From}}	{{.To}}	{{.Size}}	{{.Similarity}}{{end}}
+{{end}}{{if .Diff.Summary}}{{if not .Diff.Moves}}{{"\n"}}{{end}}
+Size changes by directory between {{.Image1}} and {{.Image2}}:
+DIRECTORY	ENTRIES	ADDED	DELETED	MODIFIED	DELTA{{range .Diff.Summary}}{{"\n"}}{{.Name}}	{{.Entries}}	{{.Added}}	{{.Deleted}}	{{.Modified}}	{{.Delta}}{{end}}
 {{end}}
 `
 const FSLayerDiffOutput = `
@@ -34,7 +40,7 @@ const FSLayerDiffOutput = `
 
 {{range $index, $diff := .Diff}}
 
-Diff for Layer {{$index}}:
+Diff for Layer {{$diff.Layer}}{{if $diff.Status}} ({{$diff.Status}}){{end}}:
 These entries have been added to {{$.Image1}}:{{if not $diff.Adds}} None{{else}}
 FILE	SIZE{{range $diff.Adds}}{{"\n"}}{{.Name}}	{{.Size}}{{end}}{{end}}
 
@@ -42,7 +48,10 @@ These entries have been deleted from {{$.Image1}}:{{if not $diff.Dels}} None{{el
 FILE	SIZE{{range $diff.Dels}}{{"\n"}}{{.Name}}	{{.Size}}{{end}}{{end}}
 
 These entries have been changed between {{$.Image1}} and {{$.Image2}}:{{if not $diff.Mods}} None{{else}}
-FILE	SIZE1	SIZE2{{range $diff.Mods}}{{"\n"}}{{.Name}}	{{.Size1}}	{{.Size2}}{{end}}
+FILE	SIZE1	SIZE2{{range $diff.Mods}}{{"\n"}}{{.Name}}	{{.Size1}}	{{.Size2}}{{range .Changes}}{{"\n"}}{{print "-"}}{{.Kind}}{{if or .Before .After}}	{{.Before}}	{{.After}}{{end}}{{end}}{{end}}{{end}}
+
+These entries have been moved between {{$.Image1}} and {{$.Image2}}:{{if not $diff.Moves}} None{{else}}
+FROM	TO	SIZE	SIMILARITY{{range $diff.Moves}}{{"\n"}}{{.From}}	{{.To}}	{{.Size}}	{{.Similarity}}{{end}}
 {{end}}
 {{end}}
 `
@@ -93,12 +102,32 @@ Image metadata differences between {{.Image1}} and {{.Image2}}:
 {{.Image2}}{{if not .Diff.Dels}} None{{else}}{{block "list2" .Diff.Dels}}{{"\n"}}{{range .}}{{print "-" .}}{{"\n"}}{{end}}{{end}}{{end}}
 `
 
-const FilenameDiffOutput = `
+const IndexDiffOutput = `
+-----{{.DiffType}}-----
+
+Platforms found only in {{.Image1}}:{{if not .Diff.Dels}} None{{else}}
+PLATFORM	DIGEST{{range .Diff.Dels}}{{"\n"}}{{.Platform}}	{{.Digest}}{{end}}{{end}}
+
+Platforms found only in {{.Image2}}:{{if not .Diff.Adds}} None{{else}}
+PLATFORM	DIGEST{{range .Diff.Adds}}{{"\n"}}{{.Platform}}	{{.Digest}}{{end}}{{end}}
+
+Manifest differences:{{if not .Diff.Mods}} None{{else}}
+PLATFORM	DIGEST1	DIGEST2{{range .Diff.Mods}}{{"\n"}}{{.Platform}}	{{.Digest1}}	{{.Digest2}}{{range .Annotations}}{{"\n"}}{{print "-"}}{{.Key}}	{{.Value1}}	{{.Value2}}{{end}}{{end}}{{end}}
+
+Index annotation differences:{{if not .Diff.Annotations}} None{{else}}
+KEY	VALUE1	VALUE2{{range .Diff.Annotations}}{{"\n"}}{{.Key}}	{{.Value1}}	{{.Value2}}{{end}}
+{{end}}
+`
+
+const FilenameDiffOutput = `{{range .Diff}}
 -----Diff of {{.Filename}}-----
-{{.Description}}
+{{.Description}}{{if .Binary}}
+
+SIZE1	SIZE2	DIGEST1	DIGEST2
+{{.Size1}}	{{.Size2}}	{{.Digest1}}	{{.Digest2}}{{end}}
 
 {{.Diff}}
-`
+{{end}}`
 
 const SizeDiffOutput = `
 -----{{.DiffType}}-----
@@ -116,12 +145,52 @@ LAYER	SIZE1	SIZE2{{range .Diff}}{{"\n"}}{{.Name}}	{{.Size1}}	{{.Size2}}{{end}}
 {{end}}
 `
 
+const EfficiencyDiffOutput = `
+-----{{.DiffType}}-----
+
+Layer efficiency of {{.Image1}} and {{.Image2}}:
+IMAGE	TOTAL	WASTED	EFFICIENCY
+{{.Image1}}	{{.Diff.Efficiency1.TotalSize}}	{{.Diff.Efficiency1.WastedSize}}	{{.Diff.Efficiency1.Score}}
+{{.Image2}}	{{.Diff.Efficiency2.TotalSize}}	{{.Diff.Efficiency2.WastedSize}}	{{.Diff.Efficiency2.Score}}
+
+Largest wasted entries in {{.Image1}}:{{if not .Diff.Efficiency1.Wasted}} None{{else}}
+FILE	SIZE	LAYER	REASON{{range .Diff.Efficiency1.Wasted}}{{"\n"}}{{.Name}}	{{.Size}}	{{.Layer}}	{{.Reason}}{{end}}{{end}}
+
+Largest wasted entries in {{.Image2}}:{{if not .Diff.Efficiency2.Wasted}} None{{else}}
+FILE	SIZE	LAYER	REASON{{range .Diff.Efficiency2.Wasted}}{{"\n"}}{{.Name}}	{{.Size}}	{{.Layer}}	{{.Reason}}{{end}}
+{{end}}
+`
+
+const DuplicatesDiffOutput = `
+-----{{.DiffType}}-----
+
+Reclaimable space in duplicate files: {{.Diff.Reclaimable1}} in {{.Image1}}, {{.Diff.Reclaimable2}} in {{.Image2}}
+
+Files only duplicated in {{.Image2}}:{{if not .Diff.Adds}} None{{else}}
+SIZE	RECLAIMABLE	FILES{{range .Diff.Adds}}{{"\n"}}{{.Size}}	{{.Reclaimable}}	{{join .Files ", "}}{{end}}{{end}}
+
+Files only duplicated in {{.Image1}}:{{if not .Diff.Dels}} None{{else}}
+SIZE	RECLAIMABLE	FILES{{range .Diff.Dels}}{{"\n"}}{{.Size}}	{{.Reclaimable}}	{{join .Files ", "}}{{end}}{{end}}
+
+Duplicate files copied to other paths between {{.Image1}} and {{.Image2}}:{{if not .Diff.Mods}} None{{else}}
+SIZE	RECLAIMABLE1	RECLAIMABLE2	FILES1	FILES2{{range .Diff.Mods}}{{"\n"}}{{.Size}}	{{.Reclaimable1}}	{{.Reclaimable2}}	{{join .Files1 ", "}}	{{join .Files2 ", "}}{{end}}
+{{end}}
+`
+
 const ListAnalysisOutput = `
 -----{{.AnalyzeType}}-----
 
 Analysis for {{.Image}}:{{if not .Analysis}} None{{else}}{{block "list" .Analysis}}{{"\n"}}{{range .}}{{print "-" .}}{{"\n"}}{{end}}{{end}}{{end}}
 `
 
+const IndexAnalysisOutput = `
+-----{{.AnalyzeType}}-----
+
+Manifests in {{.Image}}:{{if not .Analysis}} None{{else}}
+PLATFORM	DIGEST	MEDIA TYPE{{range .Analysis}}{{"\n"}}{{.Platform}}	{{.Digest}}	{{.MediaType}}{{end}}
+{{end}}
+`
+
 const FileAnalysisOutput = `
 -----{{.AnalyzeType}}-----
 
@@ -130,12 +199,23 @@ FILE	SIZE{{range .Analysis}}{{"\n"}}{{.Name}}	{{.Size}}{{end}}
 {{end}}
 `
 
+const FileProvenanceAnalysisOutput = `
+-----{{.AnalyzeType}}-----
+
+Analysis for {{.Image}}:{{if not .Analysis}} None{{else}}
+FILE	SIZE	LAYER	OVERWRITTEN IN	DELETED IN	CREATED BY{{range .Analysis}}{{"\n"}}{{.Name}}	{{.Size}}	{{.Layer}}	{{.Overwritten}}	{{.Deleted}}	{{.CreatedBy}}{{end}}
+{{end}}
+`
+
 const FileLayerAnalysisOutput = `
 -----{{.AnalyzeType}}-----
 {{range $index, $analysis := .Analysis}}
 
-Analysis for {{$.Image}} Layer {{$index}}:{{if not $analysis}} None{{else}}
-FILE	SIZE{{range $analysis}}{{"\n"}}{{.Name}}	{{.Size}}{{end}}
+Analysis for {{$.Image}} Layer {{$index}}:{{if not $analysis.Adds}} None{{else}}
+FILE	SIZE{{range $analysis.Adds}}{{"\n"}}{{.Name}}	{{.Size}}{{end}}
+{{end}}{{if $analysis.Dels}}
+Deleted by {{$.Image}} Layer {{$index}}:
+FILE	SIZE{{range $analysis.Dels}}{{"\n"}}{{.Name}}	{{.Size}}{{end}}
 {{end}}
 {{end}}
 `
@@ -156,6 +236,28 @@ LAYER	DIGEST	SIZE{{range .Analysis}}{{"\n"}}{{.Name}}	{{.Digest}}	{{.Size}}{{end
 {{end}}
 `
 
+const EfficiencyAnalysisOutput = `
+-----{{.AnalyzeType}}-----
+
+Layer efficiency of {{.Image}}:
+TOTAL	WASTED	EFFICIENCY
+{{.Analysis.TotalSize}}	{{.Analysis.WastedSize}}	{{.Analysis.Score}}
+
+Largest wasted entries:{{if not .Analysis.Wasted}} None{{else}}
+FILE	SIZE	LAYER	CREATED BY	REASON{{range .Analysis.Wasted}}{{"\n"}}{{.Name}}	{{.Size}}	{{.Layer}}	{{.CreatedBy}}	{{.Reason}}{{end}}
+{{end}}
+`
+
+const DuplicatesAnalysisOutput = `
+-----{{.AnalyzeType}}-----
+
+Duplicate files in {{.Image}}:{{if not .Analysis.Sets}} None{{else}}
+SIZE	RECLAIMABLE	FILES{{range .Analysis.Sets}}{{"\n"}}{{.Size}}	{{.Reclaimable}}	{{join .Files ", "}}{{end}}
+
+Reclaimable space: {{.Analysis.Reclaimable}}
+{{end}}
+`
+
 const MultiVersionPackageOutput = `
 -----{{.AnalyzeType}}-----
 